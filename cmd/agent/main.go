@@ -2,19 +2,38 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/syirnik/GO_Yandex/internal/logging"
+	"github.com/syirnik/GO_Yandex/internal/metrics"
+	"github.com/syirnik/GO_Yandex/internal/tracing"
+	"github.com/syirnik/GO_Yandex/pkg/calculation"
 )
 
+// Метрики агента, отдаются по GET /metrics на AGENT_STATUS_PORT
+var (
+	registry      = metrics.NewRegistry()
+	busyWorkers   = registry.NewGauge("calc_agent_busy_workers", "Workers executing a task.")
+	tasksExecuted = registry.NewCounter("calc_agent_tasks_executed_total", "Tasks executed by operation and outcome (ok or error).", "operation", "outcome")
+	fetchErrors   = registry.NewCounter("calc_agent_fetch_errors_total", "Failed task requests to the orchestrator by reason.", "reason")
+)
+
+// tracer записывает выполнение задач как этапы трассы выражения; nil — без трассировки
+var tracer *tracing.Tracer
+
 // Task представляет структуру задачи, полученной от оркестратора.
 type Task struct {
 	ID            int     `json:"id"`
@@ -26,41 +45,81 @@ type Task struct {
 	OperationTime int64   `json:"operation_time"`
 	ParentTasks   []int   `json:"parent_tasks"`
 	IsReady       bool    `json:"is_ready"`
+
+	// Точные значения аргументов в числовом режиме выражения (пустые у старых оркестраторов)
+	Mode      string `json:"mode,omitempty"`
+	Scale     int    `json:"scale,omitempty"`
+	Arg1Value string `json:"arg1_value,omitempty"`
+	Arg2Value string `json:"arg2_value,omitempty"`
+
+	RequestID   string `json:"request_id,omitempty"`  // Идентификатор запроса, создавшего выражение
+	Traceparent string `json:"traceparent,omitempty"` // Контекст трассировки выражения (W3C traceparent)
+}
+
+// requestIDHeader — заголовок, в котором агент возвращает идентификатор запроса с результатом
+const requestIDHeader = "X-Request-ID"
+
+// retryDelay — пауза перед повторным запросом задачи
+const retryDelay = 5 * time.Second
+
+// sleepContext ждет d или отмены ctx; возвращает false, если ctx отменен
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
 }
 
 // Worker представляет одну горутину, которая выполняет задачи.
-func worker(workerID int, orchestratorURL string, client *http.Client) {
-	log.Printf("Worker %d started and waiting for tasks...", workerID)
+// После отмены ctx worker перестает запрашивать задачи; уже полученная задача
+// выполняется до конца и ее результат отправляется оркестратору.
+func worker(ctx context.Context, workerID int, orchestratorURL string, client *http.Client) {
+	logger := slog.With("worker_id", workerID)
+	logger.Info("Worker started and waiting for tasks")
+	defer logger.Info("Worker stopped")
 
 	// Переменная для отслеживания времени последнего лога "No tasks available".
 	var lastLogTime time.Time
 
-	for {
-		// Запрашиваем задачу у оркестратора.
-		resp, err := client.Get(fmt.Sprintf("%s/internal/task", orchestratorURL))
+	for ctx.Err() == nil {
+		// Запрашиваем задачу у оркестратора. Запрос не прерывается по ctx: задачу,
+		// которую оркестратор уже выдал, нужно выполнить, иначе выражение не завершится.
+		resp, err := fetchTask(client, orchestratorURL, agentID)
 		if err != nil {
-			log.Printf("Worker %d failed to fetch task from %s: %v", workerID, orchestratorURL, err)
-			time.Sleep(5 * time.Second) // Ждем перед повторной попыткой.
+			logger.Warn("Failed to fetch task", "orchestrator", orchestratorURL, "error", err)
+			fetchErrors.Inc("network")
+			connectivity.record(err)
+			sleepContext(ctx, retryDelay) // Ждем перед повторной попыткой.
 			continue
 		}
 
 		// Проверяем статус ответа.
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound {
+			connectivity.record(nil)
+		} else {
+			connectivity.record(fmt.Errorf("unexpected status code %d", resp.StatusCode))
+		}
 		if resp.StatusCode == http.StatusNotFound {
 			// Логируем "No tasks available" только раз в минуту.
 			if time.Since(lastLogTime) > time.Minute {
-				log.Printf("Worker %d: No tasks available from %s. Waiting...", workerID, orchestratorURL)
+				logger.Debug("No tasks available, waiting", "orchestrator", orchestratorURL)
 				lastLogTime = time.Now()
 			}
 			resp.Body.Close()
-			time.Sleep(5 * time.Second) // Ждем перед следующим запросом.
+			sleepContext(ctx, retryDelay) // Ждем перед следующим запросом.
 			continue
 		}
 
 		// Проверяем неожиданные коды состояния.
 		if resp.StatusCode != http.StatusOK {
-			log.Printf("Worker %d received unexpected status code %d from %s", workerID, resp.StatusCode, orchestratorURL)
+			logger.Warn("Unexpected status code", "orchestrator", orchestratorURL, "status", resp.StatusCode)
+			fetchErrors.Inc("status")
 			resp.Body.Close()
-			time.Sleep(5 * time.Second)
+			sleepContext(ctx, retryDelay)
 			continue
 		}
 
@@ -69,93 +128,185 @@ func worker(workerID int, orchestratorURL string, client *http.Client) {
 			Task Task `json:"task"`
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			log.Printf("Worker %d failed to decode task from %s: %v", workerID, orchestratorURL, err)
+			logger.Warn("Failed to decode task", "orchestrator", orchestratorURL, "error", err)
+			fetchErrors.Inc("decode")
 			resp.Body.Close()
 			continue
 		}
 		resp.Body.Close() // Закрываем тело ответа, чтобы избежать утечек.
 
 		task := response.Task
-		log.Printf("Worker %d received task: ID=%d, Operation=%s, Arg1=%.2f, Arg2=%.2f, OperationTime=%dms",
-			workerID, task.ID, task.Operation, task.Arg1, task.Arg2, task.OperationTime)
+		taskLogger := logger.With("task_id", task.ID, "request_id", task.RequestID)
+		taskLogger.Debug("Received task", "operation", task.Operation, "arg1", task.Arg1, "arg2", task.Arg2,
+			"operation_time_ms", task.OperationTime)
+
+		// Выполнение задачи — этап трассы выражения, переданной оркестратором.
+		parent, _ := tracing.ParseTraceparent(task.Traceparent)
+		span := tracer.StartWithParent(parent, "ExecuteTask")
+		span.SetAttribute("task.id", task.ID)
+		span.SetAttribute("task.operation", task.Operation)
+		span.SetAttribute("worker.id", workerID)
 
 		// Выполняем задачу с учетом задержки.
+		busyWorkers.Inc()
 		time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
-		result, err := performOperation(task.Arg1, task.Arg2, task.Operation)
+		result, resultValue, err := performTask(task)
+		busyWorkers.Dec()
+		outcome := "ok"
 		if err != nil {
-			log.Printf("Worker %d failed to perform task %d: %v", workerID, task.ID, err)
-			continue
+			outcome = "error"
 		}
+		tasksExecuted.Inc(task.Operation, outcome)
+		span.SetError(err)
+		span.End()
 
-		// Подготавливаем результат для отправки.
+		// Подготавливаем результат для отправки. Ошибку вычисления (переполнение,
+		// деление на ноль) сообщаем оркестратору, чтобы выражение не ждало вечно.
 		responseData := map[string]interface{}{
 			"id":     task.ID,
 			"result": result,
 		}
+		if err != nil {
+			taskLogger.Info("Task failed", "error", err)
+			responseData["error"] = err.Error()
+		} else if resultValue != "" {
+			responseData["result_value"] = resultValue
+		}
 		jsonBody, err := json.Marshal(responseData)
 		if err != nil {
-			log.Printf("Worker %d failed to marshal response for task %d: %v", workerID, task.ID, err)
+			taskLogger.Error("Failed to marshal result", "error", err)
 			continue
 		}
 
-		// Отправляем результат обратно оркестратору.
-		resp, err = client.Post(fmt.Sprintf("%s/internal/task", orchestratorURL), "application/json", bytes.NewBuffer(jsonBody))
+		// Отправляем результат обратно оркестратору вместе с идентификатором запроса.
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/internal/task", orchestratorURL), bytes.NewBuffer(jsonBody))
 		if err != nil {
-			log.Printf("Worker %d failed to send result for task %d to %s: %v", workerID, task.ID, orchestratorURL, err)
+			taskLogger.Error("Failed to create result request", "error", err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if agentID != "" {
+			req.Header.Set(agentIDHeader, agentID)
+		}
+		if task.RequestID != "" {
+			req.Header.Set(requestIDHeader, task.RequestID)
+		}
+		tracing.Inject(span, req.Header)
+		resp, err = client.Do(req)
+		connectivity.record(err)
+		if err != nil {
+			taskLogger.Warn("Failed to send result", "orchestrator", orchestratorURL, "error", err)
 			continue
 		}
 		resp.Body.Close()
 
-		log.Printf("Worker %d successfully sent result for task %d to %s", workerID, task.ID, orchestratorURL)
+		taskLogger.Debug("Sent result", "status", resp.StatusCode)
 	}
 }
 
-// performOperation выполняет математическую операцию над аргументами.
+// fetchTask запрашивает у оркестратора следующую задачу от имени агента id
+func fetchTask(client *http.Client, orchestratorURL, id string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, orchestratorURL+"/internal/task", nil)
+	if err != nil {
+		return nil, err
+	}
+	if id != "" {
+		req.Header.Set(agentIDHeader, id)
+	}
+	return client.Do(req)
+}
+
+// performTask выполняет задачу. Если оркестратор передал точные значения аргументов,
+// операция выполняется в числовом режиме задачи и возвращается также точный результат.
+func performTask(task Task) (float64, string, error) {
+	if task.Arg1Value == "" || task.Arg2Value == "" {
+		result, err := performOperation(task.Arg1, task.Arg2, task.Operation)
+		return result, "", err
+	}
+
+	backend, err := calculation.NewBackend(calculation.Mode(task.Mode), task.Scale)
+	if err != nil {
+		return 0, "", err
+	}
+	value, err := backend.Apply(task.Operation, task.Arg1Value, task.Arg2Value)
+	if err != nil {
+		return 0, "", err
+	}
+	return backend.Float(value), value, nil
+}
+
+// performOperation выполняет операцию над аргументами без точных значений
+// в режиме float: набор операций тот же, что и у задач с точными значениями.
 func performOperation(arg1, arg2 float64, operation string) (float64, error) {
 	// Проверяем на NaN и бесконечные значения.
 	if math.IsNaN(arg1) || math.IsNaN(arg2) || math.IsInf(arg1, 0) || math.IsInf(arg2, 0) {
 		return 0, fmt.Errorf("invalid arguments: NaN or Inf")
 	}
 
-	switch operation {
-	case "+":
-		return arg1 + arg2, nil
-	case "-":
-		return arg1 - arg2, nil
-	case "*":
-		return arg1 * arg2, nil
-	case "/":
-		if arg2 == 0 {
-			return 0, fmt.Errorf("division by zero")
-		}
-		return arg1 / arg2, nil
-	default:
-		return 0, fmt.Errorf("invalid operation: %s", operation)
+	backend, err := calculation.NewBackend(calculation.ModeFloat, 0)
+	if err != nil {
+		return 0, err
+	}
+	value, err := backend.Apply(operation, strconv.FormatFloat(arg1, 'g', -1, 64), strconv.FormatFloat(arg2, 'g', -1, 64))
+	if err != nil {
+		return 0, err
+	}
+	return backend.Float(value), nil
+}
+
+// getStatusPortFromEnv получает порт сервера состояния агента (/healthz, /readyz, /metrics)
+// из AGENT_STATUS_PORT или прежней переменной AGENT_METRICS_PORT. Пустая строка — сервер не нужен.
+func getStatusPortFromEnv() (string, error) {
+	name := "AGENT_STATUS_PORT"
+	port := os.Getenv(name)
+	if port == "" {
+		name = "AGENT_METRICS_PORT"
+		port = os.Getenv(name)
+	}
+	if port == "" {
+		return "", nil
+	}
+	if value, err := strconv.Atoi(port); err != nil || value <= 0 || value > 65535 {
+		return "", fmt.Errorf("%s must be a port number, got: %s", name, port)
 	}
+	return port, nil
+}
+
+// fatal пишет ошибку в журнал и завершает агент
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func main() {
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		fatal("Error loading .env file", "error", err)
+	}
+
+	// Уровень и формат журнала задаются LOG_LEVEL и LOG_FORMAT
+	logger, err := logging.FromEnv()
+	if err != nil {
+		fatal("Logging configuration error", "error", err)
 	}
+	slog.SetDefault(logger)
 
 	// Получаем PORT
 	port := os.Getenv("PORT")
 	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+		fatal("PORT environment variable is not set")
 	}
 
 	// Получаем ORCHESTRATOR_URL и подставляем PORT
 	orchestratorURLTemplate := os.Getenv("ORCHESTRATOR_URL")
 	if orchestratorURLTemplate == "" {
-		log.Fatal("ORCHESTRATOR_URL environment variable is not set")
+		fatal("ORCHESTRATOR_URL environment variable is not set")
 	}
 	orchestratorURL := fmt.Sprintf(orchestratorURLTemplate, port)
-	log.Println("ORCHESTRATOR_URL:", orchestratorURL) // Логируем итоговый URL
+	slog.Info("Orchestrator URL", "url", orchestratorURL) // Логируем итоговый URL
 
 	if _, err := url.Parse(orchestratorURL); err != nil {
-		log.Fatalf("Invalid ORCHESTRATOR_URL: %v", err)
+		fatal("Invalid ORCHESTRATOR_URL", "error", err)
 	}
 
 	// Создаем HTTP-клиент с таймаутом.
@@ -169,20 +320,61 @@ func main() {
 		var err error
 		computingPower, err = strconv.Atoi(computingPowerStr)
 		if err != nil {
-			log.Fatalf("Invalid COMPUTING_POWER value: %v", err)
+			fatal("Invalid COMPUTING_POWER value", "error", err)
 		}
 	}
 	if computingPower <= 0 {
-		log.Fatalf("COMPUTING_POWER must be positive, got: %d", computingPower)
+		fatal("COMPUTING_POWER must be positive", "value", computingPower)
 	}
 
-	log.Printf("Agent started with computing power: %d", computingPower)
+	// Идентификатор агента для оркестратора: по нему считаются подключенные агенты
+	hostname, _ := os.Hostname()
+	agentID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	slog.Info("Agent started", "agent_id", agentID, "computing_power", computingPower)
+
+	// Трассировка выполнения задач включается переменной TRACING_EXPORTER
+	exporter, closeExporter, err := tracing.ExporterFromEnv()
+	if err != nil {
+		fatal("Tracing configuration error", "error", err)
+	}
+	tracer = tracing.NewTracer("agent", exporter)
+	defer closeExporter()
+
+	// Сервер состояния (/healthz, /readyz, /metrics) включается переменной AGENT_STATUS_PORT
+	statusPort, err := getStatusPortFromEnv()
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	var statusServer *http.Server
+	if statusPort != "" {
+		statusServer = startStatusServer(statusPort, orchestratorURL, computingPower)
+	}
+
+	// SIGINT и SIGTERM останавливают получение задач
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Запускаем пул горутин.
+	var wg sync.WaitGroup
 	for i := 0; i < computingPower; i++ {
-		go worker(i+1, orchestratorURL, client)
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			worker(ctx, workerID, orchestratorURL, client)
+		}(i + 1)
 	}
 
-	// Блокируем основной поток, чтобы программа продолжала работать.
-	select {}
+	// Ждем сигнала, затем завершения выполняемых задач.
+	<-ctx.Done()
+	slog.Info("Agent is shutting down: waiting for running tasks to finish")
+	wg.Wait()
+	if statusServer != nil {
+		statusServer.Close()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Tracing shutdown", "error", err)
+	}
+	slog.Info("Agent stopped")
 }
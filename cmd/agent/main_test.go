@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/syirnik/GO_Yandex/internal/tracing"
 )
 
 // TestPerformOperation проверяет корректность выполнения математических операций.
@@ -24,10 +33,14 @@ func TestPerformOperation(t *testing.T) {
 		{"Subtraction", 5.0, 3.0, "-", 2.0, false},
 		{"Multiplication", 4.0, 3.0, "*", 12.0, false},
 		{"Division", 6.0, 2.0, "/", 3.0, false},
+		{"Modulo", 7.0, 3.0, "%", 1.0, false},
+		{"Comparison", 2.0, 3.0, "<", 1.0, false},
+		{"Equality", 2.0, 3.0, "==", 0.0, false},
 
 		// Ошибки
 		{"Division by zero", 6.0, 0.0, "/", 0.0, true},
-		{"Invalid operation", 2.0, 3.0, "%", 0.0, true},
+		{"Modulo by zero", 6.0, 0.0, "%", 0.0, true},
+		{"Invalid operation", 2.0, 3.0, "^", 0.0, true},
 		{"NaN argument", math.NaN(), 3.0, "+", 0.0, true},
 		{"Inf argument", math.Inf(1), 3.0, "+", 0.0, true},
 	}
@@ -46,6 +59,37 @@ func TestPerformOperation(t *testing.T) {
 	}
 }
 
+// TestPerformTask проверяет выполнение задач в числовых режимах.
+func TestPerformTask(t *testing.T) {
+	tests := []struct {
+		name      string
+		task      Task
+		want      float64
+		wantValue string
+		wantErr   bool
+	}{
+		{"Legacy", Task{Arg1: 2, Arg2: 3, Operation: "+"}, 5, "", false},
+		{"Float", Task{Mode: "float64", Arg1Value: "1.5", Arg2Value: "2", Operation: "*"}, 3, "3", false},
+		{"Decimal", Task{Mode: "decimal", Scale: 2, Arg1Value: "0.1", Arg2Value: "0.2", Operation: "+"}, 0.3, "0.3", false},
+		{"DecimalRounding", Task{Mode: "decimal", Scale: 2, Arg1Value: "2", Arg2Value: "3", Operation: "/"}, 0.67, "0.67", false},
+		{"Rational", Task{Mode: "rational", Arg1Value: "1", Arg2Value: "3", Operation: "/"}, 1.0 / 3, "1/3", false},
+		{"RationalDivisionByZero", Task{Mode: "rational", Arg1Value: "1", Arg2Value: "0", Operation: "/"}, 0, "", true},
+		{"UnknownMode", Task{Mode: "complex", Arg1Value: "1", Arg2Value: "2", Operation: "+"}, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotValue, err := performTask(tt.task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("performTask() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || gotValue != tt.wantValue {
+				t.Errorf("performTask() = %v, %q, want %v, %q", got, gotValue, tt.want, tt.wantValue)
+			}
+		})
+	}
+}
+
 // TestWorkerSuccess проверяет успешное выполнение задачи.
 func TestWorkerSuccess(t *testing.T) {
 	// Создаем тестовый сервер, который возвращает задачу.
@@ -79,7 +123,7 @@ func TestWorkerSuccess(t *testing.T) {
 	// Запускаем worker в отдельной горутине.
 	done := make(chan struct{})
 	go func() {
-		worker(1, server.URL, client)
+		worker(context.Background(), 1, server.URL, client)
 		close(done)
 	}()
 
@@ -106,7 +150,7 @@ func TestWorkerNoTasks(t *testing.T) {
 	// Запускаем worker в отдельной горутине.
 	done := make(chan struct{})
 	go func() {
-		worker(1, server.URL, client)
+		worker(context.Background(), 1, server.URL, client)
 		close(done)
 	}()
 
@@ -116,3 +160,293 @@ func TestWorkerNoTasks(t *testing.T) {
 	// Завершаем тест.
 	server.Close()
 }
+
+// TestWorkerReportsError проверяет, что ошибка вычисления отправляется оркестратору.
+func TestWorkerReportsError(t *testing.T) {
+	task := Task{
+		ID:        7,
+		Operation: "+",
+		Mode:      "int",
+		Arg1Value: "9223372036854775807",
+		Arg2Value: "1",
+	}
+	posted := make(chan map[string]interface{}, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(struct {
+				Task Task `json:"task"`
+			}{Task: task})
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		select {
+		case posted <- body:
+		default:
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	failedBefore := tasksExecuted.Value("+", "error")
+	go worker(context.Background(), 1, server.URL, &http.Client{Timeout: time.Second})
+
+	select {
+	case body := <-posted:
+		if body["id"] != float64(7) || !strings.Contains(fmt.Sprint(body["error"]), "integer overflow") {
+			t.Errorf("expected overflow error for task 7, got %v", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("worker did not report the error")
+	}
+	if tasksExecuted.Value("+", "error") <= failedBefore {
+		t.Error("expected the failed task to be counted")
+	}
+}
+
+// TestWorkerFinishesTaskOnShutdown проверяет, что после отмены контекста worker
+// отправляет результат уже полученной задачи и завершается, не запрашивая новых.
+func TestWorkerFinishesTaskOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var fetches atomic.Int32
+	posted := make(chan map[string]interface{}, 1)
+	requestIDs := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fetches.Add(1)
+			cancel() // Сигнал остановки приходит сразу после выдачи задачи
+			json.NewEncoder(w).Encode(struct {
+				Task Task `json:"task"`
+			}{Task: Task{ID: 3, Arg1: 2, Arg2: 3, Operation: "*", OperationTime: 50, RequestID: "req-3"}})
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		posted <- body
+		requestIDs <- r.Header.Get(requestIDHeader)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		worker(ctx, 1, server.URL, &http.Client{Timeout: time.Second})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("worker did not stop after context cancellation")
+	}
+	select {
+	case body := <-posted:
+		if body["id"] != float64(3) || body["result"] != float64(6) {
+			t.Errorf("expected result 6 for task 3, got %v", body)
+		}
+	default:
+		t.Fatal("worker did not send the result of the running task")
+	}
+	if id := <-requestIDs; id != "req-3" {
+		t.Errorf("expected request ID req-3 with the result, got %q", id)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected 1 task request, got %d", n)
+	}
+}
+
+// TestAgentMetrics проверяет метрики агента: выполненные задачи, ошибки получения задач
+// и их вывод в формате Prometheus.
+func TestAgentMetrics(t *testing.T) {
+	executedBefore := tasksExecuted.Value("-", "ok")
+	fetchErrorsBefore := fetchErrors.Value("decode")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			cancel() // Результат получен: останавливаем worker
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if fetches.Add(1) == 1 {
+			w.Write([]byte("not json"))
+			return
+		}
+		json.NewEncoder(w).Encode(struct {
+			Task Task `json:"task"`
+		}{Task: Task{ID: 1, Arg1: 5, Arg2: 2, Operation: "-"}})
+	}))
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		worker(ctx, 1, server.URL, &http.Client{Timeout: time.Second})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("worker did not finish")
+	}
+
+	if got := tasksExecuted.Value("-", "ok") - executedBefore; got != 1 {
+		t.Errorf("expected 1 executed task, got %v", got)
+	}
+	if got := fetchErrors.Value("decode") - fetchErrorsBefore; got != 1 {
+		t.Errorf("expected 1 fetch error, got %v", got)
+	}
+	if busy := busyWorkers.Value(); busy != 0 {
+		t.Errorf("expected no busy workers, got %v", busy)
+	}
+
+	rr := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		"# TYPE calc_agent_tasks_executed_total counter",
+		`calc_agent_tasks_executed_total{operation="-",outcome="ok"}`,
+		`calc_agent_fetch_errors_total{reason="decode"} `,
+		"calc_agent_busy_workers 0",
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected %q in metrics, got:\n%s", want, rr.Body.String())
+		}
+	}
+}
+
+// spanRecorder запоминает экспортированные span'ы
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// TestWorkerTracing проверяет, что выполнение задачи продолжает трассу из traceparent
+// задачи, а span выполнения передается оркестратору в заголовке результата.
+func TestWorkerTracing(t *testing.T) {
+	recorder := &spanRecorder{}
+	tracer = tracing.NewTracer("agent", recorder)
+	defer func() { tracer = nil }()
+
+	const issued = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	traceparents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			cancel() // Результат получен: останавливаем worker
+			traceparents <- r.Header.Get(tracing.TraceparentHeader)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		json.NewEncoder(w).Encode(struct {
+			Task Task `json:"task"`
+		}{Task: Task{ID: 4, Arg1: 1, Arg2: 0, Operation: "/", Traceparent: issued}})
+	}))
+	defer server.Close()
+
+	worker(ctx, 2, server.URL, &http.Client{Timeout: time.Second})
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.spans) != 1 {
+		t.Fatalf("expected 1 span, got %+v", recorder.spans)
+	}
+	span := recorder.spans[0]
+	parent, _ := tracing.ParseTraceparent(issued)
+	if span.Name != "ExecuteTask" || span.TraceID != parent.TraceID.String() || span.ParentSpanID != parent.SpanID.String() {
+		t.Errorf("expected ExecuteTask span under the issued task context, got %+v", span)
+	}
+	if !span.Error || span.Attributes["task.id"] != 4 || span.Attributes["worker.id"] != 2 {
+		t.Errorf("expected failed span with task and worker attributes, got %+v", span)
+	}
+	posted, ok := tracing.ParseTraceparent(<-traceparents)
+	if !ok || posted.TraceID != parent.TraceID || posted.SpanID.String() != span.SpanID {
+		t.Errorf("expected result to carry the ExecuteTask span, got %v", posted)
+	}
+}
+
+// TestGetStatusPortFromEnv проверяет порт сервера состояния агента.
+func TestGetStatusPortFromEnv(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusPort  string
+		metricsPort string
+		want        string
+		wantErr     bool
+	}{
+		{"Not set", "", "", "", false},
+		{"Status port", "9100", "", "9100", false},
+		{"Metrics port fallback", "", "9200", "9200", false},
+		{"Status port wins", "9100", "9200", "9100", false},
+		{"Invalid", "abc", "", "", true},
+		{"Out of range", "70000", "", "", true},
+	}
+	defer os.Unsetenv("AGENT_STATUS_PORT")
+	defer os.Unsetenv("AGENT_METRICS_PORT")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("AGENT_STATUS_PORT", tt.statusPort)
+			os.Setenv("AGENT_METRICS_PORT", tt.metricsPort)
+			got, err := getStatusPortFromEnv()
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("expected %q (error: %v), got %q, %v", tt.want, tt.wantErr, got, err)
+			}
+		})
+	}
+}
+
+// TestStatusHandler проверяет /healthz и /readyz агента: готовность зависит от
+// последнего обращения к оркестратору, агент представляется ему заголовком X-Agent-ID.
+func TestStatusHandler(t *testing.T) {
+	agentIDs := make(chan string, 1)
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentIDs <- r.Header.Get(agentIDHeader)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer orchestrator.Close()
+	handler := statusHandler(orchestrator.URL, 3)
+
+	get := func(path string) (int, statusResponse) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var response statusResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return rr.Code, response
+	}
+
+	connectivity.record(errors.New("connection refused"))
+	if code, response := get("/readyz"); code != http.StatusServiceUnavailable || response.Orchestrator.Error != "connection refused" {
+		t.Errorf("expected 503 with the last error, got %d: %+v", code, response)
+	}
+	if code, response := get("/healthz"); code != http.StatusOK || response.Status != "ok" {
+		t.Errorf("expected healthy agent, got %d: %+v", code, response)
+	}
+
+	// Оркестратор ответил (задач нет): агент готов
+	resp, err := fetchTask(orchestrator.Client(), orchestrator.URL, "agent-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := <-agentIDs; id != "agent-test" {
+		t.Errorf("expected agent ID header, got %q", id)
+	}
+	connectivity.record(nil)
+	code, response := get("/readyz")
+	if code != http.StatusOK || !response.Orchestrator.Reachable || response.Orchestrator.LastContact == nil ||
+		response.Workers.Total != 3 || response.Orchestrator.URL != orchestrator.URL {
+		t.Errorf("expected ready agent, got %d: %+v", code, response)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// agentIDHeader — заголовок, по которому оркестратор различает агентов
+const agentIDHeader = "X-Agent-ID"
+
+// agentID — идентификатор агента (имя хоста и PID); задается в main
+var agentID string
+
+// connectivity — результат последнего обращения к оркестратору, для /readyz
+var connectivity connectionStatus
+
+// connectionStatus хранит результат последнего обращения к оркестратору
+type connectionStatus struct {
+	mu          sync.Mutex
+	lastContact time.Time // Последний успешный ответ оркестратора
+	lastError   string    // Ошибка последнего обращения; пусто, если оно успешно
+}
+
+// record запоминает результат обращения: err == nil — оркестратор ответил
+func (c *connectionStatus) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.lastError = err.Error()
+		return
+	}
+	c.lastContact = time.Now()
+	c.lastError = ""
+}
+
+// snapshot возвращает, доступен ли оркестратор, время последнего ответа и последнюю ошибку.
+// До первого обращения оркестратор считается недоступным.
+func (c *connectionStatus) snapshot() (bool, time.Time, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.lastContact.IsZero() && c.lastError == "", c.lastContact, c.lastError
+}
+
+// statusResponse — ответ /healthz и /readyz агента
+type statusResponse struct {
+	Status       string             `json:"status"` // ok или unavailable
+	Orchestrator orchestratorStatus `json:"orchestrator"`
+	Workers      workersStatus      `json:"workers"`
+}
+
+type orchestratorStatus struct {
+	URL         string     `json:"url"`
+	Reachable   bool       `json:"reachable"`
+	LastContact *time.Time `json:"last_contact,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+type workersStatus struct {
+	Total int `json:"total"`
+	Busy  int `json:"busy"`
+}
+
+// statusHandler отдает состояние агента:
+//   - GET /healthz — процесс работает (всегда 200);
+//   - GET /readyz — 200, если последнее обращение к оркестратору успешно, иначе 503;
+//   - GET /metrics — метрики агента.
+func statusHandler(orchestratorURL string, workers int) http.Handler {
+	status := func() statusResponse {
+		reachable, lastContact, lastError := connectivity.snapshot()
+		response := statusResponse{
+			Status:       "ok",
+			Orchestrator: orchestratorStatus{URL: orchestratorURL, Reachable: reachable, Error: lastError},
+			Workers:      workersStatus{Total: workers, Busy: int(busyWorkers.Value())},
+		}
+		if !lastContact.IsZero() {
+			response.Orchestrator.LastContact = &lastContact
+		}
+		if !reachable {
+			response.Status = "unavailable"
+		}
+		return response
+	}
+	write := func(w http.ResponseWriter, code int, response statusResponse) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(response)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", registry.Handler())
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		response := status()
+		response.Status = "ok" // Жизнеспособность не зависит от оркестратора
+		write(w, http.StatusOK, response)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		response := status()
+		code := http.StatusOK
+		if !response.Orchestrator.Reachable {
+			code = http.StatusServiceUnavailable
+		}
+		write(w, code, response)
+	})
+	return mux
+}
+
+// startStatusServer запускает HTTP-сервер состояния агента на порту port
+func startStatusServer(port, orchestratorURL string, workers int) *http.Server {
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           statusHandler(orchestratorURL, workers),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Status server error", "error", err)
+		}
+	}()
+	slog.Info("Status endpoints available", "addr", ":"+port, "paths", "/healthz, /readyz, /metrics")
+	return server
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/syirnik/GO_Yandex/internal/api"
 	"github.com/syirnik/GO_Yandex/internal/application"
+	"github.com/syirnik/GO_Yandex/internal/logging"
+	"github.com/syirnik/GO_Yandex/internal/metrics"
+	"github.com/syirnik/GO_Yandex/internal/tracing"
+	"github.com/syirnik/GO_Yandex/pkg/calculation"
 
 	"github.com/joho/godotenv"
 )
@@ -15,8 +24,23 @@ import (
 const (
 	defaultOperationTimeMs = 1000
 	defaultPort            = "8080"
+	defaultRateLimitRPS    = 5.0
+	defaultRateLimitBurst  = 10
+	defaultShutdownTimeout = 30 * time.Second
+	minAdminTokenLength    = 16
+
+	defaultExpressionTTL          = 24 * time.Hour
+	defaultMaxFinishedExpressions = 10000
 )
 
+// Таймауты HTTP-сервера. Запись ответа ждет дольше самого долгого ожидания задачи агентом (?wait=).
+var serverTimeouts = api.Timeouts{
+	ReadHeader: 10 * time.Second,
+	Read:       30 * time.Second,
+	Write:      60 * time.Second,
+	Idle:       120 * time.Second,
+}
+
 // checkEnvironmentVariable проверяет наличие и корректность переменной среды.
 // Возвращает ошибку, если переменная отсутствует или некорректна.
 func checkEnvironmentVariable(name string) error {
@@ -32,12 +56,22 @@ func checkEnvironmentVariable(name string) error {
 	return nil
 }
 
+// checkOptionalEnvironmentVariable проверяет необязательную переменную среды. Если переменная
+// не задана, устанавливает значение по умолчанию, чтобы предупреждение выводилось один раз при запуске.
+func checkOptionalEnvironmentVariable(name, defaultValue string) error {
+	if os.Getenv(name) == "" {
+		slog.Warn("Variable is not set, using default value", "variable", name, "default", defaultValue)
+		os.Setenv(name, defaultValue)
+	}
+	return checkEnvironmentVariable(name)
+}
+
 // getPortFromEnv получает порт из переменной среды или возвращает дефолтное значение.
 // Возвращает ошибку, если порт некорректен.
 func getPortFromEnv() (string, error) {
 	port := os.Getenv("PORT")
 	if port == "" {
-		log.Printf("Warning: PORT is not set, using default value: %s", defaultPort)
+		slog.Warn("PORT is not set, using default value", "port", defaultPort)
 		return defaultPort, nil
 	}
 
@@ -50,12 +84,142 @@ func getPortFromEnv() (string, error) {
 	return strconv.Itoa(portNum), nil
 }
 
+// getRateLimitFromEnv получает параметры ограничения частоты запросов из переменных среды.
+// RATE_LIMIT_RPS=0 отключает ограничение.
+func getRateLimitFromEnv() (float64, int, error) {
+	rps := defaultRateLimitRPS
+	if value := os.Getenv("RATE_LIMIT_RPS"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("RATE_LIMIT_RPS is not a valid number: %v", err)
+		}
+		if parsed < 0 {
+			return 0, 0, fmt.Errorf("RATE_LIMIT_RPS must not be negative, got: %s", value)
+		}
+		rps = parsed
+	}
+
+	burst := defaultRateLimitBurst
+	if value := os.Getenv("RATE_LIMIT_BURST"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, 0, fmt.Errorf("RATE_LIMIT_BURST is not a valid number: %v", err)
+		}
+		if parsed <= 0 {
+			return 0, 0, fmt.Errorf("RATE_LIMIT_BURST must be a positive number, got: %s", value)
+		}
+		burst = parsed
+	}
+
+	return rps, burst, nil
+}
+
+// getShutdownTimeoutFromEnv получает из SHUTDOWN_TIMEOUT_MS время, которое оркестратор
+// при остановке ждет вычисления принятых выражений и завершения запросов
+func getShutdownTimeoutFromEnv() (time.Duration, error) {
+	value := os.Getenv("SHUTDOWN_TIMEOUT_MS")
+	if value == "" {
+		return defaultShutdownTimeout, nil
+	}
+	ms, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("SHUTDOWN_TIMEOUT_MS is not a valid number: %v", err)
+	}
+	if ms < 0 {
+		return 0, fmt.Errorf("SHUTDOWN_TIMEOUT_MS must not be negative, got: %s", value)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// getRetentionFromEnv получает правила хранения завершенных выражений:
+//   - EXPRESSION_TTL_MS: сколько хранить выражение после завершения (мс);
+//   - MAX_FINISHED_EXPRESSIONS: сколько хранить самых новых завершенных выражений.
+//
+// Значение 0 отключает соответствующее ограничение.
+func getRetentionFromEnv() (application.Retention, error) {
+	retention := application.Retention{MaxAge: defaultExpressionTTL, MaxCount: defaultMaxFinishedExpressions}
+	if value := os.Getenv("EXPRESSION_TTL_MS"); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return application.Retention{}, fmt.Errorf("EXPRESSION_TTL_MS is not a valid number: %v", err)
+		}
+		if ms < 0 {
+			return application.Retention{}, fmt.Errorf("EXPRESSION_TTL_MS must not be negative, got: %s", value)
+		}
+		retention.MaxAge = time.Duration(ms) * time.Millisecond
+	}
+	if value := os.Getenv("MAX_FINISHED_EXPRESSIONS"); value != "" {
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return application.Retention{}, fmt.Errorf("MAX_FINISHED_EXPRESSIONS is not a valid number: %v", err)
+		}
+		if count < 0 {
+			return application.Retention{}, fmt.Errorf("MAX_FINISHED_EXPRESSIONS must not be negative, got: %s", value)
+		}
+		retention.MaxCount = count
+	}
+	return retention, nil
+}
+
+// getTLSFromEnv получает пути к сертификату и ключу из TLS_CERT_FILE и TLS_KEY_FILE.
+// Без обеих переменных сервер работает по HTTP; задать только одну из них нельзя.
+func getTLSFromEnv() (string, string, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		return "", "", fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	return certFile, keyFile, nil
+}
+
+// getCORSOriginsFromEnv получает из CORS_ALLOWED_ORIGINS (через запятую) источники,
+// которым браузер разрешает запросы к публичному API. По умолчанию — любые ("*").
+func getCORSOriginsFromEnv() []string {
+	value := os.Getenv("CORS_ALLOWED_ORIGINS")
+	if value == "" {
+		return []string{"*"}
+	}
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// getAdminTokenFromEnv получает из ADMIN_TOKEN токен API администратора.
+// Пустое значение отключает API; короткий токен легко подобрать, поэтому он запрещен.
+func getAdminTokenFromEnv() (string, error) {
+	token := strings.TrimSpace(os.Getenv("ADMIN_TOKEN"))
+	if token != "" && len(token) < minAdminTokenLength {
+		return "", fmt.Errorf("ADMIN_TOKEN must be at least %d characters long", minAdminTokenLength)
+	}
+	return token, nil
+}
+
+// fatal пишет ошибку в журнал и завершает оркестратор
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
 func main() {
 	// Загружаем переменные из .env файла, если он существует.
-	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
-		log.Fatalf("Error: Failed to load .env file: %v", err)
-	} else if os.IsNotExist(err) {
-		log.Println("Warning: .env file not found, using system environment variables")
+	envErr := godotenv.Load()
+	if envErr != nil && !os.IsNotExist(envErr) {
+		fatal("Failed to load .env file", "error", envErr)
+	}
+
+	// Уровень и формат журнала задаются LOG_LEVEL и LOG_FORMAT. Журнал пакета
+	// calculation подключается отдельно: подробности разбора пишутся на уровне Debug.
+	logger, err := logging.FromEnv()
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	slog.SetDefault(logger)
+	calculation.SetLogger(logger.With("component", "calculation"))
+	if os.IsNotExist(envErr) {
+		slog.Warn(".env file not found, using system environment variables")
 	}
 
 	// Проверяем переменные среды для времени выполнения операций.
@@ -67,26 +231,118 @@ func main() {
 	}
 	for _, env := range envVars {
 		if err := checkEnvironmentVariable(env); err != nil {
-			log.Fatalf("Error: Configuration error: %v", err)
+			fatal("Configuration error", "error", err)
 		}
 	}
+	// Время сравнений появилось позже остальных и необязательно
+	if err := checkOptionalEnvironmentVariable("TIME_COMPARISONS_MS", "1000"); err != nil {
+		fatal("Configuration error", "error", err)
+	}
 
 	// Получаем порт из переменной среды.
 	port, err := getPortFromEnv()
 	if err != nil {
-		log.Fatalf("Error: Configuration error: %v", err)
+		fatal("Configuration error", "error", err)
 	}
 
+	certFile, keyFile, err := getTLSFromEnv()
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+
+	adminToken, err := getAdminTokenFromEnv()
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+
+	// Трассировка выражений включается переменной TRACING_EXPORTER
+	exporter, closeExporter, err := tracing.ExporterFromEnv()
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	tracer := tracing.NewTracer("orchestrator", exporter)
+	defer closeExporter()
+
 	// Создаем приложение и сервер.
 	app := application.New()
-	server := api.NewServer(app)
+	origins := getCORSOriginsFromEnv()
+	slog.Info("CORS allowed origins", "origins", strings.Join(origins, ", "))
+	registry := metrics.NewRegistry()
+	opts := []api.Option{
+		api.WithAddr(":" + port),
+		api.WithTimeouts(serverTimeouts),
+		api.WithMetrics(registry),
+		api.WithMiddleware(api.RequestID, api.HTTPMetrics(registry), api.AccessLog, api.Recover, api.CORS(origins)),
+	}
+	if tracer != nil {
+		opts = append(opts, api.WithTracer(tracer))
+		slog.Info("Tracing enabled", "exporter", os.Getenv("TRACING_EXPORTER"))
+	}
+	if adminToken != "" {
+		opts = append(opts, api.WithAdminToken(adminToken))
+		slog.Info("Admin API enabled", "path", "/api/v1/admin/")
+	}
+	if certFile != "" {
+		opts = append(opts, api.WithTLS(certFile, keyFile))
+		slog.Info("TLS enabled", "certificate", certFile)
+	}
+	server := api.NewServer(app, opts...)
+
+	// Настраиваем ограничение частоты отправки выражений.
+	rps, burst, err := getRateLimitFromEnv()
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	if rps > 0 {
+		server.Handler.Limiter = api.NewRateLimiter(rps, burst)
+		slog.Info("Rate limit enabled", "rps", rps, "burst", burst)
+	}
+
+	shutdownTimeout, err := getShutdownTimeoutFromEnv()
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+
+	retention, err := getRetentionFromEnv()
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+
+	// SIGINT и SIGTERM запускают плавную остановку
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Завершенные выражения удаляются в фоне, чтобы память не росла без ограничений
+	if retention.MaxAge > 0 || retention.MaxCount > 0 {
+		go app.RunJanitor(ctx, retention)
+		slog.Info("Expression retention enabled", "max_age", retention.MaxAge, "max_count", retention.MaxCount)
+	}
 
 	// Запускаем сервер на указанном порте.
-	log.Printf("Starting server on port :%s...", port)
-	if err := server.Start(":" + port); err != nil {
-		log.Fatalf("Error: Failed to start server: %v", err)
+	slog.Info("Starting server", "addr", ":"+port)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			fatal("Failed to start server", "error", err)
+		}
+		return
+	case <-ctx.Done():
 	}
 
-	// Логируем успешный запуск сервера.
-	log.Printf("Server started on port :%s", port)
+	slog.Info("Shutting down: waiting for pending expressions and requests", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Server shutdown", "error", err)
+	}
+	<-serverErr
+	if err := tracer.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Tracing shutdown", "error", err)
+	}
+	slog.Info("Server stopped")
 }
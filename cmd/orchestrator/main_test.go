@@ -2,7 +2,11 @@ package main
 
 import (
 	"os"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/syirnik/GO_Yandex/internal/application"
 )
 
 // TestCheckEnvironmentVariable проверяет функцию checkEnvironmentVariable.
@@ -48,6 +52,41 @@ func TestCheckEnvironmentVariable(t *testing.T) {
 	}
 }
 
+// TestCheckOptionalEnvironmentVariable проверяет функцию checkOptionalEnvironmentVariable.
+func TestCheckOptionalEnvironmentVariable(t *testing.T) {
+	tests := []struct {
+		name        string
+		envVarValue string
+		setEnv      bool
+		want        string
+		wantErr     bool
+	}{
+		{"Variable set", "250", true, "250", false},
+		{"Variable not set", "", false, "1000", false},
+		{"Non-numeric value", "abc", true, "abc", true},
+		{"Zero value", "0", true, "0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setEnv {
+				os.Setenv("TIME_TEST_MS", tt.envVarValue)
+			} else {
+				os.Unsetenv("TIME_TEST_MS")
+			}
+			defer os.Unsetenv("TIME_TEST_MS")
+
+			err := checkOptionalEnvironmentVariable("TIME_TEST_MS", "1000")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkOptionalEnvironmentVariable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := os.Getenv("TIME_TEST_MS"); got != tt.want {
+				t.Errorf("TIME_TEST_MS = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 // TestGetPortFromEnv проверяет функцию getPortFromEnv.
 func TestGetPortFromEnv(t *testing.T) {
 	tests := []struct {
@@ -93,3 +132,215 @@ func TestGetPortFromEnv(t *testing.T) {
 		})
 	}
 }
+
+// TestGetRateLimitFromEnv проверяет функцию getRateLimitFromEnv.
+func TestGetRateLimitFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		rps       string
+		burst     string
+		wantRPS   float64
+		wantBurst int
+		wantErr   bool
+	}{
+		// Успешные случаи
+		{"Defaults", "", "", defaultRateLimitRPS, defaultRateLimitBurst, false},
+		{"Custom values", "2.5", "20", 2.5, 20, false},
+		{"Disabled", "0", "", 0, defaultRateLimitBurst, false},
+
+		// Ошибки
+		{"Non-numeric rps", "abc", "", 0, 0, true},
+		{"Negative rps", "-1", "", 0, 0, true},
+		{"Zero burst", "1", "0", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("RATE_LIMIT_RPS", tt.rps)
+			os.Setenv("RATE_LIMIT_BURST", tt.burst)
+
+			gotRPS, gotBurst, err := getRateLimitFromEnv()
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getRateLimitFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (gotRPS != tt.wantRPS || gotBurst != tt.wantBurst) {
+				t.Errorf("getRateLimitFromEnv() = %v, %v, want %v, %v", gotRPS, gotBurst, tt.wantRPS, tt.wantBurst)
+			}
+
+			os.Unsetenv("RATE_LIMIT_RPS")
+			os.Unsetenv("RATE_LIMIT_BURST")
+		})
+	}
+}
+
+func TestGetShutdownTimeoutFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		// Успешные случаи
+		{"Default", "", defaultShutdownTimeout, false},
+		{"Custom value", "1500", 1500 * time.Millisecond, false},
+		{"Immediate", "0", 0, false},
+
+		// Ошибки
+		{"Non-numeric", "abc", 0, true},
+		{"Negative", "-1", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("SHUTDOWN_TIMEOUT_MS", tt.value)
+
+			got, err := getShutdownTimeoutFromEnv()
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getShutdownTimeoutFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("getShutdownTimeoutFromEnv() = %v, want %v", got, tt.want)
+			}
+
+			os.Unsetenv("SHUTDOWN_TIMEOUT_MS")
+		})
+	}
+}
+
+func TestGetRetentionFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      string
+		maxCount string
+		want     application.Retention
+		wantErr  bool
+	}{
+		// Успешные случаи
+		{"Default", "", "", application.Retention{MaxAge: defaultExpressionTTL, MaxCount: defaultMaxFinishedExpressions}, false},
+		{"Custom", "60000", "50", application.Retention{MaxAge: time.Minute, MaxCount: 50}, false},
+		{"Disabled", "0", "0", application.Retention{}, false},
+
+		// Ошибки
+		{"Invalid TTL", "day", "", application.Retention{}, true},
+		{"Negative TTL", "-1", "", application.Retention{}, true},
+		{"Invalid count", "", "many", application.Retention{}, true},
+		{"Negative count", "", "-5", application.Retention{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("EXPRESSION_TTL_MS", tt.ttl)
+			os.Setenv("MAX_FINISHED_EXPRESSIONS", tt.maxCount)
+
+			got, err := getRetentionFromEnv()
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getRetentionFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getRetentionFromEnv() = %+v, want %+v", got, tt.want)
+			}
+
+			os.Unsetenv("EXPRESSION_TTL_MS")
+			os.Unsetenv("MAX_FINISHED_EXPRESSIONS")
+		})
+	}
+}
+
+func TestGetTLSFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		// Успешные случаи
+		{"Disabled", "", "", false},
+		{"Enabled", "cert.pem", "key.pem", false},
+
+		// Ошибки
+		{"Missing key", "cert.pem", "", true},
+		{"Missing certificate", "", "key.pem", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("TLS_CERT_FILE", tt.certFile)
+			os.Setenv("TLS_KEY_FILE", tt.keyFile)
+
+			certFile, keyFile, err := getTLSFromEnv()
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getTLSFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (certFile != tt.certFile || keyFile != tt.keyFile) {
+				t.Errorf("getTLSFromEnv() = %q, %q, want %q, %q", certFile, keyFile, tt.certFile, tt.keyFile)
+			}
+
+			os.Unsetenv("TLS_CERT_FILE")
+			os.Unsetenv("TLS_KEY_FILE")
+		})
+	}
+}
+
+func TestGetAdminTokenFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		// Успешные случаи
+		{"Disabled", "", "", false},
+		{"Enabled", "0123456789abcdef", "0123456789abcdef", false},
+		{"Trimmed", " 0123456789abcdef\n", "0123456789abcdef", false},
+
+		// Ошибки
+		{"Too short", "secret", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("ADMIN_TOKEN", tt.value)
+
+			token, err := getAdminTokenFromEnv()
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getAdminTokenFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if token != tt.want {
+				t.Errorf("getAdminTokenFromEnv() = %q, want %q", token, tt.want)
+			}
+
+			os.Unsetenv("ADMIN_TOKEN")
+		})
+	}
+}
+
+func TestGetCORSOriginsFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"Default", "", []string{"*"}},
+		{"Single origin", "http://localhost:8081", []string{"http://localhost:8081"}},
+		{"Several origins", " http://a.example , http://b.example,", []string{"http://a.example", "http://b.example"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("CORS_ALLOWED_ORIGINS", tt.value)
+
+			got := getCORSOriginsFromEnv()
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getCORSOriginsFromEnv() = %v, want %v", got, tt.want)
+			}
+
+			os.Unsetenv("CORS_ALLOWED_ORIGINS")
+		})
+	}
+}
@@ -27,7 +27,7 @@ async function sendExpression() {
             await checkResult(data.id);
         } else {
             // Вывод ошибки в отдельное поле
-            errorField.innerText = data.error || "Неизвестная ошибка";
+            errorField.innerText = formatError(data);
             resultField.innerText = "—"; // Сбрасываем поле результата
         }
     } catch (error) {
@@ -36,6 +36,17 @@ async function sendExpression() {
     }
 }
 
+// Функция для форматирования ошибки API: {code, message, position, details}
+function formatError(data) {
+    if (!data || !data.message) {
+        return "Неизвестная ошибка";
+    }
+    if (data.position !== undefined) {
+        return `${data.message} (позиция ${data.position + 1})`;
+    }
+    return data.message;
+}
+
 // Функция для получения результата с повторными запросами
 async function checkResult(exprID) {
     const resultField = document.getElementById("result");
@@ -62,3 +73,71 @@ async function checkResult(exprID) {
     errorField.innerText = "Ошибка: результат не получен";
     resultField.innerText = "—"; // Сбрасываем поле результата
 }
+
+// Проверка выражения при вводе с подсветкой ошибок
+let validationTimer = null;
+
+function scheduleValidation() {
+    clearTimeout(validationTimer);
+    validationTimer = setTimeout(validateExpression, 300); // Ждём паузу в наборе
+}
+
+async function validateExpression() {
+    const expression = document.getElementById("expression").value;
+    const diagnosticsField = document.getElementById("diagnostics");
+
+    if (!expression) {
+        diagnosticsField.replaceChildren();
+        return;
+    }
+
+    try {
+        const response = await fetch("http://localhost:8080/api/v1/validate", {
+            method: "POST",
+            headers: {
+                "Content-Type": "application/json"
+            },
+            body: JSON.stringify({ expression: expression })
+        });
+        if (!response.ok) {
+            return;
+        }
+
+        const data = await response.json();
+        renderDiagnostics(diagnosticsField, expression, data.diagnostics || []);
+    } catch (error) {
+        // Подсветка необязательна: ошибки сети не показываем
+    }
+}
+
+// Выводит выражение, подчёркивая символы в диапазонах [start, end) диагностик
+function renderDiagnostics(field, expression, diagnostics) {
+    field.replaceChildren();
+    if (diagnostics.length === 0) {
+        return;
+    }
+
+    const chars = Array.from(expression);
+    const messages = chars.map(() => []);
+    for (const diag of diagnostics) {
+        for (let i = diag.start; i < Math.max(diag.end, diag.start + 1) && i < chars.length; i++) {
+            messages[i].push(diag.message);
+        }
+    }
+
+    chars.forEach((char, i) => {
+        if (messages[i].length === 0) {
+            field.appendChild(document.createTextNode(char));
+            return;
+        }
+        const mark = document.createElement("span");
+        mark.className = "diag-mark";
+        mark.title = messages[i].join("; ");
+        mark.textContent = char;
+        field.appendChild(mark);
+    });
+
+    const list = document.createElement("div");
+    list.textContent = diagnostics.map(d => `${d.start + 1}: ${d.message}`).join("\n");
+    field.appendChild(list);
+}
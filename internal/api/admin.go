@@ -0,0 +1,194 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminRoutes — маршруты администратора: просмотр очередей и ручное вмешательство.
+// Каждый обработчик требует токен администратора.
+func adminRoutes(h *Handler, token string) []route {
+	auth := requireAdmin(token)
+	return []route{
+		{"/api/v1/admin/tasks", map[string]http.HandlerFunc{
+			http.MethodGet: auth(h.HandleAdminTasks),
+		}},
+		{"/api/v1/admin/tasks/{id}/requeue", map[string]http.HandlerFunc{
+			http.MethodPost: auth(h.HandleRequeueTask),
+		}},
+		{"/api/v1/admin/expressions", map[string]http.HandlerFunc{
+			http.MethodDelete: auth(h.HandlePurgeExpressions),
+		}},
+		{"/api/v1/admin/expressions/{id}/fail", map[string]http.HandlerFunc{
+			http.MethodPost: auth(h.HandleFailExpression),
+		}},
+		{"/api/v1/admin/dispatch", map[string]http.HandlerFunc{
+			http.MethodGet: auth(h.HandleDispatch),
+		}},
+		{"/api/v1/admin/dispatch/pause", map[string]http.HandlerFunc{
+			http.MethodPost: auth(h.HandlePauseDispatch),
+		}},
+		{"/api/v1/admin/dispatch/resume", map[string]http.HandlerFunc{
+			http.MethodPost: auth(h.HandleResumeDispatch),
+		}},
+	}
+}
+
+// requireAdmin пропускает только запросы с заголовком Authorization: Bearer <token>.
+// Без токена отвечает 401, с неверным токеном — 403.
+func requireAdmin(token string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || given == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				sendError(w, http.StatusUnauthorized, CodeUnauthorized, "Admin token is required")
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				requestLogger(r).Warn("Invalid admin token", "client", clientKey(r), "path", r.URL.Path)
+				sendError(w, http.StatusForbidden, CodeForbidden, "Invalid admin token")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// HandleAdminTasks возвращает готовые, зависимые и выданные агентам задачи
+// невыполненных выражений
+func (h *Handler) HandleAdminTasks(w http.ResponseWriter, r *http.Request) {
+	response := ResponseAdminTasks{Paused: h.App.DispatchPaused(), Tasks: []AdminTaskResponse{}}
+	for _, info := range h.App.ListTasks() {
+		task := AdminTaskResponse{
+			ID:           info.Task.ID,
+			State:        info.State,
+			Operation:    info.Task.Operation,
+			Arg1Value:    info.Task.Arg1Value,
+			Arg2Value:    info.Task.Arg2Value,
+			ParentTasks:  info.Task.ParentTasks,
+			ExpressionID: info.ExpressionID,
+			Expression:   info.Expression,
+			RequestID:    info.Task.RequestID,
+		}
+		if !info.IssuedAt.IsZero() {
+			issuedAt := info.IssuedAt
+			task.IssuedAt = &issuedAt
+		}
+		response.Tasks = append(response.Tasks, task)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		requestLogger(r).Warn("Failed to encode response", "error", err)
+	}
+}
+
+// HandleRequeueTask возвращает в очередь задачу, выданную агенту, но не выполненную
+func (h *Handler) HandleRequeueTask(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r)
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.Debug("Invalid ID format", "id", idStr)
+		sendError(w, http.StatusBadRequest, CodeInvalidID, "Invalid ID format")
+		return
+	}
+
+	if err := h.App.RequeueTask(id); err != nil {
+		logger.Info("Task not requeued", "task_id", id, "error", err)
+		sendErrorFrom(w, err)
+		return
+	}
+	logger.Info("Task requeued by administrator", "task_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleFailExpression принудительно завершает невычисленное выражение ошибкой.
+// Причину можно передать в теле запроса: {"reason": "..."}.
+func (h *Handler) HandleFailExpression(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r)
+	defer r.Body.Close()
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.Debug("Invalid ID format", "id", idStr)
+		sendError(w, http.StatusBadRequest, CodeInvalidID, "Invalid ID format")
+		return
+	}
+
+	// Тело необязательно
+	var req RequestFailExpression
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		logger.Debug("Invalid request body", "error", err)
+		sendError(w, http.StatusUnprocessableEntity, CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+
+	if err := h.App.FailExpression(id, req.Reason); err != nil {
+		logger.Info("Expression not failed", "expression_id", id, "error", err)
+		sendErrorFrom(w, err)
+		return
+	}
+	logger.Info("Expression failed by administrator", "expression_id", id, "reason", req.Reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlePurgeExpressions удаляет выражения, завершенные раньше older_than назад
+// (например, ?older_than=24h; без параметра — все завершенные)
+func (h *Handler) HandlePurgeExpressions(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r)
+
+	var olderThan time.Duration
+	if value := r.URL.Query().Get("older_than"); value != "" {
+		var err error
+		olderThan, err = time.ParseDuration(value)
+		if err != nil || olderThan < 0 {
+			logger.Debug("Invalid older_than", "older_than", value)
+			sendError(w, http.StatusBadRequest, CodeInvalidDuration, "Invalid older_than duration")
+			return
+		}
+	}
+
+	purged := h.App.PurgeExpressions(olderThan)
+	logger.Info("Expressions purged by administrator", "purged", purged, "older_than", olderThan)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ResponsePurge{Purged: purged}); err != nil {
+		logger.Warn("Failed to encode response", "error", err)
+	}
+}
+
+// HandleDispatch сообщает, приостановлена ли выдача задач
+func (h *Handler) HandleDispatch(w http.ResponseWriter, r *http.Request) {
+	h.sendDispatch(w, r)
+}
+
+// HandlePauseDispatch приостанавливает выдачу задач агентам
+func (h *Handler) HandlePauseDispatch(w http.ResponseWriter, r *http.Request) {
+	h.App.PauseDispatch()
+	requestLogger(r).Info("Task dispatch paused by administrator")
+	h.sendDispatch(w, r)
+}
+
+// HandleResumeDispatch возобновляет выдачу задач агентам
+func (h *Handler) HandleResumeDispatch(w http.ResponseWriter, r *http.Request) {
+	h.App.ResumeDispatch()
+	requestLogger(r).Info("Task dispatch resumed by administrator")
+	h.sendDispatch(w, r)
+}
+
+// sendDispatch отправляет состояние выдачи задач
+func (h *Handler) sendDispatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ResponseDispatch{Paused: h.App.DispatchPaused()}); err != nil {
+		requestLogger(r).Warn("Failed to encode response", "error", err)
+	}
+}
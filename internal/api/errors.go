@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/syirnik/GO_Yandex/internal/application"
+	"github.com/syirnik/GO_Yandex/pkg/calculation"
+)
+
+// Машиночитаемые коды ошибок API
+const (
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeNotFound              = "not_found"
+	CodeInvalidRequestBody    = "invalid_request_body"
+	CodeRequestTooLarge       = "request_too_large"
+	CodeInvalidID             = "invalid_id"
+	CodeMissingTaskID         = "missing_task_id"
+	CodeNoTasks               = "no_tasks"
+	CodeShuttingDown          = "shutting_down"
+	CodeInvalidWait           = "invalid_wait"
+	CodeInvalidTimeout        = "invalid_timeout"
+	CodeRateLimited           = "rate_limited"
+	CodeTooManyPending        = "too_many_pending_expressions"
+	CodeExpressionNotFound    = "expression_not_found"
+	CodeExpressionEvicted     = "expression_evicted"
+	CodeTaskNotFound          = "task_not_found"
+	CodeResultNotReady        = "result_not_ready"
+	CodeEmptyExpression       = "empty_expression"
+	CodeInvalidCharacter      = "invalid_character"
+	CodeMismatchedParens      = "mismatched_parentheses"
+	CodeUnmatchedConditional  = "unmatched_conditional"
+	CodeInsufficientOperands  = "insufficient_operands"
+	CodeMalformedNumber       = "malformed_number"
+	CodeUnexpectedToken       = "unexpected_token"
+	CodeUnknownIdentifier     = "unknown_identifier"
+	CodeDivisionByZero        = "division_by_zero"
+	CodeInvalidExpression     = "invalid_expression"
+	CodeExpressionTooLong     = "expression_too_long"
+	CodeTooManyTokens         = "too_many_tokens"
+	CodeNestingTooDeep        = "nesting_too_deep"
+	CodeTooManyTasks          = "too_many_tasks"
+	CodeUnknownMode           = "unknown_mode"
+	CodeInvalidScale          = "invalid_scale"
+	CodeInvalidValue          = "invalid_value"
+	CodeFractionalNumber      = "fractional_number"
+	CodeIntegerOverflow       = "integer_overflow"
+	CodeExpressionFailed      = "expression_failed"
+	CodeExpressionTimeout     = "expression_timeout"
+	CodeExpressionNotPending  = "expression_not_pending"
+	CodeTaskNotInFlight       = "task_not_in_flight"
+	CodeInvalidDuration       = "invalid_duration"
+	CodeInvalidIdempotencyKey = "invalid_idempotency_key"
+	CodeIdempotencyConflict   = "idempotency_conflict"
+	CodeUnauthorized          = "unauthorized"
+	CodeForbidden             = "forbidden"
+	CodeInternal              = "internal_error"
+)
+
+// errorMapping связывает типизированную ошибку с HTTP-статусом и кодом
+type errorMapping struct {
+	err    error
+	status int
+	code   string
+}
+
+// errorMappings проверяются по порядку через errors.Is
+var errorMappings = []errorMapping{
+	{calculation.ErrExpressionTooLong, http.StatusRequestEntityTooLarge, CodeExpressionTooLong},
+	{calculation.ErrTooManyTokens, http.StatusUnprocessableEntity, CodeTooManyTokens},
+	{calculation.ErrNestingTooDeep, http.StatusUnprocessableEntity, CodeNestingTooDeep},
+	{calculation.ErrTooManyTasks, http.StatusUnprocessableEntity, CodeTooManyTasks},
+	{calculation.ErrEmptyExpression, http.StatusUnprocessableEntity, CodeEmptyExpression},
+	{calculation.ErrInvalidCharacter, http.StatusUnprocessableEntity, CodeInvalidCharacter},
+	{calculation.ErrMismatchedParentheses, http.StatusUnprocessableEntity, CodeMismatchedParens},
+	{calculation.ErrUnmatchedConditional, http.StatusUnprocessableEntity, CodeUnmatchedConditional},
+	{calculation.ErrInsufficientOperands, http.StatusUnprocessableEntity, CodeInsufficientOperands},
+	{calculation.ErrMalformedNumber, http.StatusUnprocessableEntity, CodeMalformedNumber},
+	{calculation.ErrUnexpectedToken, http.StatusUnprocessableEntity, CodeUnexpectedToken},
+	{calculation.ErrUnknownIdentifier, http.StatusUnprocessableEntity, CodeUnknownIdentifier},
+	{calculation.ErrDivisionByZero, http.StatusUnprocessableEntity, CodeDivisionByZero},
+	{calculation.ErrInvalidExpression, http.StatusUnprocessableEntity, CodeInvalidExpression},
+	{calculation.ErrUnknownMode, http.StatusUnprocessableEntity, CodeUnknownMode},
+	{calculation.ErrInvalidScale, http.StatusUnprocessableEntity, CodeInvalidScale},
+	{calculation.ErrInvalidValue, http.StatusUnprocessableEntity, CodeInvalidValue},
+	{calculation.ErrFractionalNumber, http.StatusUnprocessableEntity, CodeFractionalNumber},
+	{calculation.ErrIntegerOverflow, http.StatusUnprocessableEntity, CodeIntegerOverflow},
+	{application.ErrExpressionFailed, http.StatusUnprocessableEntity, CodeExpressionFailed},
+	{application.ErrExpressionTimeout, http.StatusUnprocessableEntity, CodeExpressionTimeout},
+	{application.ErrTooManyPendingExpressions, http.StatusTooManyRequests, CodeTooManyPending},
+	{application.ErrExpressionNotFound, http.StatusNotFound, CodeExpressionNotFound},
+	{application.ErrExpressionEvicted, http.StatusGone, CodeExpressionEvicted},
+	{application.ErrTaskNotFound, http.StatusNotFound, CodeTaskNotFound},
+	{application.ErrResultNotReady, http.StatusNotFound, CodeResultNotReady},
+	{application.ErrExpressionNotPending, http.StatusConflict, CodeExpressionNotPending},
+	{application.ErrTaskNotInFlight, http.StatusConflict, CodeTaskNotInFlight},
+	{application.ErrIdempotencyConflict, http.StatusConflict, CodeIdempotencyConflict},
+}
+
+// newErrorResponse строит тело ответа по ошибке: код и статус определяются типом ошибки,
+// позиция и детали — полями SyntaxError и LimitError
+func newErrorResponse(err error) (int, ErrorResponse) {
+	status, code := http.StatusInternalServerError, CodeInternal
+	for _, mapping := range errorMappings {
+		if errors.Is(err, mapping.err) {
+			status, code = mapping.status, mapping.code
+			break
+		}
+	}
+
+	response := ErrorResponse{Code: code, Message: err.Error()}
+
+	var syntaxErr *calculation.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		pos := syntaxErr.Pos
+		response.Position = &pos
+		response.Details = map[string]interface{}{"token": syntaxErr.Token}
+	}
+
+	var limitErr *calculation.LimitError
+	if errors.As(err, &limitErr) {
+		response.Details = map[string]interface{}{"limit": limitErr.Limit, "actual": limitErr.Actual}
+	}
+
+	if status == http.StatusInternalServerError {
+		// Не раскрываем клиенту детали внутренних ошибок
+		response.Message = "Internal server error"
+	}
+	return status, response
+}
+
+// writeError отправляет клиенту ошибку в едином формате
+func writeError(w http.ResponseWriter, statusCode int, response ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Warn("Failed to encode error response", "error", err)
+	}
+}
+
+// sendError отправляет ошибку с заданным статусом и кодом
+func sendError(w http.ResponseWriter, statusCode int, code, message string) {
+	writeError(w, statusCode, ErrorResponse{Code: code, Message: message})
+}
+
+// sendErrorFrom отправляет типизированную ошибку приложения или пакета calculation
+func sendErrorFrom(w http.ResponseWriter, err error) {
+	status, response := newErrorResponse(err)
+	writeError(w, status, response)
+}
+
+// sendTooManyRequests отправляет ответ 429 с заголовком Retry-After
+func sendTooManyRequests(w http.ResponseWriter, code, message string, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+	sendError(w, http.StatusTooManyRequests, code, message)
+}
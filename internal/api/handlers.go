@@ -1,108 +1,330 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/syirnik/GO_Yandex/internal/application"
+	"github.com/syirnik/GO_Yandex/internal/tracing"
 	"github.com/syirnik/GO_Yandex/pkg/calculation"
 )
 
+const (
+	// pendingQuotaRetryAfter — рекомендуемая пауза перед повтором при превышении квоты выражений
+	pendingQuotaRetryAfter = 5 * time.Second
+	// requestBodyOverhead — запас на JSON-обертку выражения при ограничении размера тела запроса
+	requestBodyOverhead = 1024
+	// maxTaskWait — наибольшее время ожидания задачи агентом в GET /internal/task?wait=
+	maxTaskWait = 30 * time.Second
+)
+
+// Заголовки повторной отправки выражения
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"     // Ключ запроса, одинаковый для всех его повторов
+	IdempotentReplayedHeader = "Idempotent-Replayed" // true, если ответ относится к выражению, созданному раньше
+)
+
 // Handler содержит ссылку на приложение
 type Handler struct {
-	App *application.Application
+	App     *application.Application
+	Limiter *RateLimiter    // Ограничитель частоты отправки выражений (nil — без ограничений)
+	Tracer  *tracing.Tracer // Трассировка выражений (nil — без трассировки)
+
+	listening   atomic.Bool   // Сервер слушает порт: готов принимать запросы (/readyz)
+	draining    atomic.Bool   // Сервер останавливается: новые выражения не принимаются
+	agents      *agentTracker // Агенты, недавно обращавшиеся за задачами
+	stopWaiting chan struct{} // Закрывается при остановке: агенты перестают ждать задачи
+	stopOnce    sync.Once
 }
 
 // NewHandler создает новый обработчик
 func NewHandler(app *application.Application) *Handler {
-	return &Handler{App: app}
+	return &Handler{App: app, stopWaiting: make(chan struct{}), agents: newAgentTracker()}
 }
 
-// CORS Middleware
-func enableCORS(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
+// StartDraining прекращает прием новых выражений; задачи принятых выражений выдаются как обычно
+func (h *Handler) StartDraining() {
+	h.draining.Store(true)
 }
 
-// Функция для отправки JSON-ошибки клиенту
-func sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+// StopWaiting завершает ожидание задач агентами (GET /internal/task?wait=)
+func (h *Handler) StopWaiting() {
+	h.stopOnce.Do(func() { close(h.stopWaiting) })
 }
 
 // HandleCalculate обрабатывает добавление выражения
 func (h *Handler) HandleCalculate(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w, r) // Разрешаем CORS
+	logger := requestLogger(r)
+	defer r.Body.Close()
 
-	log.Printf("HandleCalculate: Received request")
+	// Корень трассы — выражение; прием запроса — его первый этап. Корневой span
+	// завершает приложение вместе с выражением, а до передачи ему — обработчик.
+	root := h.Tracer.StartWithParent(tracing.SpanContext{}, "expression")
+	ctx, span := h.Tracer.Start(tracing.ContextWithSpanContext(r.Context(), root.Context()), "HandleCalculate")
+	span.SetAttribute("request.id", RequestIDFrom(r.Context()))
+	defer span.End()
+	rootPassed := false
+	defer func() {
+		if !rootPassed {
+			root.End()
+		}
+	}()
 
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusNoContent)
+	if h.draining.Load() {
+		logger.Info("Server is shutting down, expression rejected")
+		sendError(w, http.StatusServiceUnavailable, CodeShuttingDown, "Server is shutting down")
 		return
 	}
 
-	if r.Method != http.MethodPost {
-		log.Printf("HandleCalculate: Invalid method %s", r.Method)
-		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	client := clientKey(r)
+	limits := h.App.Limits()
+	if limits.MaxBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(limits.MaxBytes+requestBodyOverhead))
 	}
 
-	defer r.Body.Close()
-
+	// Лимит запросов расходует каждый запрос, кроме повтора с Idempotency-Key:
+	// повтор не создает выражение, а ошибочные запросы лимит не обходят
 	var req RequestAddExpression
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("HandleCalculate: Error decoding request body: %v", err)
-		sendErrorResponse(w, "Invalid request body", http.StatusUnprocessableEntity)
+		logger.Debug("Invalid request body", "error", err)
+		if !h.allow(w, r, client) {
+			return
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendError(w, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "Request body is too large")
+			return
+		}
+		sendError(w, http.StatusUnprocessableEntity, CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if idempotencyKey != "" && !validRequestID(idempotencyKey) {
+		logger.Debug("Invalid idempotency key")
+		if h.allow(w, r, client) {
+			sendError(w, http.StatusBadRequest, CodeInvalidIdempotencyKey, "Idempotency-Key must be 1-128 visible ASCII characters")
+		}
+		return
+	}
+	keyOpts := application.ExpressionOptions{Owner: client, IdempotencyKey: idempotencyKey, RequestHash: requestHash(req)}
+	if idempotencyKey != "" {
+		exprID, replayed, err := h.App.ReplayExpression(keyOpts)
+		if err != nil {
+			logger.Info("Expression rejected", "error", err)
+			if h.allow(w, r, client) {
+				sendErrorFrom(w, err)
+			}
+			return
+		}
+		if replayed {
+			sendAddedExpression(w, exprID, false)
+			return
+		}
+	}
+
+	if !h.allow(w, r, client) {
+		return
+	}
+
+	if err := calculation.ValidateExpressionWithLimits(req.Expression, limits); err != nil {
+		logger.Debug("Invalid expression", "error", err)
+		sendErrorFrom(w, err)
+		return
+	}
+
+	mode, err := calculation.ParseMode(req.Mode)
+	if err != nil {
+		logger.Debug("Invalid mode", "error", err)
+		sendErrorFrom(w, err)
 		return
 	}
 
-	if err := calculation.ValidateExpression(req.Expression); err != nil {
-		log.Printf("HandleCalculate: Invalid expression: %v", err)
-		sendErrorResponse(w, err.Error(), http.StatusUnprocessableEntity)
+	if req.TimeoutMs < 0 {
+		logger.Debug("Invalid timeout", "timeout_ms", req.TimeoutMs)
+		sendError(w, http.StatusUnprocessableEntity, CodeInvalidTimeout, "timeout_ms must not be negative")
 		return
 	}
+	var deadline time.Time
+	if req.TimeoutMs > 0 {
+		deadline = time.Now().Add(time.Duration(req.TimeoutMs) * time.Millisecond)
+	}
 
-	exprID, err := h.App.ParseExpression(req.Expression)
+	exprID, created, err := h.App.SubmitExpression(ctx, req.Expression, application.ExpressionOptions{
+		Owner:    client,
+		Optimize: req.Optimize,
+		Mode:     mode,
+		Scale:    req.Scale,
+		Deadline: deadline,
+
+		RequestID:      RequestIDFrom(r.Context()),
+		IdempotencyKey: idempotencyKey,
+		RequestHash:    keyOpts.RequestHash,
+		Span:           root,
+	})
+	rootPassed = true
 	if err != nil {
-		log.Printf("HandleCalculate: Error parsing expression: %v", err)
-		sendErrorResponse(w, err.Error(), http.StatusUnprocessableEntity)
+		span.SetError(err)
+		logger.Info("Expression rejected", "error", err)
+		if r.Context().Err() != nil {
+			return // Клиент отключился, отвечать некому
+		}
+		if errors.Is(err, application.ErrTooManyPendingExpressions) {
+			sendTooManyRequests(w, CodeTooManyPending, err.Error(), pendingQuotaRetryAfter)
+			return
+		}
+		sendErrorFrom(w, err)
 		return
 	}
 
-	log.Printf("HandleCalculate: Added expression with ID: %d", exprID)
+	sendAddedExpression(w, exprID, created)
+}
+
+// allow списывает токен клиента из лимита запросов. При превышении лимита
+// отвечает 429 и возвращает false.
+func (h *Handler) allow(w http.ResponseWriter, r *http.Request, client string) bool {
+	if h.Limiter == nil {
+		return true
+	}
+	allowed, wait := h.Limiter.Allow(client)
+	if !allowed {
+		requestLogger(r).Info("Rate limit exceeded", "client", client)
+		sendTooManyRequests(w, CodeRateLimited, "Rate limit exceeded", wait)
+	}
+	return allowed
+}
 
-	w.WriteHeader(http.StatusCreated)
+// sendAddedExpression отправляет ID принятого выражения: 201 для нового выражения,
+// 200 с заголовком Idempotent-Replayed для выражения, созданного первым запросом с тем же ключом
+func sendAddedExpression(w http.ResponseWriter, exprID int, created bool) {
+	status := http.StatusCreated
+	if !created {
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(ResponseAddExpression{ID: exprID})
 }
 
-// HandleGetTask обрабатывает запрос на получение следующей задачи агентом
+// requestHash возвращает отпечаток тела запроса на вычисление: повтор с тем же
+// ключом должен совпадать с первым запросом по всем полям
+func requestHash(req RequestAddExpression) string {
+	body, _ := json.Marshal(req)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// HandleValidate проверяет выражение и возвращает все найденные проблемы с их позициями
+func (h *Handler) HandleValidate(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r)
+	defer r.Body.Close()
+
+	limits := h.App.Limits()
+	if limits.MaxBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(limits.MaxBytes+requestBodyOverhead))
+	}
+
+	var req RequestAddExpression
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Debug("Invalid request body", "error", err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendError(w, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "Request body is too large")
+			return
+		}
+		sendError(w, http.StatusUnprocessableEntity, CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+
+	// Ограничения размера проверяем до посимвольного разбора
+	if err := calculation.CheckLimits(req.Expression, limits); err != nil {
+		sendErrorFrom(w, err)
+		return
+	}
+
+	response := ResponseValidateExpression{Diagnostics: []DiagnosticResponse{}}
+	for _, diag := range calculation.Diagnose(req.Expression) {
+		response.Diagnostics = append(response.Diagnostics, DiagnosticResponse{
+			Code:    diag.Code,
+			Message: diag.Message,
+			Start:   diag.Start,
+			End:     diag.End,
+		})
+	}
+	response.Valid = len(response.Diagnostics) == 0
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Warn("Failed to encode response", "error", err)
+	}
+}
+
+// HandleGetTask обрабатывает запрос на получение следующей задачи агентом.
+// С параметром wait (например, ?wait=5s) при пустой очереди ждет появления задачи.
 func (h *Handler) HandleGetTask(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r)
+	h.agents.seen(agentID(r), time.Now())
+
+	var wait time.Duration
+	if value := r.URL.Query().Get("wait"); value != "" {
+		var err error
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			logger.Debug("Invalid wait", "wait", value)
+			sendError(w, http.StatusBadRequest, CodeInvalidWait, "Invalid wait duration")
+			return
+		}
+		wait = min(wait, maxTaskWait)
+	}
+
 	// Получаем следующую задачу из очереди
-	task, err := h.App.GetNextTask()
+	var task *application.Task
+	var err error
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		go func() {
+			select {
+			case <-h.stopWaiting:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		task, err = h.App.GetNextTaskContext(ctx)
+		cancel()
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			err = nil // Задача не появилась за время ожидания
+		case r.Context().Err() != nil:
+			logger.Debug("Agent disconnected while waiting for a task")
+			return
+		case err != nil:
+			logger.Info("Server is shutting down, waiting stopped")
+			sendError(w, http.StatusServiceUnavailable, CodeShuttingDown, "Server is shutting down")
+			return
+		}
+	} else {
+		task, err = h.App.GetNextTask()
+	}
 	if err != nil {
-		log.Printf("HandleGetTask: Error retrieving task: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		logger.Error("Failed to retrieve task", "error", err)
+		sendErrorFrom(w, err)
 		return
 	}
 
 	// Если задач нет, возвращаем 404
 	if task == nil {
-		w.WriteHeader(http.StatusNotFound)
+		sendError(w, http.StatusNotFound, CodeNoTasks, "No tasks available")
 		return
 	}
 
-	log.Printf("HandleGetTask: Returning task ID: %d", task.ID)
-
 	// Формируем ответ
 	response := ResponseGetTask{
 		Task: TaskResponse{
@@ -111,6 +333,12 @@ func (h *Handler) HandleGetTask(w http.ResponseWriter, r *http.Request) {
 			Arg2:          task.Arg2,
 			Operation:     task.Operation,
 			OperationTime: task.OperationTime,
+			Mode:          string(task.Mode),
+			Scale:         task.Scale,
+			Arg1Value:     task.Arg1Value,
+			Arg2Value:     task.Arg2Value,
+			RequestID:     task.RequestID,
+			Traceparent:   task.Traceparent,
 		},
 	}
 
@@ -118,15 +346,15 @@ func (h *Handler) HandleGetTask(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("HandleGetTask: Error encoding response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		logger.Warn("Failed to encode response", "task_id", task.ID, "error", err)
 		return
 	}
 }
 
 // HandlePostTask принимает результат выполнения задачи от агента
 func (h *Handler) HandlePostTask(w http.ResponseWriter, r *http.Request) {
-	log.Printf("HandlePostTask: Received request")
+	logger := requestLogger(r)
+	h.agents.seen(agentID(r), time.Now())
 
 	// Закрываем тело запроса при выходе из функции
 	defer r.Body.Close()
@@ -134,128 +362,106 @@ func (h *Handler) HandlePostTask(w http.ResponseWriter, r *http.Request) {
 	// Декодируем тело запроса
 	var req RequestPostTask
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("HandlePostTask: Error decoding request body: %v", err)
-		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
+		logger.Debug("Invalid request body", "error", err)
+		sendError(w, http.StatusUnprocessableEntity, CodeInvalidRequestBody, "Invalid request body")
 		return
 	}
 
 	// Проверяем, передан ли ID задачи
 	if req.ID == 0 {
-		log.Printf("HandlePostTask: Missing task ID")
-		http.Error(w, "Task ID is required", http.StatusBadRequest)
+		logger.Debug("Missing task ID")
+		sendError(w, http.StatusBadRequest, CodeMissingTaskID, "Task ID is required")
 		return
 	}
 
-	log.Printf("HandlePostTask: Processing task ID %d with result %.2f", req.ID, req.Result)
-
-	// Обновляем результат задачи
-	err := h.App.CompleteTask(req.ID, req.Result)
+	logger.Debug("Processing task result", "task_id", req.ID, "result", req.Result, "result_value", req.ResultValue)
+
+	// Обновляем результат задачи: точное значение предпочтительнее float64.
+	// Span агента из заголовка traceparent становится родителем этапа завершения задачи.
+	ctx := tracing.Extract(r.Context(), r.Header)
+	var err error
+	if req.Error != "" {
+		logger.Info("Task failed on agent", "task_id", req.ID, "error", req.Error)
+		err = h.App.FailTaskContext(ctx, req.ID, req.Error)
+	} else if req.ResultValue != "" {
+		err = h.App.CompleteTaskValueContext(ctx, req.ID, req.ResultValue)
+	} else {
+		err = h.App.CompleteTaskContext(ctx, req.ID, req.Result)
+	}
 	if err != nil {
-		log.Printf("HandlePostTask: Error completing task: %v", err)
-
-		// Проверяем, является ли ошибка "task not found"
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logger.Warn("Failed to complete task", "task_id", req.ID, "error", err)
+		sendErrorFrom(w, err)
 		return
 	}
 
-	log.Printf("HandlePostTask: Successfully completed task ID %d", req.ID)
 	// Возвращаем успешный ответ
 	w.WriteHeader(http.StatusOK)
 }
 
 // HandleExpressions обрабатывает запрос на получение всех выражений
 func (h *Handler) HandleExpressions(w http.ResponseWriter, r *http.Request) {
-	log.Printf("HandleExpressions: Received request")
-
-	// Проверяем метод запроса
-	if r.Method != http.MethodGet {
-		log.Printf("HandleExpressions: Invalid method %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Получаем все выражения из приложения
 	expressions := h.App.GetAllExpressions()
-	log.Printf("HandleExpressions: Retrieved %d expressions", len(expressions))
 
 	// Преобразуем выражения в JSON-массив
 	var response ResponseGetExpressions
 	for _, expr := range expressions {
 		response.Expressions = append(response.Expressions, ExpressionResponse{
-			ID:     expr.ID,
-			Status: expr.Status,
-			Result: expr.Result,
+			ID:          expr.ID,
+			Status:      expr.Status,
+			Result:      expr.Result,
+			TasksSaved:  expr.TasksSaved,
+			Mode:        string(expr.Mode),
+			ResultValue: expr.ResultValue,
+			Error:       expr.Error,
+			Boolean:     booleanResult(expr),
+			Deadline:    deadlineOf(expr),
+			TraceID:     expr.TraceID,
 		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("HandleExpressions: Error encoding response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		requestLogger(r).Warn("Failed to encode response", "error", err)
 		return
 	}
 }
 
 // HandleGetExpressionByID обрабатывает GET-запрос для получения выражения по ID.
 func (h *Handler) HandleGetExpressionByID(w http.ResponseWriter, r *http.Request) {
-	// Проверяем метод запроса
-	if r.Method != http.MethodGet {
-		log.Printf("HandleGetExpressionByID: Invalid method %s for URL: %s", r.Method, r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Извлекаем ID из URL пути
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 || parts[len(parts)-1] == "" {
-		log.Printf("HandleGetExpressionByID: Invalid URL path: %s", r.URL.Path)
-		http.Error(w, "Invalid URL path", http.StatusBadRequest)
-		return
-	}
+	logger := requestLogger(r)
 
-	// Получаем ID выражения и преобразуем в число
-	idStr := parts[len(parts)-1]
+	// Получаем ID выражения из пути и преобразуем в число
+	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("HandleGetExpressionByID: Invalid ID format: %s", idStr)
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		logger.Debug("Invalid ID format", "id", idStr)
+		sendError(w, http.StatusBadRequest, CodeInvalidID, "Invalid ID format")
 		return
 	}
 
 	// Получаем выражение по ID
 	expression, err := h.App.GetExpressionByID(id)
 	if err != nil {
-		log.Printf("HandleGetExpressionByID: Error retrieving expression ID %d: %v", id, err)
-
-		// Проверяем, является ли ошибка "not found"
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, "Expression not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+		logger.Debug("Expression not available", "expression_id", id, "error", err)
+		sendErrorFrom(w, err)
 		return
 	}
 
-	if expression == nil {
-		log.Printf("HandleGetExpressionByID: Expression with ID %d not found", id)
-		http.Error(w, "Expression not found", http.StatusNotFound)
-		return
-	}
-
-	log.Printf("HandleGetExpressionByID: Successfully retrieved expression ID %d with status %s",
-		id, expression.Status)
-
 	// Формируем JSON-ответ
 	response := GetExpressionResponse{
 		Expression: ExpressionResponse{
-			ID:     expression.ID,
-			Status: expression.Status,
-			Result: expression.Result,
+			ID:         expression.ID,
+			Status:     expression.Status,
+			Result:     expression.Result,
+			TasksSaved: expression.TasksSaved,
+
+			Mode:        string(expression.Mode),
+			ResultValue: expression.ResultValue,
+			Error:       expression.Error,
+			Boolean:     booleanResult(expression),
+			Deadline:    deadlineOf(expression),
+			TraceID:     expression.TraceID,
 		},
 	}
 
@@ -264,47 +470,29 @@ func (h *Handler) HandleGetExpressionByID(w http.ResponseWriter, r *http.Request
 
 	// Проверяем ошибку при кодировании JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("HandleGetExpressionByID: Error encoding response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		logger.Warn("Failed to encode response", "expression_id", id, "error", err)
 		return
 	}
 }
 
 // HandleGetResult обрабатывает запрос на получение результата выражения
 func (h *Handler) HandleGetResult(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w, r) // Разрешаем CORS
+	logger := requestLogger(r)
 
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Извлекаем ID выражения из URL
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 || parts[len(parts)-1] == "" {
-		log.Printf("HandleGetResult: Invalid URL path: %s", r.URL.Path)
-		http.Error(w, "Invalid URL path", http.StatusBadRequest)
-		return
-	}
-
-	exprIDStr := parts[len(parts)-1]
+	// Извлекаем ID выражения из пути
+	exprIDStr := r.PathValue("id")
 	exprID, err := strconv.Atoi(exprIDStr)
 	if err != nil {
-		log.Printf("HandleGetResult: Invalid ID format: %s", exprIDStr)
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		logger.Debug("Invalid ID format", "id", exprIDStr)
+		sendError(w, http.StatusBadRequest, CodeInvalidID, "Invalid ID format")
 		return
 	}
 
 	// Получаем результат выражения
 	result, err := h.App.GetExpressionResult(exprID)
 	if err != nil {
-		log.Printf("HandleGetResult: Error retrieving result for expression ID %d: %v", exprID, err)
-		http.Error(w, "Result not found", http.StatusNotFound)
+		logger.Debug("Result not available", "expression_id", exprID, "error", err)
+		sendErrorFrom(w, err)
 		return
 	}
 
@@ -315,3 +503,30 @@ func (h *Handler) HandleGetResult(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// HandleCacheStats возвращает счетчики кэша результатов операций
+func (h *Handler) HandleCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.App.CacheStats()); err != nil {
+		requestLogger(r).Warn("Failed to encode response", "error", err)
+	}
+}
+
+// booleanResult возвращает логический результат вычисленного выражения со сравнениями
+// или логическими операциями; для остальных выражений — nil
+func booleanResult(expr *application.Expression) *bool {
+	if !expr.Boolean || expr.Status != "completed" {
+		return nil
+	}
+	result := expr.Result != 0
+	return &result
+}
+
+// deadlineOf возвращает срок вычисления выражения или nil, если срок не задан
+func deadlineOf(expr *application.Expression) *time.Time {
+	if expr.Deadline.IsZero() {
+		return nil
+	}
+	deadline := expr.Deadline
+	return &deadline
+}
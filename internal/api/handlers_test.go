@@ -2,13 +2,26 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/syirnik/GO_Yandex/internal/application"
+	"github.com/syirnik/GO_Yandex/internal/metrics"
+	"github.com/syirnik/GO_Yandex/internal/tracing"
+	"github.com/syirnik/GO_Yandex/pkg/calculation"
 )
 
 // TestMain используется для настройки тестового окружения (опционально).
@@ -22,10 +35,10 @@ func TestHandleCalculate(t *testing.T) {
 	handler := NewHandler(app)
 
 	t.Run("InvalidMethod", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/calculate", nil)
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/calculate", nil)
 		rr := httptest.NewRecorder()
 
-		handler.HandleCalculate(rr, req)
+		newRouter(handler).ServeHTTP(rr, req)
 
 		if rr.Code != http.StatusMethodNotAllowed {
 			t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
@@ -135,10 +148,10 @@ func TestHandleExpressions(t *testing.T) {
 	handler := NewHandler(app)
 
 	t.Run("InvalidMethod", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPost, "/expressions", nil)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/expressions", nil)
 		rr := httptest.NewRecorder()
 
-		handler.HandleExpressions(rr, req)
+		newRouter(handler).ServeHTTP(rr, req)
 
 		if rr.Code != http.StatusMethodNotAllowed {
 			t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
@@ -159,6 +172,20 @@ func TestHandleExpressions(t *testing.T) {
 			t.Errorf("expected Content-Type application/json, got %s", rr.Header().Get("Content-Type"))
 		}
 	})
+
+	t.Run("TasksSaved", func(t *testing.T) {
+		app.ParseExpressionWithOptions("(2+3)*(2+3)", application.ExpressionOptions{Optimize: true})
+		rr := httptest.NewRecorder()
+		handler.HandleExpressions(rr, httptest.NewRequest(http.MethodGet, "/expressions", nil))
+
+		var response ResponseGetExpressions
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(response.Expressions) != 1 || response.Expressions[0].TasksSaved != 3 {
+			t.Errorf("expected one expression with tasks_saved 3, got %+v", response.Expressions)
+		}
+	})
 }
 
 // TestHandleGetExpressionByID тестирует обработчик получения выражения по ID (внешнее поведение).
@@ -167,11 +194,10 @@ func TestHandleGetExpressionByID(t *testing.T) {
 	handler := NewHandler(app)
 
 	t.Run("InvalidMethod", func(t *testing.T) {
-		// Используем более длинный путь, чтобы соответствовать проверке len(parts) < 4
-		req := httptest.NewRequest(http.MethodPost, "/api/expressions/1", nil)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/expressions/1", nil)
 		rr := httptest.NewRecorder()
 
-		handler.HandleGetExpressionByID(rr, req)
+		newRouter(handler).ServeHTTP(rr, req)
 
 		if rr.Code != http.StatusMethodNotAllowed {
 			t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
@@ -179,23 +205,22 @@ func TestHandleGetExpressionByID(t *testing.T) {
 	})
 
 	t.Run("InvalidURLPath", func(t *testing.T) {
-		// Путь не содержит достаточно сегментов
-		req := httptest.NewRequest(http.MethodGet, "/api/expressions/", nil)
+		// Путь без ID не совпадает с маршрутом
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/", nil)
 		rr := httptest.NewRecorder()
 
-		handler.HandleGetExpressionByID(rr, req)
+		newRouter(handler).ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusBadRequest {
-			t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+		if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), CodeNotFound) {
+			t.Errorf("expected status %d with %s, got %d", http.StatusNotFound, CodeNotFound, rr.Code)
 		}
 	})
 
 	t.Run("InvalidIDFormat", func(t *testing.T) {
-		// Используем более длинный путь
-		req := httptest.NewRequest(http.MethodGet, "/api/expressions/invalid", nil)
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/invalid", nil)
 		rr := httptest.NewRecorder()
 
-		handler.HandleGetExpressionByID(rr, req)
+		newRouter(handler).ServeHTTP(rr, req)
 
 		if rr.Code != http.StatusBadRequest {
 			t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
@@ -203,11 +228,10 @@ func TestHandleGetExpressionByID(t *testing.T) {
 	})
 
 	t.Run("ExpressionNotFound", func(t *testing.T) {
-		// Используем более длинный путь
-		req := httptest.NewRequest(http.MethodGet, "/api/expressions/999", nil)
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/999", nil)
 		rr := httptest.NewRecorder()
 
-		handler.HandleGetExpressionByID(rr, req)
+		newRouter(handler).ServeHTTP(rr, req)
 
 		if rr.Code != http.StatusNotFound {
 			t.Errorf("expected status %d, got %d", http.StatusNotFound, rr.Code)
@@ -237,11 +261,11 @@ func TestHandleGetExpressionByID(t *testing.T) {
 			return
 		}
 
-		// Теперь запрашиваем выражение по ID, используя более длинный путь
-		req = httptest.NewRequest(http.MethodGet, "/api/expressions/"+strconv.Itoa(resp.ID), nil)
+		// Теперь запрашиваем выражение по ID
+		req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+strconv.Itoa(resp.ID), nil)
 		rr = httptest.NewRecorder()
 
-		handler.HandleGetExpressionByID(rr, req)
+		newRouter(handler).ServeHTTP(rr, req)
 
 		if rr.Code != http.StatusOK {
 			t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
@@ -263,3 +287,1149 @@ func TestHandleGetExpressionByID(t *testing.T) {
 		}
 	})
 }
+
+// TestHandleCalculateRateLimit проверяет ответ 429 при превышении частоты запросов.
+func TestHandleCalculateRateLimit(t *testing.T) {
+	app := application.New()
+	handler := NewHandler(app)
+	handler.Limiter = NewRateLimiter(1, 2)
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		body, _ := json.Marshal(RequestAddExpression{Expression: "1+1"})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		handler.HandleCalculate(rr, req)
+		codes = append(codes, rr.Code)
+
+		if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("expected Retry-After header on 429 response")
+		}
+	}
+
+	if codes[0] != http.StatusCreated || codes[1] != http.StatusCreated || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected statuses [201 201 429], got %v", codes)
+	}
+
+	// Поток некорректных тел запросов тоже упирается в лимит
+	handler.Limiter = NewRateLimiter(1, 2)
+	codes = codes[:0]
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": `))
+		rr := httptest.NewRecorder()
+		handler.HandleCalculate(rr, req)
+		codes = append(codes, rr.Code)
+	}
+	if codes[0] != http.StatusUnprocessableEntity || codes[1] != http.StatusUnprocessableEntity || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected statuses [422 422 429] for invalid bodies, got %v", codes)
+	}
+}
+
+// TestRateLimiter проверяет пополнение корзины и изоляцию клиентов.
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewRateLimiter(2, 1)
+	limiter.now = func() time.Time { return now }
+
+	if allowed, _ := limiter.Allow("a"); !allowed {
+		t.Fatal("first request should be allowed")
+	}
+	allowed, wait := limiter.Allow("a")
+	if allowed {
+		t.Fatal("second request should be rejected")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("expected wait 500ms, got %v", wait)
+	}
+
+	// Другой клиент имеет собственную корзину
+	if allowed, _ := limiter.Allow("b"); !allowed {
+		t.Error("request from another client should be allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if allowed, _ := limiter.Allow("a"); !allowed {
+		t.Error("request should be allowed after refill")
+	}
+}
+
+// TestHandleCalculateLimits проверяет коды ответов при превышении ограничений выражения.
+func TestHandleCalculateLimits(t *testing.T) {
+	t.Setenv("MAX_EXPRESSION_BYTES", "16")
+	t.Setenv("MAX_PARENTHESES_DEPTH", "2")
+	app := application.New()
+	handler := NewHandler(app)
+
+	testCases := []struct {
+		name       string
+		expression string
+		wantStatus int
+	}{
+		{"TooLong", "1+2+3+4+5+6+7+8+9", http.StatusRequestEntityTooLarge},
+		{"TooDeep", "(((1+2)))", http.StatusUnprocessableEntity},
+		{"WithinLimits", "((1+2))", http.StatusCreated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, _ := json.Marshal(RequestAddExpression{Expression: tc.expression})
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+
+			handler.HandleCalculate(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("expected status %d, got %d", tc.wantStatus, rr.Code)
+			}
+		})
+	}
+}
+
+// TestErrorResponseFormat проверяет единый формат ошибок с кодом и позицией.
+func TestErrorResponseFormat(t *testing.T) {
+	app := application.New()
+	handler := NewHandler(app)
+
+	testCases := []struct {
+		name         string
+		expression   string
+		wantStatus   int
+		wantCode     string
+		wantPosition int
+	}{
+		{"InvalidCharacter", "2+x", http.StatusUnprocessableEntity, CodeInvalidCharacter, 2},
+		{"UnmatchedClosing", "(1+2))", http.StatusUnprocessableEntity, CodeMismatchedParens, 5},
+		{"UnclosedOpening", "1+(2", http.StatusUnprocessableEntity, CodeMismatchedParens, 2},
+		{"EmptyExpression", "", http.StatusUnprocessableEntity, CodeEmptyExpression, -1},
+		{"AdjacentNumbers", "1 2 + 3", http.StatusUnprocessableEntity, CodeUnexpectedToken, 2},
+		{"MalformedNumber", "2+3.4.5", http.StatusUnprocessableEntity, CodeMalformedNumber, 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, _ := json.Marshal(RequestAddExpression{Expression: tc.expression})
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+
+			handler.HandleCalculate(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("expected status %d, got %d", tc.wantStatus, rr.Code)
+			}
+
+			var resp ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if resp.Code != tc.wantCode {
+				t.Errorf("expected code %s, got %s", tc.wantCode, resp.Code)
+			}
+			if resp.Message == "" {
+				t.Error("expected non-empty message")
+			}
+			if tc.wantPosition < 0 && resp.Position != nil {
+				t.Errorf("expected no position, got %d", *resp.Position)
+			}
+			if tc.wantPosition >= 0 && (resp.Position == nil || *resp.Position != tc.wantPosition) {
+				t.Errorf("expected position %d, got %v", tc.wantPosition, resp.Position)
+			}
+		})
+	}
+
+	t.Run("TaskNotFound", func(t *testing.T) {
+		body, _ := json.Marshal(RequestPostTask{ID: 42, Result: 1})
+		req := httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		handler.HandlePostTask(rr, req)
+
+		var resp ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode error response: %v", err)
+		}
+		if rr.Code != http.StatusNotFound || resp.Code != CodeTaskNotFound {
+			t.Errorf("expected 404 %s, got %d %s", CodeTaskNotFound, rr.Code, resp.Code)
+		}
+	})
+}
+
+// TestHandleValidate тестирует обработчик диагностики выражения.
+func TestHandleValidate(t *testing.T) {
+	app := application.New()
+	handler := NewHandler(app)
+
+	t.Run("InvalidMethod", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/validate", nil)
+		rr := httptest.NewRecorder()
+
+		newRouter(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+		}
+	})
+
+	t.Run("Diagnostics", func(t *testing.T) {
+		body, _ := json.Marshal(RequestAddExpression{Expression: "2+*3)"})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/validate", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		handler.HandleValidate(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var resp ResponseValidateExpression
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.Valid || len(resp.Diagnostics) != 2 {
+			t.Errorf("expected 2 diagnostics, got valid=%v %+v", resp.Valid, resp.Diagnostics)
+		}
+	})
+
+	t.Run("ValidExpression", func(t *testing.T) {
+		body, _ := json.Marshal(RequestAddExpression{Expression: "2+3"})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/validate", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		handler.HandleValidate(rr, req)
+
+		var resp ResponseValidateExpression
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !resp.Valid || len(resp.Diagnostics) != 0 {
+			t.Errorf("expected valid expression, got %+v", resp)
+		}
+	})
+}
+
+// TestHandleCacheStats проверяет счетчики кэша результатов.
+func TestHandleCacheStats(t *testing.T) {
+	app := application.New()
+	handler := NewHandler(app)
+	app.ParseExpression("2+3")
+	app.ParseExpression("2+3")
+
+	req := httptest.NewRequest(http.MethodGet, "/internal/cache", nil)
+	rr := httptest.NewRecorder()
+	handler.HandleCacheStats(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var stats application.CacheStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !stats.Enabled || stats.Misses != 1 || stats.Coalesced != 1 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+}
+
+// TestHandleNumericModes проверяет выбор числового режима и точный результат задачи.
+func TestHandleNumericModes(t *testing.T) {
+	app := application.New()
+	handler := NewHandler(app)
+
+	body, _ := json.Marshal(RequestAddExpression{Expression: "1+2", Mode: "complex"})
+	rr := httptest.NewRecorder()
+	handler.HandleCalculate(rr, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body)))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), CodeUnknownMode) {
+		t.Fatalf("expected %d with %s, got %d: %s", http.StatusUnprocessableEntity, CodeUnknownMode, rr.Code, rr.Body.String())
+	}
+
+	body, _ = json.Marshal(RequestAddExpression{Expression: "1/3 + 1", Mode: "rational"})
+	rr = httptest.NewRecorder()
+	handler.HandleCalculate(rr, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleGetTask(rr, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	var taskResp ResponseGetTask
+	json.NewDecoder(rr.Body).Decode(&taskResp)
+	if taskResp.Task.Mode != "rational" || taskResp.Task.Arg1Value != "1" || taskResp.Task.Arg2Value != "3" {
+		t.Fatalf("expected exact rational arguments, got %+v", taskResp.Task)
+	}
+
+	// Агент возвращает точный результат; следующая задача получает его без потерь
+	body, _ = json.Marshal(RequestPostTask{ID: taskResp.Task.ID, Result: 0.333, ResultValue: "1/3"})
+	rr = httptest.NewRecorder()
+	handler.HandlePostTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	task, _ := app.GetNextTask()
+	if task == nil || task.Arg1Value != "1/3" {
+		t.Fatalf("expected exact argument 1/3, got %+v", task)
+	}
+	app.CompleteTaskValue(task.ID, "4/3")
+
+	rr = httptest.NewRecorder()
+	newRouter(handler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/expressions/1", nil))
+	var exprResp GetExpressionResponse
+	json.NewDecoder(rr.Body).Decode(&exprResp)
+	if exprResp.Expression.ResultValue != "4/3" || exprResp.Expression.Mode != "rational" {
+		t.Errorf("expected rational result 4/3, got %+v", exprResp.Expression)
+	}
+}
+
+// TestHandlePostTaskError проверяет прием ошибки вычисления от агента.
+func TestHandlePostTaskError(t *testing.T) {
+	app := application.New()
+	handler := NewHandler(app)
+	exprID, _ := app.ParseExpressionWithOptions("9223372036854775807 + 1", application.ExpressionOptions{Mode: calculation.ModeInt})
+	task, _ := app.GetNextTask()
+
+	body, _ := json.Marshal(RequestPostTask{ID: task.ID, Error: "integer overflow: 9223372036854775807 + 1"})
+	rr := httptest.NewRecorder()
+	handler.HandlePostTask(rr, httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	newRouter(handler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+strconv.Itoa(exprID), nil))
+	var exprResp GetExpressionResponse
+	json.NewDecoder(rr.Body).Decode(&exprResp)
+	if exprResp.Expression.Status != "failed" || !strings.Contains(exprResp.Expression.Error, "integer overflow") {
+		t.Errorf("expected failed expression with overflow, got %+v", exprResp.Expression)
+	}
+
+	rr = httptest.NewRecorder()
+	newRouter(handler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/result/"+strconv.Itoa(exprID), nil))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), CodeExpressionFailed) {
+		t.Errorf("expected %d with %s, got %d: %s", http.StatusUnprocessableEntity, CodeExpressionFailed, rr.Code, rr.Body.String())
+	}
+}
+
+// TestHandleBooleanResult проверяет логический результат сравнений и ошибку условного оператора.
+func TestHandleBooleanResult(t *testing.T) {
+	app := application.New()
+	handler := NewHandler(app)
+	boolID, _ := app.ParseExpression("2 + 2 == 5")
+	numID, _ := app.ParseExpression("1 + 1 > 1 ? 3 : 4")
+	for {
+		task, _ := app.GetNextTask()
+		if task == nil {
+			break
+		}
+		backend, _ := calculation.NewBackend(task.Mode, task.Scale)
+		value, _ := backend.Apply(task.Operation, task.Arg1Value, task.Arg2Value)
+		app.CompleteTaskValue(task.ID, value)
+	}
+
+	for id, expected := range map[int]string{boolID: `"boolean":false`, numID: `"result":3`} {
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+strconv.Itoa(id), nil))
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expression %d: expected %s, got %s", id, expected, rr.Body.String())
+		}
+		if id == numID && strings.Contains(rr.Body.String(), "boolean") {
+			t.Errorf("expected no boolean field for numeric result, got %s", rr.Body.String())
+		}
+	}
+
+	body, _ := json.Marshal(RequestAddExpression{Expression: "1 ? 2"})
+	rr := httptest.NewRecorder()
+	handler.HandleCalculate(rr, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body)))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), CodeUnmatchedConditional) {
+		t.Errorf("expected %d with %s, got %d: %s", http.StatusUnprocessableEntity, CodeUnmatchedConditional, rr.Code, rr.Body.String())
+	}
+}
+
+// TestHandleDeadlineAndWait проверяет срок вычисления выражения и ожидание задачи агентом.
+func TestHandleDeadlineAndWait(t *testing.T) {
+	app := application.New()
+	handler := NewHandler(app)
+
+	body, _ := json.Marshal(RequestAddExpression{Expression: "1+2", TimeoutMs: -1})
+	rr := httptest.NewRecorder()
+	handler.HandleCalculate(rr, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body)))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), CodeInvalidTimeout) {
+		t.Fatalf("expected %d with %s, got %d: %s", http.StatusUnprocessableEntity, CodeInvalidTimeout, rr.Code, rr.Body.String())
+	}
+
+	body, _ = json.Marshal(RequestAddExpression{Expression: "1+2", TimeoutMs: 10})
+	rr = httptest.NewRecorder()
+	handler.HandleCalculate(rr, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	rr = httptest.NewRecorder()
+	newRouter(handler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/expressions/1", nil))
+	var exprResp GetExpressionResponse
+	json.NewDecoder(rr.Body).Decode(&exprResp)
+	if exprResp.Expression.Status != "timeout" || exprResp.Expression.Deadline == nil {
+		t.Errorf("expected timeout with deadline, got %+v", exprResp.Expression)
+	}
+
+	rr = httptest.NewRecorder()
+	newRouter(handler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/result/1", nil))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), CodeExpressionTimeout) {
+		t.Errorf("expected %d with %s, got %d: %s", http.StatusUnprocessableEntity, CodeExpressionTimeout, rr.Code, rr.Body.String())
+	}
+
+	// Задачи истекшего выражения сняты с очереди: ожидание заканчивается без задачи
+	rr = httptest.NewRecorder()
+	handler.HandleGetTask(rr, httptest.NewRequest(http.MethodGet, "/internal/task?wait=20ms", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleGetTask(rr, httptest.NewRequest(http.MethodGet, "/internal/task?wait=soon", nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvalidWait) {
+		t.Errorf("expected %d with %s, got %d: %s", http.StatusBadRequest, CodeInvalidWait, rr.Code, rr.Body.String())
+	}
+
+	// Задача, появившаяся во время ожидания, выдается сразу
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		app.ParseExpression("3+4")
+	}()
+	rr = httptest.NewRecorder()
+	handler.HandleGetTask(rr, httptest.NewRequest(http.MethodGet, "/internal/task?wait=5s", nil))
+	var taskResp ResponseGetTask
+	json.NewDecoder(rr.Body).Decode(&taskResp)
+	if rr.Code != http.StatusOK || taskResp.Task.Arg1Value != "3" {
+		t.Errorf("expected task 3+4, got %d: %+v", rr.Code, taskResp.Task)
+	}
+}
+
+// TestServerShutdown проверяет плавную остановку: новые выражения отклоняются,
+// принятые вычисляются до конца, ожидающие задачу агенты отпускаются.
+func TestServerShutdown(t *testing.T) {
+	app := application.New()
+	server := NewServer(app)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseURL := "http://" + listener.Addr().String()
+	// Без keep-alive у сервера не остается неиспользованных соединений, которые
+	// http.Server.Shutdown считает активными первые 5 секунд
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	post := func(path string, v interface{}) *http.Response {
+		body, _ := json.Marshal(v)
+		resp, err := client.Post(baseURL+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := post("/api/v1/calculate", RequestAddExpression{Expression: "1+2"}); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	resp := post("/api/v1/calculate", RequestAddExpression{Expression: "3+4"})
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d while draining, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	// Агент получает задачу принятого выражения и присылает результат
+	resp, err = client.Get(baseURL + "/internal/task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var taskResp ResponseGetTask
+	json.NewDecoder(resp.Body).Decode(&taskResp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected task while draining, got %d", resp.StatusCode)
+	}
+	if resp := post("/internal/task", RequestPostTask{ID: taskResp.Task.ID, Result: 3}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Errorf("unexpected shutdown error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	if err := <-served; err != nil {
+		t.Errorf("unexpected serve error: %v", err)
+	}
+	if result, err := app.GetExpressionResult(1); err != nil || result != 3 {
+		t.Errorf("expected result 3, got %v, %v", result, err)
+	}
+
+	// Ожидание задачи прерывается остановкой
+	rr := httptest.NewRecorder()
+	server.Handler.HandleGetTask(rr, httptest.NewRequest(http.MethodGet, "/internal/task?wait=5s", nil))
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), CodeShuttingDown) {
+		t.Errorf("expected %d with %s, got %d: %s", http.StatusServiceUnavailable, CodeShuttingDown, rr.Code, rr.Body.String())
+	}
+}
+
+// TestServerShutdownDrainBudget проверяет, что ожидание невычисленных выражений не занимает
+// весь срок остановки: агент, ожидающий задачу, успевает получить ответ до закрытия соединений
+func TestServerShutdownDrainBudget(t *testing.T) {
+	app := application.New()
+	app.ParseExpression("1+2")
+	app.GetNextTask() // Задачу взял агент, который не пришлет результат
+	server := NewServer(app)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	// Другой агент ждет задачу дольше срока остановки
+	responded := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Get("http://" + listener.Addr().String() + "/internal/task?wait=10s")
+		if err != nil {
+			t.Errorf("waiting agent request failed: %v", err)
+			responded <- nil
+			return
+		}
+		resp.Body.Close()
+		responded <- resp
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+	if resp := <-responded; resp != nil && resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	<-served
+}
+
+// TestServerRouter проверяет маршрутизатор сервера: серверы независимы друг от друга,
+// неподдерживаемый метод дает 405 с Allow, неизвестный путь — 404, промежуточные
+// обработчики вызываются в порядке передачи.
+func TestServerRouter(t *testing.T) {
+	var calls []string
+	middleware := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	server := NewServer(application.New(), WithAddr(":0"), WithMiddleware(middleware("first"), middleware("second")))
+	other := NewServer(application.New())
+
+	body, _ := json.Marshal(RequestAddExpression{Expression: "1+2"})
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+	if strings.Join(calls, ",") != "first,second" {
+		t.Errorf("expected middlewares first,second, got %v", calls)
+	}
+
+	// Выражение создано только в первом сервере
+	rr = httptest.NewRecorder()
+	other.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/expressions/1", nil))
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), CodeExpressionNotFound) {
+		t.Errorf("expected %d with %s, got %d: %s", http.StatusNotFound, CodeExpressionNotFound, rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/internal/task", nil))
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "GET, OPTIONS, POST" {
+		t.Errorf("expected %d with Allow: GET, OPTIONS, POST, got %d with %q", http.StatusMethodNotAllowed, rr.Code, rr.Header().Get("Allow"))
+	}
+
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/result/abc", nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvalidID) {
+		t.Errorf("expected %d with %s, got %d: %s", http.StatusBadRequest, CodeInvalidID, rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v2/expressions", nil))
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), CodeNotFound) {
+		t.Errorf("expected %d with %s, got %d: %s", http.StatusNotFound, CodeNotFound, rr.Code, rr.Body.String())
+	}
+}
+
+// TestMiddleware проверяет идентификатор запроса (в том числе в задаче агента),
+// журнал запросов, перехват паники и CORS.
+func TestMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	defer func(logger *slog.Logger, flags int) {
+		slog.SetDefault(logger)
+		log.SetOutput(os.Stderr) // SetDefault перенаправил в журнал и вывод пакета log
+		log.SetFlags(flags)
+	}(slog.Default(), log.Flags())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	app := application.New()
+	server := NewServer(app, WithAdminToken("secret"), WithMiddleware(RequestID, AccessLog, Recover, CORS([]string{"http://allowed.example"})))
+
+	t.Run("RequestID", func(t *testing.T) {
+		body, _ := json.Marshal(RequestAddExpression{Expression: "2*3"})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body))
+		req.Header.Set(RequestIDHeader, "client-42")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Header().Get(RequestIDHeader) != "client-42" {
+			t.Errorf("expected request ID client-42, got %q", rr.Header().Get(RequestIDHeader))
+		}
+
+		// Задача выражения получает идентификатор запроса, создавшего его
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+		var taskResp ResponseGetTask
+		json.NewDecoder(rr.Body).Decode(&taskResp)
+		if taskResp.Task.RequestID != "client-42" {
+			t.Errorf("expected task request ID client-42, got %q", taskResp.Task.RequestID)
+		}
+		if generated := rr.Header().Get(RequestIDHeader); len(generated) != 32 {
+			t.Errorf("expected generated request ID, got %q", generated)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil)
+		req.Header.Set(RequestIDHeader, "bad id\n")
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if id := rr.Header().Get(RequestIDHeader); id == "bad id\n" || id == "" {
+			t.Errorf("expected invalid request ID to be replaced, got %q", id)
+		}
+	})
+
+	t.Run("AccessLog", func(t *testing.T) {
+		logs.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/999", nil)
+		req.Header.Set(RequestIDHeader, "log-1")
+		server.ServeHTTP(httptest.NewRecorder(), req)
+		if !strings.Contains(logs.String(), "method=GET path=/api/v1/expressions/999 status=404") ||
+			!strings.Contains(logs.String(), "request_id=log-1") {
+			t.Errorf("expected access log entry, got %q", logs.String())
+		}
+	})
+
+	t.Run("Recover", func(t *testing.T) {
+		logs.Reset()
+		panicking := RequestID(AccessLog(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}))))
+		rr := httptest.NewRecorder()
+		panicking.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil))
+		if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), CodeInternal) {
+			t.Errorf("expected %d with %s, got %d: %s", http.StatusInternalServerError, CodeInternal, rr.Code, rr.Body.String())
+		}
+		if !strings.Contains(logs.String(), "boom") || !strings.Contains(logs.String(), "status=500") {
+			t.Errorf("expected panic and status 500 in logs, got %q", logs.String())
+		}
+	})
+
+	t.Run("CORS", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			method      string
+			path        string
+			origin      string
+			wantStatus  int
+			wantOrigin  string
+			wantMethods string
+		}{
+			{"Preflight allowed", http.MethodOptions, "/api/v1/calculate", "http://allowed.example", http.StatusNoContent, "http://allowed.example", "OPTIONS, POST"},
+			{"Preflight other origin", http.MethodOptions, "/api/v1/result/1", "http://other.example", http.StatusNoContent, "", ""},
+			{"Request allowed", http.MethodGet, "/api/v1/expressions", "http://allowed.example", http.StatusOK, "http://allowed.example", ""},
+			{"Internal path", http.MethodGet, "/internal/cache", "http://allowed.example", http.StatusOK, "", ""},
+			{"Admin preflight", http.MethodOptions, "/api/v1/admin/expressions", "http://allowed.example", http.StatusNoContent, "", ""},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus || rr.Header().Get("Access-Control-Allow-Origin") != tt.wantOrigin {
+				t.Errorf("%s: expected %d with origin %q, got %d with %q", tt.name, tt.wantStatus, tt.wantOrigin,
+					rr.Code, rr.Header().Get("Access-Control-Allow-Origin"))
+			}
+			if methods := rr.Header().Get("Access-Control-Allow-Methods"); methods != tt.wantMethods {
+				t.Errorf("%s: expected allowed methods %q, got %q", tt.name, tt.wantMethods, methods)
+			}
+		}
+	})
+}
+
+// TestMetricsEndpoint проверяет /metrics оркестратора: выражения по статусам, очереди,
+// счетчики и время выполнения задач, метрики HTTP-запросов.
+func TestMetricsEndpoint(t *testing.T) {
+	app := application.New()
+	reg := metrics.NewRegistry()
+	server := httptest.NewServer(NewServer(app, WithMetrics(reg), WithMiddleware(RequestID, HTTPMetrics(reg))))
+	defer server.Close()
+
+	body, _ := json.Marshal(RequestAddExpression{Expression: "(1+2)*(3-1)"})
+	resp, err := http.Post(server.URL+"/api/v1/calculate", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Выполняем обе готовые задачи: одну успешно, другую с ошибкой
+	task, _ := app.GetNextTask()
+	app.CompleteTask(task.ID, 3)
+	task, _ = app.GetNextTask()
+	app.FailTask(task.ID, "agent error")
+
+	resp, err = http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scraped, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != metrics.ContentType {
+		t.Errorf("expected Content-Type %q, got %q", metrics.ContentType, resp.Header.Get("Content-Type"))
+	}
+
+	for _, want := range []string{
+		`calc_expressions{status="failed"} 1`,
+		`calc_expressions{status="pending"} 0`,
+		`calc_task_queue_length{queue="ready"} 0`,
+		`calc_task_queue_length{queue="dependent"} 0`,
+		`calc_tasks_issued_total{operation="+"} 1`,
+		`calc_tasks_issued_total{operation="-"} 1`,
+		`calc_tasks_completed_total{operation="+"} 1`,
+		`calc_tasks_failed_total{operation="-"} 1`,
+		`calc_task_duration_seconds_count{operation="+"} 1`,
+		`calc_http_requests_total{method="POST",route="POST /api/v1/calculate",status="201"} 1`,
+		`calc_http_request_duration_seconds_count{method="POST",route="POST /api/v1/calculate"} 1`,
+	} {
+		if !strings.Contains(string(scraped), want) {
+			t.Errorf("expected %q in metrics, got:\n%s", want, scraped)
+		}
+	}
+}
+
+// spanRecorder запоминает экспортированные span'ы
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// TestTracing проверяет трассу выражения: прием, разбор, выдача задачи агенту,
+// выполнение на агенте (span из traceparent задачи) и прием результата.
+func TestTracing(t *testing.T) {
+	recorder := &spanRecorder{}
+	tracer := tracing.NewTracer("orchestrator", recorder)
+	app := application.New()
+	server := NewServer(app, WithTracer(tracer), WithMiddleware(RequestID))
+
+	body, _ := json.Marshal(RequestAddExpression{Expression: "1+2"})
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+	var added ResponseAddExpression
+	json.NewDecoder(rr.Body).Decode(&added)
+
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	var taskResp ResponseGetTask
+	json.NewDecoder(rr.Body).Decode(&taskResp)
+	issued, ok := tracing.ParseTraceparent(taskResp.Task.Traceparent)
+	if !ok {
+		t.Fatalf("expected valid traceparent in task, got %q", taskResp.Task.Traceparent)
+	}
+
+	// Агент продолжает трассу и возвращает свой span в заголовке результата
+	agentTracer := tracing.NewTracer("agent", recorder)
+	agentSpan := agentTracer.StartWithParent(issued, "ExecuteTask")
+	body, _ = json.Marshal(RequestPostTask{ID: taskResp.Task.ID, Result: 3})
+	req := httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewReader(body))
+	tracing.Inject(agentSpan, req.Header)
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	agentSpan.End()
+
+	for _, tr := range []*tracing.Tracer{tracer, agentTracer} {
+		if err := tr.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	byName := make(map[string]tracing.SpanData)
+	for _, span := range recorder.spans {
+		byName[span.Name] = span
+	}
+	root := byName["expression"]
+	if root.ParentSpanID != "" || root.Attributes["expression.status"] != "completed" || root.End.IsZero() {
+		t.Errorf("unexpected root span: %+v", root)
+	}
+	for name, parent := range map[string]string{
+		"HandleCalculate": "expression",
+		"ParseExpression": "HandleCalculate",
+		"GetNextTask":     "expression",
+		"CompleteTask":    "ExecuteTask",
+	} {
+		span, exists := byName[name]
+		if !exists {
+			t.Errorf("expected span %s, got %+v", name, recorder.spans)
+			continue
+		}
+		if span.TraceID != root.TraceID || span.ParentSpanID != byName[parent].SpanID {
+			t.Errorf("expected span %s to be a child of %s in trace %s, got %+v", name, parent, root.TraceID, span)
+		}
+	}
+	if byName["ExecuteTask"].ParentSpanID != byName["GetNextTask"].SpanID {
+		t.Errorf("expected agent span to continue the issued task context, got %+v", byName["ExecuteTask"])
+	}
+
+	// Трасса находится по выражению, а выражение — по трассе
+	if root.Attributes["expression.id"] != added.ID {
+		t.Errorf("expected root span for expression %d, got %+v", added.ID, root.Attributes)
+	}
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/expressions/%d", added.ID), nil))
+	var detail GetExpressionResponse
+	json.NewDecoder(rr.Body).Decode(&detail)
+	if detail.Expression.TraceID == "" || detail.Expression.TraceID != root.TraceID {
+		t.Errorf("expected expression trace_id %q, got %q", root.TraceID, detail.Expression.TraceID)
+	}
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil))
+	var list ResponseGetExpressions
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Expressions) != 1 || list.Expressions[0].TraceID != root.TraceID {
+		t.Errorf("expected listed expression with trace_id %q, got %+v", root.TraceID, list.Expressions)
+	}
+}
+
+// TestHealthEndpoints проверяет /healthz и /readyz: готовность после запуска сервера,
+// очереди и выражения в ответе, подсчет агентов и неготовность при остановке.
+func TestHealthEndpoints(t *testing.T) {
+	app := application.New()
+	server := NewServer(app)
+
+	get := func(path string, header http.Header) (int, ResponseReadiness) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for key, values := range header {
+			req.Header.Set(key, values[0])
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		var response ResponseReadiness
+		json.NewDecoder(rr.Body).Decode(&response)
+		return rr.Code, response
+	}
+
+	if code, response := get("/healthz", nil); code != http.StatusOK || response.Status != "ok" {
+		t.Errorf("expected healthy server, got %d: %+v", code, response)
+	}
+	if code, response := get("/readyz", nil); code != http.StatusServiceUnavailable || response.Status != readinessStarting {
+		t.Errorf("expected %s before Serve, got %d: %+v", readinessStarting, code, response)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	defer func() {
+		// Выражение остается невычисленным: не ждем его дольше короткого срока
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		server.Shutdown(ctx)
+		<-served
+	}()
+	resp, err := http.Get("http://" + listener.Addr().String() + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected ready server, got %d", resp.StatusCode)
+	}
+
+	// Два агента с разными идентификаторами и один без идентификатора (по IP)
+	app.ParseExpression("(1+2)*3")
+	get("/internal/task", http.Header{AgentIDHeader: {"agent-1"}})
+	get("/internal/task", http.Header{AgentIDHeader: {"agent-2"}})
+	get("/internal/task", nil)
+	code, response := get("/readyz", nil)
+	if code != http.StatusOK || response.Status != readinessReady || response.Agents != 3 ||
+		response.Queue.Dependent != 1 || response.Expressions["pending"] != 1 {
+		t.Errorf("unexpected readiness: %d: %+v", code, response)
+	}
+
+	server.Handler.StartDraining()
+	if code, response := get("/readyz", nil); code != http.StatusServiceUnavailable || response.Status != readinessShuttingDown {
+		t.Errorf("expected %s while draining, got %d: %+v", readinessShuttingDown, code, response)
+	}
+	if code, _ := get("/healthz", nil); code != http.StatusOK {
+		t.Errorf("expected healthy server while draining, got %d", code)
+	}
+}
+
+// TestAgentTracker проверяет учет агентов: число запоминаемых идентификаторов ограничено,
+// давно не обращавшиеся агенты забываются, без заголовка агент различается по IP-адресу
+func TestAgentTracker(t *testing.T) {
+	tracker := newAgentTracker()
+	now := time.Unix(0, 0)
+	for i := 0; i < maxTrackedAgents+10; i++ {
+		tracker.seen(fmt.Sprintf("agent-%d", i), now)
+	}
+	if active := tracker.active(now); active != maxTrackedAgents {
+		t.Errorf("expected %d tracked agents, got %d", maxTrackedAgents, active)
+	}
+
+	// Место освобождается, когда прежние агенты перестают обращаться
+	now = now.Add(agentTTL + time.Second)
+	tracker.seen("late-agent", now)
+	if len(tracker.lastSeen) != 1 || tracker.active(now) != 1 {
+		t.Errorf("expected only the late agent to stay, got %d", len(tracker.lastSeen))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/internal/task", nil)
+	req.RemoteAddr = "10.0.0.7:5000"
+	req.Header.Set(AgentIDHeader, "bad id\n")
+	if id := agentID(req); id != "10.0.0.7" {
+		t.Errorf("expected remote address for invalid agent ID, got %q", id)
+	}
+}
+
+// TestAdminAPI проверяет доступ к API администратора по токену и его операции
+func TestAdminAPI(t *testing.T) {
+	const token = "0123456789abcdef"
+	app := application.New()
+	server := NewServer(app, WithAdminToken(token))
+
+	do := func(method, path, auth string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Без WithAdminToken API администратора нет
+	rr := httptest.NewRecorder()
+	NewServer(app).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/tasks", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected admin API to be disabled, got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodGet, "/api/v1/admin/tasks", "", ""); rr.Code != http.StatusUnauthorized ||
+		rr.Header().Get("WWW-Authenticate") == "" || !strings.Contains(rr.Body.String(), CodeUnauthorized) {
+		t.Errorf("expected 401 without token, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/v1/admin/tasks", "wrong-token", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 with wrong token, got %d", rr.Code)
+	}
+
+	exprID, _ := app.ParseExpression("(1+2)*3")
+	issued, _ := app.GetNextTask()
+
+	rr = do(http.MethodGet, "/api/v1/admin/tasks", token, "")
+	var tasks ResponseAdminTasks
+	json.NewDecoder(rr.Body).Decode(&tasks)
+	if rr.Code != http.StatusOK || len(tasks.Tasks) != 2 || tasks.Tasks[0].State != application.TaskStateInFlight ||
+		tasks.Tasks[0].IssuedAt == nil || tasks.Tasks[0].Expression != "(1+2)*3" || tasks.Tasks[1].State != application.TaskStateDependent {
+		t.Fatalf("unexpected task list: %d: %+v", rr.Code, tasks)
+	}
+
+	// Возврат задачи в очередь
+	requeue := "/api/v1/admin/tasks/" + strconv.Itoa(issued.ID) + "/requeue"
+	if rr := do(http.MethodPost, requeue, token, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected task requeued, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPost, requeue, token, ""); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeTaskNotInFlight) {
+		t.Errorf("expected 409 for queued task, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Приостановка выдачи задач
+	rr = do(http.MethodPost, "/api/v1/admin/dispatch/pause", token, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"paused":true`) {
+		t.Errorf("expected dispatch paused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/internal/task", "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected no tasks while paused, got %d", rr.Code)
+	}
+	do(http.MethodPost, "/api/v1/admin/dispatch/resume", token, "")
+	if rr := do(http.MethodGet, "/api/v1/admin/dispatch", token, ""); !strings.Contains(rr.Body.String(), `"paused":false`) {
+		t.Errorf("expected dispatch resumed, got %s", rr.Body.String())
+	}
+
+	// Принудительная ошибка и удаление завершенных выражений
+	fail := "/api/v1/admin/expressions/" + strconv.Itoa(exprID) + "/fail"
+	if rr := do(http.MethodPost, fail, token, `{"reason":"stuck"}`); rr.Code != http.StatusNoContent {
+		t.Errorf("expected expression failed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if expr, _ := app.GetExpressionByID(exprID); expr.Status != "failed" || !strings.Contains(expr.Error, "stuck") {
+		t.Errorf("expected failed expression with reason, got %s: %s", expr.Status, expr.Error)
+	}
+	if rr := do(http.MethodPost, fail, token, ""); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeExpressionNotPending) {
+		t.Errorf("expected 409 for finished expression, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodDelete, "/api/v1/admin/expressions?older_than=soon", token, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid duration, got %d", rr.Code)
+	}
+	rr = do(http.MethodDelete, "/api/v1/admin/expressions?older_than=0s", token, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"purged":1`) {
+		t.Errorf("expected one expression purged, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/v1/expressions/"+strconv.Itoa(exprID), "", ""); rr.Code != http.StatusGone {
+		t.Errorf("expected purged expression to be gone, got %d", rr.Code)
+	}
+}
+
+// TestExpressionEvicted проверяет ответ 410 для выражений, удаленных по сроку хранения,
+// и 404 для никогда не существовавших
+func TestExpressionEvicted(t *testing.T) {
+	app := application.New()
+	server := NewServer(app)
+	exprID, _ := app.ParseExpression("2+3")
+	task, _ := app.GetNextTask()
+	app.CompleteTask(task.ID, 5)
+
+	if evicted := app.EvictExpressions(application.Retention{MaxAge: time.Nanosecond}); evicted != 1 {
+		t.Fatalf("expected one evicted expression, got %d", evicted)
+	}
+
+	for _, path := range []string{"/api/v1/expressions/", "/api/v1/result/"} {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path+strconv.Itoa(exprID), nil))
+		if rr.Code != http.StatusGone || !strings.Contains(rr.Body.String(), CodeExpressionEvicted) {
+			t.Errorf("%s: expected 410 %s, got %d: %s", path, CodeExpressionEvicted, rr.Code, rr.Body.String())
+		}
+
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path+"999", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404 for unknown expression, got %d", path, rr.Code)
+		}
+	}
+}
+
+// TestHandleCalculateIdempotency проверяет повтор POST /api/v1/calculate с Idempotency-Key
+func TestHandleCalculateIdempotency(t *testing.T) {
+	app := application.New()
+	server := NewServer(app)
+
+	submit := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+	idOf := func(rr *httptest.ResponseRecorder) int {
+		var response ResponseAddExpression
+		json.NewDecoder(rr.Body).Decode(&response)
+		return response.ID
+	}
+
+	first := submit("retry-1", `{"expression": "2+2"}`)
+	if first.Code != http.StatusCreated || first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("expected 201 for the first request, got %d", first.Code)
+	}
+	firstID := idOf(first)
+
+	replay := submit("retry-1", `{"expression":"2+2"}`)
+	if replay.Code != http.StatusOK || replay.Header().Get(IdempotentReplayedHeader) != "true" || idOf(replay) != firstID {
+		t.Errorf("expected 200 with the original ID %d, got %d", firstID, replay.Code)
+	}
+
+	conflict := submit("retry-1", `{"expression": "2+2", "mode": "decimal"}`)
+	if conflict.Code != http.StatusConflict || !strings.Contains(conflict.Body.String(), CodeIdempotencyConflict) {
+		t.Errorf("expected 409 for a different body, got %d: %s", conflict.Code, conflict.Body.String())
+	}
+
+	if rr := submit("bad key\n", `{"expression": "2+2"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid key, got %d", rr.Code)
+	}
+	if rr := submit("", `{"expression": "2+2"}`); rr.Code != http.StatusCreated || idOf(rr) == firstID {
+		t.Errorf("expected a new expression without a key, got %d", rr.Code)
+	}
+	if len(app.GetAllExpressions()) != 2 {
+		t.Errorf("expected 2 expressions, got %d", len(app.GetAllExpressions()))
+	}
+
+	// Повтор не расходует лимит запросов: ответ 200 и после исчерпания лимита
+	handler := NewHandler(application.New())
+	handler.Limiter = NewRateLimiter(0.001, 1)
+	limited := func(key, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		handler.HandleCalculate(rr, req)
+		return rr.Code
+	}
+	for _, tc := range []struct {
+		key, body string
+		expected  int
+	}{
+		{"limited-1", `{"expression": "1+1"}`, http.StatusCreated},
+		{"limited-1", `{"expression": "1+1"}`, http.StatusOK},
+		{"limited-1", `{"expression": "1+2"}`, http.StatusTooManyRequests},
+		{"limited-2", `{"expression": "1+1"}`, http.StatusTooManyRequests},
+		{"limited-1", `{"expression": "1+1"}`, http.StatusOK},
+	} {
+		if code := limited(tc.key, tc.body); code != tc.expected {
+			t.Errorf("key %s, body %s: expected %d, got %d", tc.key, tc.body, tc.expected, code)
+		}
+	}
+
+	// Ошибочные запросы расходуют лимит так же, как корректные
+	handler.Limiter = NewRateLimiter(0.001, 3)
+	for _, tc := range []struct {
+		key, body string
+		expected  int
+	}{
+		{"", `{"expression":`, http.StatusUnprocessableEntity},
+		{"bad key\n", `{"expression": "1+1"}`, http.StatusBadRequest},
+		{"limited-1", `{"expression": "1+2"}`, http.StatusConflict},
+		{"", `{"expression":`, http.StatusTooManyRequests},
+		{"limited-1", `{"expression": "1+1"}`, http.StatusOK},
+	} {
+		if code := limited(tc.key, tc.body); code != tc.expected {
+			t.Errorf("key %q, body %s: expected %d, got %d", tc.key, tc.body, tc.expected, code)
+		}
+	}
+}
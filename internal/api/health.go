@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AgentIDHeader — заголовок, в котором агент передает свой идентификатор.
+// Без него агент различается по IP-адресу.
+const AgentIDHeader = "X-Agent-ID"
+
+// agentTTL — сколько агент считается подключенным после последнего обращения
+const agentTTL = 30 * time.Second
+
+// maxTrackedAgents — сколько агентов запоминается. Идентификатор передает сам агент,
+// поэтому без ограничения любой клиент мог бы бесконечно увеличивать их число.
+const maxTrackedAgents = 1024
+
+// Статусы готовности оркестратора
+const (
+	readinessReady        = "ready"
+	readinessStarting     = "starting"
+	readinessShuttingDown = "shutting_down"
+)
+
+// agentTracker запоминает время последнего обращения агентов за задачами и результатами
+type agentTracker struct {
+	mu        sync.Mutex
+	lastSeen  map[string]time.Time
+	lastPrune time.Time
+}
+
+func newAgentTracker() *agentTracker {
+	return &agentTracker{lastSeen: make(map[string]time.Time)}
+}
+
+// seen отмечает обращение агента id. Когда запомнено maxTrackedAgents агентов,
+// новые не учитываются, пока не освободится место.
+func (t *agentTracker) seen(id string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastPrune) >= agentTTL {
+		t.prune(now)
+	}
+	if _, known := t.lastSeen[id]; !known && len(t.lastSeen) >= maxTrackedAgents {
+		t.prune(now)
+		if len(t.lastSeen) >= maxTrackedAgents {
+			return
+		}
+	}
+	t.lastSeen[id] = now
+}
+
+// active возвращает количество агентов, обращавшихся за последние agentTTL,
+// и забывает остальных
+func (t *agentTracker) active(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+	return len(t.lastSeen)
+}
+
+// prune забывает агентов, не обращавшихся дольше agentTTL. Вызывается под мьютексом.
+func (t *agentTracker) prune(now time.Time) {
+	t.lastPrune = now
+	for id, last := range t.lastSeen {
+		if now.Sub(last) > agentTTL {
+			delete(t.lastSeen, id)
+		}
+	}
+}
+
+// agentID возвращает идентификатор агента из заголовка или его IP-адрес
+func agentID(r *http.Request) string {
+	if id := r.Header.Get(AgentIDHeader); validRequestID(id) {
+		return id
+	}
+	return clientKey(r)
+}
+
+// HandleHealth отвечает на проверку жизнеспособности: процесс работает и обрабатывает запросы
+func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ResponseHealth{Status: "ok"})
+}
+
+// HandleReady отвечает на проверку готовности: 200, когда сервер слушает порт и принимает
+// выражения, иначе 503 (еще запускается или останавливается). В ответе — очереди задач,
+// выражения по статусам и количество подключенных агентов.
+func (h *Handler) HandleReady(w http.ResponseWriter, r *http.Request) {
+	ready, dependent := h.App.QueueLengths()
+	response := ResponseReadiness{
+		Status:      readinessReady,
+		Queue:       QueueResponse{Ready: ready, Dependent: dependent},
+		Expressions: h.App.ExpressionCounts(),
+		Agents:      h.agents.active(time.Now()),
+	}
+
+	status := http.StatusOK
+	switch {
+	case h.draining.Load():
+		response.Status, status = readinessShuttingDown, http.StatusServiceUnavailable
+	case !h.listening.Load():
+		response.Status, status = readinessStarting, http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		requestLogger(r).Warn("Failed to encode response", "error", err)
+	}
+}
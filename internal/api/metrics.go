@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/syirnik/GO_Yandex/internal/application"
+	"github.com/syirnik/GO_Yandex/internal/metrics"
+)
+
+// expressionStatuses — статусы выражений, которые всегда выводятся в метриках (в том числе нулевые)
+var expressionStatuses = []string{"pending", "completed", "failed", "timeout"}
+
+// taskDurationBuckets — границы гистограммы времени выполнения задач агентами (секунды)
+var taskDurationBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 15, 30, 60}
+
+// taskMetrics — счетчики задач оркестратора; получает события от приложения
+type taskMetrics struct {
+	issued    *metrics.Counter
+	completed *metrics.Counter
+	failed    *metrics.Counter
+	duration  *metrics.Histogram
+}
+
+func (m *taskMetrics) TaskIssued(operation string) {
+	m.issued.Inc(operation)
+}
+
+func (m *taskMetrics) TaskCompleted(operation string, latency time.Duration) {
+	m.completed.Inc(operation)
+	m.duration.Observe(latency.Seconds(), operation)
+}
+
+func (m *taskMetrics) TaskFailed(operation string) {
+	m.failed.Inc(operation)
+}
+
+// registerAppMetrics регистрирует метрики выражений, очередей и задач приложения
+func registerAppMetrics(reg *metrics.Registry, app *application.Application) {
+	reg.NewGaugeFunc("calc_expressions", "Number of expressions by status.", []string{"status"},
+		func(emit func(float64, ...string)) {
+			counts := app.ExpressionCounts()
+			for _, status := range expressionStatuses {
+				emit(float64(counts[status]), status)
+			}
+		})
+	reg.NewGaugeFunc("calc_task_queue_length", "Number of tasks ready for agents (ready) and waiting for other tasks (dependent).", []string{"queue"},
+		func(emit func(float64, ...string)) {
+			ready, dependent := app.QueueLengths()
+			emit(float64(ready), "ready")
+			emit(float64(dependent), "dependent")
+		})
+
+	app.SetTaskObserver(&taskMetrics{
+		issued:    reg.NewCounter("calc_tasks_issued_total", "Tasks issued to agents.", "operation"),
+		completed: reg.NewCounter("calc_tasks_completed_total", "Tasks completed by agents.", "operation"),
+		failed:    reg.NewCounter("calc_tasks_failed_total", "Tasks reported as failed by agents.", "operation"),
+		duration:  reg.NewHistogram("calc_task_duration_seconds", "Time from issuing a task to receiving its result.", taskDurationBuckets, "operation"),
+	})
+}
+
+// HTTPMetrics считает HTTP-запросы по методу, маршруту и коду ответа и измеряет время их
+// обработки. Маршрут — шаблон ServeMux (например, "GET /api/v1/expressions/{id}"), поэтому
+// обработчик должен стоять до промежуточных обработчиков, подменяющих запрос.
+func HTTPMetrics(reg *metrics.Registry) Middleware {
+	requests := reg.NewCounter("calc_http_requests_total", "HTTP requests by method, route and status code.", "method", "route", "status")
+	duration := reg.NewHistogram("calc_http_request_duration_seconds", "HTTP request latency.", metrics.DefBuckets, "method", "route")
+	inFlight := reg.NewGauge("calc_http_requests_in_flight", "HTTP requests being served.")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			route := r.Pattern
+			if route == "" {
+				route = "unmatched" // Запрос обработан до маршрутизатора (например, предварительный запрос CORS)
+			}
+			requests.Inc(r.Method, route, strconv.Itoa(rec.status))
+			duration.Observe(time.Since(start).Seconds(), r.Method, route)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// RequestIDHeader — заголовок с идентификатором запроса
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength — максимальная длина идентификатора, принимаемого от клиента
+const maxRequestIDLength = 128
+
+// requestIDKey — ключ идентификатора запроса в контексте
+type requestIDKey struct{}
+
+// RequestIDFrom возвращает идентификатор запроса из контекста или пустую строку
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger возвращает журнал с идентификатором запроса
+func requestLogger(r *http.Request) *slog.Logger {
+	if id := RequestIDFrom(r.Context()); id != "" {
+		return slog.With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// RequestID берет идентификатор запроса из X-Request-ID или создает новый, кладет его
+// в контекст запроса и возвращает клиенту в том же заголовке
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID проверяет идентификатор клиента: непустой, не длиннее maxRequestIDLength,
+// только печатные ASCII-символы, чтобы его можно было писать в логи и заголовки
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID создает случайный идентификатор запроса
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder запоминает код ответа и количество записанных байт
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap дает http.ResponseController доступ к исходному ResponseWriter
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// AccessLog пишет строку лога на каждый запрос: метод, путь, код ответа, размер,
+// время обработки и идентификатор запроса
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		requestLogger(r).Info("HTTP request", "method", r.Method, "path", r.URL.Path, "status", rec.status,
+			"bytes", rec.bytes, "duration", time.Since(start), "remote", r.RemoteAddr)
+	})
+}
+
+// Recover перехватывает панику обработчика, пишет ее в лог со стеком и отвечает
+// 500 в едином формате ошибок
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err) // Обработчик намеренно прервал ответ
+			}
+			requestLogger(r).Error("Panic in handler", "method", r.Method, "path", r.URL.Path,
+				"panic", err, "stack", string(debug.Stack()))
+			if rec.status == 0 {
+				sendError(rec, http.StatusInternalServerError, CodeInternal, "Internal server error")
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// publicPathPrefix — пути публичного API; внутренние пути агентов CORS не получают
+const publicPathPrefix = "/api/"
+
+// adminPathPrefix — пути API администратора; браузерам с других источников они недоступны
+const adminPathPrefix = "/api/v1/admin/"
+
+// CORS разрешает браузерам запросы к публичному API, кроме API администратора, с источников
+// из origins ("*" — с любого). Методы в ответе на предварительный запрос OPTIONS
+// перечисляет маршрутизатор (см. handleRoutes).
+func CORS(origins []string) Middleware {
+	allowAll := false
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		if origin == "*" {
+			allowAll = true
+		}
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, publicPathPrefix) || strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			origin := r.Header.Get("Origin")
+			if origin != "" && (allowAll || allowed[origin]) {
+				header := w.Header()
+				if allowAll {
+					header.Set("Access-Control-Allow-Origin", "*")
+				} else {
+					header.Set("Access-Control-Allow-Origin", origin)
+					header.Add("Vary", "Origin")
+				}
+				header.Set("Access-Control-Allow-Headers", "Content-Type, "+RequestIDHeader+", "+IdempotencyKeyHeader)
+				header.Set("Access-Control-Expose-Headers", RequestIDHeader+", Retry-After, "+IdempotentReplayedHeader)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,8 +1,14 @@
 package api
 
+import "time"
+
 // RequestAddExpression представляет тело запроса для добавления выражения
 type RequestAddExpression struct {
 	Expression string `json:"expression"`
+	Optimize   bool   `json:"optimize,omitempty"`   // Упростить выражение перед созданием задач
+	Mode       string `json:"mode,omitempty"`       // Числовой режим: float64, decimal, rational или int
+	Scale      int    `json:"scale,omitempty"`      // Знаков после точки в режиме decimal
+	TimeoutMs  int64  `json:"timeout_ms,omitempty"` // Срок вычисления в миллисекундах; после него статус timeout
 }
 
 // ResponseAddExpression представляет тело ответа для добавления выражения
@@ -17,6 +23,15 @@ type TaskResponse struct {
 	Arg2          float64 `json:"arg2"`
 	Operation     string  `json:"operation"`
 	OperationTime int64   `json:"operation_time"`
+
+	// Точные значения аргументов в числовом режиме выражения
+	Mode      string `json:"mode,omitempty"`
+	Scale     int    `json:"scale,omitempty"`
+	Arg1Value string `json:"arg1_value,omitempty"`
+	Arg2Value string `json:"arg2_value,omitempty"`
+
+	RequestID   string `json:"request_id,omitempty"`  // Идентификатор запроса, создавшего выражение
+	Traceparent string `json:"traceparent,omitempty"` // Контекст трассировки (W3C traceparent); агент возвращает свой span в заголовке traceparent
 }
 
 // ResponseGetTask представляет тело ответа для получения задачи
@@ -26,8 +41,10 @@ type ResponseGetTask struct {
 
 // RequestPostTask представляет тело запроса для завершения задачи
 type RequestPostTask struct {
-	ID     int     `json:"id"`
-	Result float64 `json:"result"`
+	ID          int     `json:"id"`
+	Result      float64 `json:"result"`
+	ResultValue string  `json:"result_value,omitempty"` // Точный результат; если задан, заменяет result
+	Error       string  `json:"error,omitempty"`        // Ошибка выполнения (переполнение, деление на ноль)
 }
 
 // GetExpressionResponse представляет тело ответа для получения выражения по ID
@@ -37,12 +54,97 @@ type GetExpressionResponse struct {
 
 // ExpressionResponse представляет данные одного выражения
 type ExpressionResponse struct {
-	ID     int     `json:"id"`
-	Status string  `json:"status"`
-	Result float64 `json:"result"`
+	ID         int     `json:"id"`
+	Status     string  `json:"status"`
+	Result     float64 `json:"result"`
+	TasksSaved int     `json:"tasks_saved,omitempty"` // Сколько задач сэкономила оптимизация
+
+	Mode        string `json:"mode,omitempty"`
+	ResultValue string `json:"result_value,omitempty"` // Точный результат в режиме mode
+	Error       string `json:"error,omitempty"`        // Причина ошибки для статуса failed
+	Boolean     *bool  `json:"boolean,omitempty"`      // Логический результат для сравнений и логических операций
+
+	Deadline *time.Time `json:"deadline,omitempty"` // Срок вычисления, если задан timeout_ms
+	TraceID  string     `json:"trace_id,omitempty"` // Трасса выражения: корневой span expression
 }
 
 // ResponseGetExpressions представляет тело ответа для получения списка выражений
 type ResponseGetExpressions struct {
 	Expressions []ExpressionResponse `json:"expressions"`
 }
+
+// ErrorResponse представляет единый формат ошибки для всех эндпоинтов
+type ErrorResponse struct {
+	Code     string                 `json:"code"`               // Машиночитаемый код ошибки
+	Message  string                 `json:"message"`            // Описание ошибки
+	Position *int                   `json:"position,omitempty"` // Позиция ошибки в выражении (в символах)
+	Details  map[string]interface{} `json:"details,omitempty"`  // Дополнительные сведения (токен, лимиты)
+}
+
+// DiagnosticResponse представляет одну проблему в выражении с диапазоном символов [start, end)
+type DiagnosticResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+}
+
+// ResponseValidateExpression представляет тело ответа для проверки выражения
+type ResponseValidateExpression struct {
+	Valid       bool                 `json:"valid"`
+	Diagnostics []DiagnosticResponse `json:"diagnostics"`
+}
+
+// ResponseHealth представляет тело ответа проверки жизнеспособности (/healthz)
+type ResponseHealth struct {
+	Status string `json:"status"`
+}
+
+// ResponseReadiness представляет тело ответа проверки готовности (/readyz)
+type ResponseReadiness struct {
+	Status      string         `json:"status"`      // ready, starting или shutting_down
+	Queue       QueueResponse  `json:"queue"`       // Задачи в очередях
+	Expressions map[string]int `json:"expressions"` // Количество выражений по статусам
+	Agents      int            `json:"agents"`      // Агенты, обращавшиеся за задачами в последние agentTTL
+}
+
+// QueueResponse представляет длины очередей задач
+type QueueResponse struct {
+	Ready     int `json:"ready"`     // Готовые к выдаче агентам
+	Dependent int `json:"dependent"` // Ожидающие результатов других задач
+}
+
+// AdminTaskResponse представляет задачу невыполненного выражения для администратора
+type AdminTaskResponse struct {
+	ID           int        `json:"id"`
+	State        string     `json:"state"` // ready, dependent, in_flight или coalesced
+	Operation    string     `json:"operation"`
+	Arg1Value    string     `json:"arg1_value,omitempty"` // Аргументы, если уже известны
+	Arg2Value    string     `json:"arg2_value,omitempty"`
+	ParentTasks  []int      `json:"parent_tasks,omitempty"`
+	ExpressionID int        `json:"expression_id"`
+	Expression   string     `json:"expression"`
+	IssuedAt     *time.Time `json:"issued_at,omitempty"` // Когда задача выдана агенту (для in_flight)
+	RequestID    string     `json:"request_id,omitempty"`
+}
+
+// ResponseAdminTasks представляет тело ответа со списком задач для администратора
+type ResponseAdminTasks struct {
+	Paused bool                `json:"paused"` // Выдача задач приостановлена
+	Tasks  []AdminTaskResponse `json:"tasks"`
+}
+
+// RequestFailExpression представляет тело запроса для принудительного завершения выражения
+type RequestFailExpression struct {
+	Reason string `json:"reason,omitempty"` // Причина ошибки, сохраняемая в выражении
+}
+
+// ResponsePurge представляет тело ответа на удаление завершенных выражений
+type ResponsePurge struct {
+	Purged int `json:"purged"`
+}
+
+// ResponseDispatch представляет состояние выдачи задач агентам
+type ResponseDispatch struct {
+	Paused bool `json:"paused"`
+}
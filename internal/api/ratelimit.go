@@ -0,0 +1,99 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bucketIdleTTL — время, после которого простаивающая корзина клиента удаляется
+const bucketIdleTTL = 10 * time.Minute
+
+// tokenBucket хранит состояние корзины токенов одного клиента
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter ограничивает частоту запросов по алгоритму token bucket отдельно для каждого клиента
+type RateLimiter struct {
+	rate    float64 // Скорость пополнения (токенов в секунду)
+	burst   float64 // Емкость корзины
+	buckets map[string]*tokenBucket
+	lastGC  time.Time
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// NewRateLimiter создает ограничитель с заданной скоростью (запросов в секунду) и емкостью корзины
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Allow списывает токен клиента. Если токенов нет, возвращает false
+// и время, через которое можно повторить запрос.
+func (rl *RateLimiter) Allow(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.collectGarbage(now)
+
+	bucket, exists := rl.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: rl.burst, lastSeen: now}
+		rl.buckets[key] = bucket
+	}
+
+	// Пополняем корзину пропорционально прошедшему времени
+	elapsed := now.Sub(bucket.lastSeen).Seconds()
+	bucket.tokens = math.Min(rl.burst, bucket.tokens+elapsed*rl.rate)
+	bucket.lastSeen = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+	return false, wait
+}
+
+// collectGarbage удаляет корзины клиентов, которые давно не обращались к серверу
+func (rl *RateLimiter) collectGarbage(now time.Time) {
+	if now.Sub(rl.lastGC) < bucketIdleTTL {
+		return
+	}
+	rl.lastGC = now
+
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.lastSeen) >= bucketIdleTTL {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// clientKey определяет клиента запроса. Аутентификации нет, поэтому клиент — это IP-адрес.
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// retryAfterSeconds округляет время ожидания до целых секунд вверх для заголовка Retry-After
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
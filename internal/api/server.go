@@ -1,39 +1,287 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/syirnik/GO_Yandex/internal/application"
+	"github.com/syirnik/GO_Yandex/internal/metrics"
+	"github.com/syirnik/GO_Yandex/internal/tracing"
 )
 
+// defaultAddr — адрес, который слушает сервер без WithAddr
+const defaultAddr = ":8080"
+
+// Middleware оборачивает обработчик запросов
+type Middleware func(http.Handler) http.Handler
+
+// Timeouts — таймауты HTTP-сервера; нулевое значение отключает таймаут
+type Timeouts struct {
+	ReadHeader time.Duration // Чтение заголовков запроса
+	Read       time.Duration // Чтение всего запроса
+	Write      time.Duration // Запись ответа; должен превышать ожидание задачи (?wait=)
+	Idle       time.Duration // Простой keep-alive соединения
+}
+
+// Option задает параметр сервера при создании
+type Option func(*Server)
+
+// WithAddr задает адрес, который слушает Start (например, ":8080")
+func WithAddr(addr string) Option {
+	return func(s *Server) { s.addr = addr }
+}
+
+// WithTimeouts задает таймауты HTTP-сервера
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(s *Server) { s.timeouts = timeouts }
+}
+
+// WithTLS включает HTTPS с сертификатом и ключом из файлов
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) { s.certFile, s.keyFile = certFile, keyFile }
+}
+
+// WithMiddleware добавляет обработчики, через которые проходят все запросы.
+// Первый переданный обработчик вызывается первым.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(s *Server) { s.middlewares = append(s.middlewares, middlewares...) }
+}
+
+// WithMetrics регистрирует метрики приложения в reg и отдает их по GET /metrics
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(s *Server) { s.metrics = reg }
+}
+
+// WithTracer включает трассировку выражений: от приема запроса до результатов задач
+func WithTracer(tracer *tracing.Tracer) Option {
+	return func(s *Server) { s.tracer = tracer }
+}
+
+// WithAdminToken включает API администратора (/api/v1/admin/...), доступное
+// с заголовком Authorization: Bearer <token>. Без токена API отключено.
+func WithAdminToken(token string) Option {
+	return func(s *Server) { s.adminToken = token }
+}
+
 // Server представляет HTTP-сервер
 type Server struct {
 	Handler *Handler
+
+	addr        string
+	timeouts    Timeouts
+	certFile    string
+	keyFile     string
+	middlewares []Middleware
+	metrics     *metrics.Registry
+	tracer      *tracing.Tracer
+	adminToken  string
+
+	router     http.Handler
+	httpServer *http.Server
+}
+
+// NewServer создает новый сервер со своим маршрутизатором
+func NewServer(app *application.Application, opts ...Option) *Server {
+	s := &Server{Handler: NewHandler(app), addr: defaultAddr}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.tracer != nil {
+		s.Handler.Tracer = s.tracer
+		app.SetTracer(s.tracer)
+	}
+
+	mux := newRouter(s.Handler)
+	if s.adminToken != "" {
+		handleRoutes(mux, adminRoutes(s.Handler, s.adminToken))
+	}
+	if s.metrics != nil {
+		registerAppMetrics(s.metrics, app)
+		mux.Handle("GET /metrics", s.metrics.Handler())
+		mux.HandleFunc("/metrics", methodNotAllowed([]string{http.MethodGet}))
+	}
+
+	s.router = mux
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		s.router = s.middlewares[i](s.router)
+	}
+
+	s.httpServer = &http.Server{
+		Addr:              s.addr,
+		Handler:           s.router,
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		ReadTimeout:       s.timeouts.Read,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+	}
+	return s
 }
 
-// NewServer создает новый сервер
-func NewServer(app *application.Application) *Server {
-	return &Server{Handler: NewHandler(app)}
+// ServeHTTP обрабатывает запрос маршрутизатором сервера, в том числе без запуска
+// сервера (например, в тестах через httptest)
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
 }
 
-// Start запускает сервер
-func (s *Server) Start(port string) error {
-	http.HandleFunc("/api/v1/calculate", s.Handler.HandleCalculate)
-	http.HandleFunc("/api/v1/expressions", s.Handler.HandleExpressions)
-	http.HandleFunc("/api/v1/expressions/", s.Handler.HandleGetExpressionByID)
-	http.HandleFunc("/api/v1/result/", s.Handler.HandleGetResult) // обработчик для получения результата
+// route — маршрут API: путь и обработчики его методов
+type route struct {
+	path    string
+	methods map[string]http.HandlerFunc
+}
 
-	// Обработчики задач
-	http.HandleFunc("/internal/task", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			s.Handler.HandleGetTask(w, r)
-		case http.MethodPost:
-			s.Handler.HandlePostTask(w, r)
-		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
-		}
+// newRouter регистрирует обработчики запросов. На другие методы известного пути
+// отвечает 405, на неизвестные пути — 404 в едином формате ошибок.
+func newRouter(h *Handler) *http.ServeMux {
+	routes := []route{
+		{"/api/v1/calculate", map[string]http.HandlerFunc{
+			http.MethodPost: h.HandleCalculate,
+		}},
+		{"/api/v1/validate", map[string]http.HandlerFunc{
+			http.MethodPost: h.HandleValidate,
+		}},
+		{"/api/v1/expressions", map[string]http.HandlerFunc{
+			http.MethodGet: h.HandleExpressions,
+		}},
+		{"/api/v1/expressions/{id}", map[string]http.HandlerFunc{
+			http.MethodGet: h.HandleGetExpressionByID,
+		}},
+		{"/api/v1/result/{id}", map[string]http.HandlerFunc{
+			http.MethodGet: h.HandleGetResult,
+		}},
+
+		// Обработчики задач
+		{"/internal/task", map[string]http.HandlerFunc{
+			http.MethodGet:  h.HandleGetTask,
+			http.MethodPost: h.HandlePostTask,
+		}},
+		{"/internal/cache", map[string]http.HandlerFunc{
+			http.MethodGet: h.HandleCacheStats,
+		}},
+
+		// Проверки для оркестратора контейнеров и балансировщика
+		{"/healthz", map[string]http.HandlerFunc{
+			http.MethodGet: h.HandleHealth,
+		}},
+		{"/readyz", map[string]http.HandlerFunc{
+			http.MethodGet: h.HandleReady,
+		}},
+	}
+
+	mux := http.NewServeMux()
+	handleRoutes(mux, routes)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requestLogger(r).Debug("Unknown path", "path", r.URL.Path)
+		sendError(w, http.StatusNotFound, CodeNotFound, "Not found")
 	})
+	return mux
+}
+
+// handleRoutes регистрирует обработчики методов маршрутов, ответ на OPTIONS
+// и ответ 405 на остальные методы
+func handleRoutes(mux *http.ServeMux, routes []route) {
+	for _, rt := range routes {
+		allowed := []string{http.MethodOptions}
+		for method, handler := range rt.methods {
+			mux.HandleFunc(method+" "+rt.path, handler)
+			allowed = append(allowed, method)
+		}
+		mux.HandleFunc(http.MethodOptions+" "+rt.path, options(allowed))
+		mux.HandleFunc(rt.path, methodNotAllowed(allowed))
+	}
+}
+
+// options отвечает 204 на OPTIONS, перечисляя методы маршрута в заголовке Allow, а для
+// предварительного запроса браузера, разрешенного CORS, — и в Access-Control-Allow-Methods
+func options(allowed []string) http.HandlerFunc {
+	allow := strings.Join(sortedMethods(allowed), ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Allow", allow)
+		if header.Get("Access-Control-Allow-Origin") != "" {
+			header.Set("Access-Control-Allow-Methods", allow)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// sortedMethods возвращает отсортированную копию списка методов
+func sortedMethods(methods []string) []string {
+	sorted := append([]string(nil), methods...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// methodNotAllowed отвечает 405 с заголовком Allow на запросы с неподдерживаемым методом
+func methodNotAllowed(allowed []string) http.HandlerFunc {
+	allow := strings.Join(sortedMethods(allowed), ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestLogger(r).Debug("Method not allowed", "method", r.Method, "path", r.URL.Path)
+		w.Header().Set("Allow", allow)
+		sendError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// Start слушает адрес сервера и блокируется до его остановки. После Shutdown возвращает nil.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve обрабатывает запросы, принятые listener, до остановки сервера
+func (s *Server) Serve(listener net.Listener) error {
+	// Порт уже открыт: соединения ждут в очереди listener, пока сервер не начнет их принимать
+	s.Handler.listening.Store(true)
+	defer s.Handler.listening.Store(false)
+
+	var err error
+	if s.certFile != "" {
+		err = s.httpServer.ServeTLS(listener, s.certFile, s.keyFile)
+	} else {
+		err = s.httpServer.Serve(listener)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// drainShare — доля срока остановки на ожидание вычисления выражений; остаток
+// оставляется текущим запросам, например результатам, которые присылают агенты
+const drainShare = 0.75
+
+// Shutdown плавно останавливает сервер: перестает принимать новые выражения, ждет
+// вычисления принятых (агенты продолжают получать задачи и присылать результаты),
+// затем закрывает HTTP-сервер, дожидаясь обработки текущих запросов. Ожидание выражений
+// занимает не больше drainShare срока ctx. Если срок ctx истек раньше, оставшиеся
+// соединения закрываются принудительно.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Handler.StartDraining()
+	drainCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		drainCtx, cancel = context.WithTimeout(ctx, time.Duration(float64(time.Until(deadline))*drainShare))
+		defer cancel()
+	}
+	if err := s.Handler.App.Drain(drainCtx); err != nil {
+		slog.Warn("Expressions were not finished before shutdown", "pending", s.Handler.App.PendingExpressions(), "error", err)
+	}
 
-	return http.ListenAndServe(port, nil)
+	// Агенты, ожидающие задачу, получают ответ сразу, чтобы не задерживать остановку
+	s.Handler.StopWaiting()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		slog.Warn("Closing remaining connections", "error", err)
+		s.httpServer.Close()
+		return err
+	}
+	return nil
 }
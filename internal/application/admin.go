@@ -0,0 +1,172 @@
+package application
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Состояния задач невыполненных выражений (см. ListTasks)
+const (
+	TaskStateReady     = "ready"     // В очереди, ждет агента
+	TaskStateDependent = "dependent" // Ждет результатов других задач или условий ветвей
+	TaskStateInFlight  = "in_flight" // Выдана агенту, результата еще нет
+	TaskStateCoalesced = "coalesced" // Ждет результата такой же задачи, выданной агенту
+)
+
+// TaskInfo — задача невыполненного выражения с ее состоянием в планировщике
+type TaskInfo struct {
+	Task         *Task     // Снимок задачи
+	State        string    // ready, dependent, in_flight или coalesced
+	ExpressionID int       // Выражение задачи
+	Expression   string    // Текст выражения
+	IssuedAt     time.Time // Когда задача выдана агенту (для in_flight)
+}
+
+// ListTasks возвращает задачи невыполненных выражений, упорядоченные по ID:
+// готовые, зависимые, выданные агентам и объединенные с выданными
+func (app *Application) ListTasks() []TaskInfo {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	states := make(map[int]string)
+	for _, task := range app.taskQueue {
+		states[task.ID] = TaskStateReady
+	}
+	for id := range app.dependent {
+		states[id] = TaskStateDependent
+	}
+	for _, followers := range app.followers {
+		for _, follower := range followers {
+			states[follower.ID] = TaskStateCoalesced
+		}
+	}
+	for id, task := range app.tasks {
+		if _, known := states[id]; !known && app.inFlight(task) {
+			states[id] = TaskStateInFlight
+		}
+	}
+
+	tasks := make([]TaskInfo, 0, len(states))
+	for id, state := range states {
+		task := app.tasks[id]
+		expr := app.expressionOf(task)
+		if task.Status != "pending" || expr.Status != "pending" {
+			continue
+		}
+		info := TaskInfo{Task: task.snapshot(), State: state, ExpressionID: expr.ID, Expression: expr.Value}
+		if state == TaskStateInFlight {
+			info.IssuedAt = task.issuedAt
+		}
+		tasks = append(tasks, info)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Task.ID < tasks[j].Task.ID })
+	return tasks
+}
+
+// inFlight сообщает, что задача выдана агенту и ее результат еще не получен.
+// Вызывается под мьютексом приложения.
+func (app *Application) inFlight(task *Task) bool {
+	return !task.issuedAt.IsZero() && task.Status == "pending" && app.expressionOf(task).Status == "pending"
+}
+
+// queued сообщает, что задача стоит в очереди агентов. Вызывается под мьютексом приложения.
+func (app *Application) queued(task *Task) bool {
+	for _, queued := range app.taskQueue {
+		if queued == task {
+			return true
+		}
+	}
+	return false
+}
+
+// RequeueTask возвращает в очередь задачу, выданную агенту, но не выполненную
+// (например, агент завис или остановился). Принимается первый присланный результат:
+// если его пришлет первый агент, повторная выдача задачи пропускается, а результат
+// второго агента отклоняется с ErrTaskNotInFlight.
+func (app *Application) RequeueTask(taskID int) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	task, exists := app.tasks[taskID]
+	if !exists {
+		return fmt.Errorf("task with ID %d: %w", taskID, ErrTaskNotFound)
+	}
+	if !app.inFlight(task) || app.queued(task) {
+		return fmt.Errorf("task with ID %d: %w", taskID, ErrTaskNotInFlight)
+	}
+
+	// Отметка выдачи остается: результат первого агента по-прежнему принимается
+	app.pushTask(task)
+	app.logger().Info("Task requeued", "expression_id", app.taskToExpression[taskID], "task_id", taskID)
+	return nil
+}
+
+// FailExpression принудительно завершает невычисленное выражение ошибкой reason:
+// его задачи снимаются с очередей, результаты уже выданных задач не принимаются
+func (app *Application) FailExpression(exprID int, reason string) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	expr, exists := app.expressions[exprID]
+	if !exists {
+		return app.missingExpression(exprID)
+	}
+	if expr.Status != "pending" {
+		return fmt.Errorf("expression ID %d: %w", exprID, ErrExpressionNotPending)
+	}
+	if reason == "" {
+		reason = "failed by administrator"
+	}
+	app.failExpression(expr, fmt.Errorf("expression ID %d: %s", exprID, reason))
+	return nil
+}
+
+// PurgeExpressions удаляет выражения, завершенные (вычисленные, с ошибкой или по сроку)
+// раньше чем olderThan назад, вместе с их задачами. Возвращает количество удаленных выражений.
+func (app *Application) PurgeExpressions(olderThan time.Duration) int {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	var purged []*Expression
+	for _, expr := range app.expressions {
+		if app.evictable(expr) && !expr.finishedAt.After(cutoff) {
+			purged = append(purged, expr)
+		}
+	}
+	app.removeExpressions(purged)
+	if len(purged) > 0 {
+		app.logger().Info("Expressions purged", "count", len(purged), "older_than", olderThan)
+	}
+	return len(purged)
+}
+
+// PauseDispatch приостанавливает выдачу задач агентам. Выражения принимаются,
+// результаты выданных задач — тоже; агенты, ожидающие задачу, продолжают ждать.
+func (app *Application) PauseDispatch() {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if !app.paused {
+		app.paused = true
+		app.logger().Info("Task dispatch paused")
+	}
+}
+
+// ResumeDispatch возобновляет выдачу задач и будит ожидающих агентов
+func (app *Application) ResumeDispatch() {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.paused {
+		app.paused = false
+		app.notifyTaskReady()
+		app.logger().Info("Task dispatch resumed")
+	}
+}
+
+// DispatchPaused сообщает, что выдача задач приостановлена
+func (app *Application) DispatchPaused() bool {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	return app.paused
+}
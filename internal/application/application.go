@@ -1,13 +1,16 @@
 package application
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/syirnik/GO_Yandex/internal/tracing"
 	"github.com/syirnik/GO_Yandex/pkg/calculation"
 )
 
@@ -22,15 +25,120 @@ type Task struct {
 	OperationTime int64   `json:"operation_time"` // Время выполнения задачи
 	ParentTasks   []int   `json:"parent_tasks"`   // ID родительских задач
 	IsReady       bool    `json:"is_ready"`       // Флаг готовности задачи
+
+	// Точные значения в числовом режиме выражения; Arg1, Arg2 и Result — их приближения float64
+	Mode        calculation.Mode `json:"mode,omitempty"`
+	Scale       int              `json:"scale,omitempty"` // Знаков после точки в режиме decimal
+	Arg1Value   string           `json:"arg1_value,omitempty"`
+	Arg2Value   string           `json:"arg2_value,omitempty"`
+	ResultValue string           `json:"result_value,omitempty"`
+
+	// Условия ветвей условного оператора: задача выполняется, только если все они выполнены
+	Guards []Guard `json:"guards,omitempty"`
+
+	RequestID   string `json:"request_id,omitempty"`  // Идентификатор запроса, создавшего выражение
+	Traceparent string `json:"traceparent,omitempty"` // Контекст трассировки для агента (W3C traceparent)
+
+	waiting  int       // Сколько родительских задач и условий еще без результата
+	issuedAt time.Time // Когда задача выдана агенту
+	backend  calculation.Backend
+}
+
+// Guard — условие выполнения задачи: результат задачи TaskID истинен (When) или ложен (!When)
+type Guard struct {
+	TaskID int  `json:"task_id"`
+	When   bool `json:"when"`
+}
+
+// snapshot возвращает копию задачи для передачи за пределы мьютекса приложения
+func (t *Task) snapshot() *Task {
+	c := *t
+	return &c
+}
+
+// setArg подставляет значение аргумента задачи: index 0 — Arg1, 1 — Arg2
+func (t *Task) setArg(index int, value string) {
+	if index == 0 {
+		t.Arg1Value = value
+		t.Arg1 = t.backend.Float(value)
+	} else {
+		t.Arg2Value = value
+		t.Arg2 = t.backend.Float(value)
+	}
+}
+
+// divisionByZero сообщает, что готовая задача делит на ноль
+func (t *Task) divisionByZero() bool {
+	return (t.Operation == "/" || t.Operation == "%") && t.backend.IsZero(t.Arg2Value)
 }
 
 // Expression представляет выражение, состоящее из задач
 type Expression struct {
-	ID     int
-	Value  string
-	Tasks  []*Task
-	Status string
-	Result float64
+	ID         int
+	Value      string
+	Owner      string // Клиент, отправивший выражение
+	Tasks      []*Task
+	Status     string
+	Result     float64
+	TasksSaved int // Сколько задач сэкономила оптимизация выражения
+
+	Mode        calculation.Mode // Числовой режим вычисления
+	Scale       int              // Знаков после точки в режиме decimal
+	ResultValue string           // Точный результат в режиме Mode
+	Error       string           // Причина ошибки для выражения в статусе failed
+	Boolean     bool             // Результат логический: 1 — истина, 0 — ложь
+	Deadline    time.Time        // Срок вычисления; после него выражение переходит в статус timeout
+	RequestID   string           // Идентификатор запроса, создавшего выражение
+	TraceID     string           // Трасса выражения; пустая, если трассировка выключена
+
+	rootID     int       // Задача с итоговым результатом
+	values     []int     // Числа выражения: их результаты удаляются из taskResults вместе с результатами задач
+	finishedAt time.Time // Когда выражение вычислено или завершено ошибкой
+	timer      *time.Timer
+
+	idempotencyKey string        // Ключ повторной отправки в app.idempotency; пустой — без ключа
+	span           *tracing.Span // Корневой span трассы выражения; завершается вместе с выражением
+	backend        calculation.Backend
+}
+
+// snapshot возвращает копию выражения вместе с копиями его задач
+func (e *Expression) snapshot() *Expression {
+	c := *e
+	c.Tasks = make([]*Task, len(e.Tasks))
+	for i, task := range e.Tasks {
+		c.Tasks[i] = task.snapshot()
+	}
+	return &c
+}
+
+// TaskObserver получает события задач, выполняемых агентами (например, для метрик).
+// Методы вызываются под мьютексом приложения и не должны обращаться к нему.
+type TaskObserver interface {
+	TaskIssued(operation string)                           // Задача выдана агенту
+	TaskCompleted(operation string, latency time.Duration) // Агент прислал результат; latency — с момента выдачи
+	TaskFailed(operation string)                           // Агент сообщил об ошибке вычисления
+}
+
+// ExpressionOptions задает дополнительные параметры создания выражения
+type ExpressionOptions struct {
+	Owner    string // Идентификатор клиента (IP или пользователь) для квот
+	Optimize bool   // Упростить выражение и объединить общие подвыражения перед созданием задач
+
+	Mode  calculation.Mode // Числовой режим; пустой — float64
+	Scale int              // Знаков после точки в режиме decimal; 0 — DECIMAL_SCALE
+
+	Deadline time.Time // Срок вычисления; нулевой — без срока
+
+	RequestID string // Идентификатор HTTP-запроса; передается агентам вместе с задачами
+
+	// Ключ повторной отправки: повтор с тем же ключом и отпечатком тела RequestHash
+	// возвращает уже созданное выражение, с другим отпечатком — ErrIdempotencyConflict
+	IdempotencyKey string
+	RequestHash    string
+
+	// Корневой span трассы выражения; приложение завершает его вместе с выражением.
+	// Если не задан, а трассировка включена, приложение создает его само.
+	Span *tracing.Span
 }
 
 // Application управляет очередью задач и выражениями
@@ -38,11 +146,32 @@ type Application struct {
 	nextTaskID       int
 	nextExpressionID int
 	expressions      map[int]*Expression
-	taskToExpression map[int]int
-	taskQueue        []*Task         // Очередь готовых задач
-	dependentQueue   []*Task         // Очередь зависимых задач
-	taskResults      map[int]float64 // Хранение выполненных задач
-	mu               sync.Mutex
+	tasks            map[int]*Task                // Индекс задач по ID
+	taskToExpression map[int]int                  // ID задачи -> ID выражения
+	taskQueue        []*Task                      // Очередь готовых задач
+	taskReady        chan struct{}                // Закрывается, когда в очереди появляются задачи
+	paused           bool                         // Выдача задач агентам приостановлена (PauseDispatch)
+	dependent        map[int]*Task                // Задачи, ожидающие результатов других задач
+	children         map[int][]*Task              // ID задачи -> зависимые задачи, ожидающие ее результата
+	taskResults      map[int]string               // Результаты задач невыполненных выражений в режиме их выражения
+	pendingByOwner   map[string]int               // Количество невыполненных выражений клиента
+	idempotency      map[string]idempotencyRecord // Ключ повторной отправки -> созданное выражение
+
+	cache     *resultCache    // Кэш результатов операций
+	inflight  map[string]int  // Ключ операции -> ID такой же задачи, выполняемой агентом
+	followers map[int][]*Task // ID задачи в работе -> задачи, ждущие ее результата
+
+	maxPendingExpressions int                         // Квота невыполненных выражений на клиента
+	limits                calculation.Limits          // Ограничения размера и сложности выражений
+	decimalScale          int                         // Знаков после точки в режиме decimal по умолчанию
+	idempotencyTTL        time.Duration               // Сколько помнить ключи повторной отправки; 0 — не помнить
+	observer              TaskObserver                // Получатель событий задач; nil — без наблюдения
+	tracer                *tracing.Tracer             // Трассировка этапов выражений; nil — без трассировки
+	log                   atomic.Pointer[slog.Logger] // Журнал приложения; nil — журнал по умолчанию (slog.Default)
+
+	// Изменяющие методы берут mu на запись, читающие — на чтение и возвращают копии
+	// (снимки) выражений и задач, чтобы вызывающий код не читал их одновременно с планировщиком
+	mu sync.RWMutex
 }
 
 // New создает новый экземпляр Application
@@ -51,238 +180,667 @@ func New() *Application {
 		nextTaskID:       1,
 		nextExpressionID: 1,
 		expressions:      make(map[int]*Expression),
+		tasks:            make(map[int]*Task),
 		taskToExpression: make(map[int]int),
 		taskQueue:        []*Task{},
-		dependentQueue:   []*Task{},
-		taskResults:      make(map[int]float64),
+		taskReady:        make(chan struct{}),
+		dependent:        make(map[int]*Task),
+		children:         make(map[int][]*Task),
+		taskResults:      make(map[int]string),
+		pendingByOwner:   make(map[string]int),
+		idempotency:      make(map[string]idempotencyRecord),
+		cache:            newResultCache(getResultCacheSize()),
+		inflight:         make(map[string]int),
+		followers:        make(map[int][]*Task),
+
+		maxPendingExpressions: getEnvLimit("MAX_PENDING_EXPRESSIONS", defaultMaxPendingExpressions),
+		limits: calculation.Limits{
+			MaxBytes:  getEnvLimit("MAX_EXPRESSION_BYTES", calculation.DefaultLimits.MaxBytes),
+			MaxTokens: getEnvLimit("MAX_EXPRESSION_TOKENS", calculation.DefaultLimits.MaxTokens),
+			MaxDepth:  getEnvLimit("MAX_PARENTHESES_DEPTH", calculation.DefaultLimits.MaxDepth),
+			MaxTasks:  getEnvLimit("MAX_TASKS_PER_EXPRESSION", calculation.DefaultLimits.MaxTasks),
+		},
+		decimalScale:   getEnvLimit("DECIMAL_SCALE", calculation.DefaultDecimalScale),
+		idempotencyTTL: time.Duration(getEnvLimit("IDEMPOTENCY_KEY_TTL_MS", defaultIdempotencyTTLMs)) * time.Millisecond,
 	}
 }
 
+// Limits возвращает ограничения размера и сложности выражений
+func (app *Application) Limits() calculation.Limits {
+	return app.limits
+}
+
 // ParseExpression разбирает выражение и создает задачи
 func (app *Application) ParseExpression(expression string) (int, error) {
-	app.mu.Lock()
-	defer app.mu.Unlock()
+	return app.ParseExpressionWithOptions(expression, ExpressionOptions{})
+}
 
-	log.Printf("ParseExpression: Processing expression: %s", expression)
+// ParseExpressionWithOptions разбирает выражение с учетом параметров клиента и квот
+func (app *Application) ParseExpressionWithOptions(expression string, opts ExpressionOptions) (int, error) {
+	return app.ParseExpressionContext(context.Background(), expression, opts)
+}
 
-	tokens := calculation.Tokenize(expression)
-	log.Printf("ParseExpression: Tokenized expression: %v", tokens)
+// ParseExpressionContext разбирает выражение, если контекст запроса еще не отменен.
+// Срок вычисления выражения задается в opts.Deadline и от контекста не зависит.
+func (app *Application) ParseExpressionContext(ctx context.Context, expression string, opts ExpressionOptions) (int, error) {
+	exprID, _, err := app.SubmitExpression(ctx, expression, opts)
+	return exprID, err
+}
 
-	postfix, err := calculation.InfixToPostfix(tokens)
+// SubmitExpression разбирает выражение, как ParseExpressionContext, с учетом ключа
+// повторной отправки opts.IdempotencyKey. Повтор запроса с тем же ключом возвращает
+// ID созданного раньше выражения и created == false.
+func (app *Application) SubmitExpression(ctx context.Context, expression string, opts ExpressionOptions) (exprID int, created bool, err error) {
+	// Трасса выражения: корневой span живет до завершения выражения, разбор — его этап
+	if opts.Span == nil {
+		opts.Span = app.tracer.StartWithParent(tracing.SpanContext{}, "expression")
+	}
+	parent, ok := tracing.SpanContextFrom(ctx)
+	if !ok {
+		parent = opts.Span.Context()
+	}
+	span := app.tracer.StartWithParent(parent, "ParseExpression")
+	span.SetAttribute("expression.text", expression)
+	defer span.End()
+
+	exprID, created, err = app.parseExpression(ctx, expression, opts)
 	if err != nil {
-		return 0, fmt.Errorf("error converting to postfix: %w", err)
+		span.SetError(err)
+		opts.Span.SetError(err)
+		opts.Span.End()
+		return 0, false, err
+	}
+	span.SetAttribute("expression.id", exprID)
+	if !created {
+		// Выражение создано раньше и продолжает свою трассу; эта трасса — только повтор запроса
+		span.SetAttribute("expression.replayed", true)
+		opts.Span.End()
 	}
+	return exprID, created, nil
+}
 
-	log.Printf("ParseExpression: Postfix notation: %v", postfix)
+// parseExpression разбирает выражение и ставит его задачи в очереди. Для повтора
+// запроса с известным ключом возвращает созданное раньше выражение и created == false.
+func (app *Application) parseExpression(ctx context.Context, expression string, opts ExpressionOptions) (exprID int, created bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
 
-	var tasks []*Task
-	stack := []int{}
+	app.logger().Debug("Processing expression", "expression", expression, "request_id", opts.RequestID)
 
-	// Для каждой задачи в постфиксной записи
-	for _, token := range postfix {
-		log.Printf("ParseExpression: Processing token: %s", token)
+	// Повтор запроса не создает выражение заново и не расходует квоту клиента
+	now := time.Now()
+	if exprID, found, err := app.replay(opts, now); err != nil || found {
+		if found {
+			app.logger().Info("Expression submission replayed", "expression_id", exprID, "request_id", opts.RequestID)
+		}
+		return exprID, false, err
+	}
 
-		if isNumber(token) {
-			// Число — это уже "выполненная задача"
-			value, err := strconv.ParseFloat(token, 64)
-			if err != nil {
-				return 0, fmt.Errorf("invalid number format in expression: %s", token)
-			}
-			taskID := app.nextTaskID
-			app.taskResults[taskID] = value // Записываем число как результат
-			stack = append(stack, taskID)
-			log.Printf("ParseExpression: Created task for number %s with ID %d", token, taskID)
-			app.nextTaskID++
-		} else if isOperator(token) {
-			if len(stack) < 2 {
-				return 0, errors.New("invalid expression format: not enough values in stack")
-			}
+	// Проверяем квоту невыполненных выражений клиента
+	if opts.Owner != "" && app.maxPendingExpressions > 0 && app.pendingByOwner[opts.Owner] >= app.maxPendingExpressions {
+		app.logger().Info("Client exceeded pending expressions quota", "client", opts.Owner, "quota", app.maxPendingExpressions)
+		return 0, false, ErrTooManyPendingExpressions
+	}
 
-			// Извлекаем операнды для текущей операции
-			task2ID := stack[len(stack)-1] // Второй операнд
-			task1ID := stack[len(stack)-2] // Первый операнд
-			stack = stack[:len(stack)-2]
+	// Проверяем размер выражения до разбора
+	if err := calculation.CheckLimits(expression, app.limits); err != nil {
+		return 0, false, err
+	}
 
-			log.Printf("ParseExpression: Creating task for operator %s, using task IDs: %d, %d", token, task1ID, task2ID)
+	// Выбираем арифметику числового режима
+	scale := opts.Scale
+	if scale == 0 {
+		scale = app.decimalScale
+	}
+	backend, err := calculation.NewBackend(opts.Mode, scale)
+	if err != nil {
+		return 0, false, err
+	}
+	if backend.Mode() != calculation.ModeDecimal {
+		scale = 0
+	}
 
-			// Создание задачи на операцию
-			task := &Task{
-				ID:            app.nextTaskID,
-				Operation:     token,
-				Status:        "pending",
-				ParentTasks:   []int{task1ID, task2ID},
-				OperationTime: app.getOperationTime(token),
-			}
+	tree, err := calculation.ParseInMode(expression, backend.Mode())
+	if err != nil {
+		return 0, false, fmt.Errorf("error parsing expression: %w", err)
+	}
+	app.logger().Debug("Parsed expression", "tree", treeValue{tree})
 
-			// Присваиваем аргументы, если они уже вычислены
-			if res1, exists1 := app.taskResults[task1ID]; exists1 {
-				task.Arg1 = res1
-				log.Printf("ParseExpression: Arg1 for task ID %d set to %f", task.ID, res1)
-			}
-			if res2, exists2 := app.taskResults[task2ID]; exists2 {
-				task.Arg2 = res2
-				log.Printf("ParseExpression: Arg2 for task ID %d set to %f", task.ID, res2)
-			}
-			// Проверяем, известны ли оба аргумента
-			_, exists1 := app.taskResults[task1ID]
-			_, exists2 := app.taskResults[task2ID]
-			if exists1 && exists2 {
-				// Проверка деления на ноль
-				if token == "/" && task.Arg2 == 0 {
-					return 0, errors.New("division by zero detected")
-				}
-				task.IsReady = true
-				log.Printf("ParseExpression: Task ID %d is ready (IsReady = %v)", task.ID, task.IsReady)
-				app.taskQueue = append(app.taskQueue, task)
-				log.Printf("ParseExpression: Task ID %d added to taskQueue", task.ID)
-			} else {
-				app.dependentQueue = append(app.dependentQueue, task) // Иначе откладываем задачу
-				log.Printf("ParseExpression: Task ID %d added to dependentQueue", task.ID)
-			}
+	// Оптимизация: упрощаем тождественные операции и сворачиваем числа, общие подвыражения
+	// объединяет builder. Тип результата определяется по исходному дереву.
+	operations := calculation.CountOperations(tree)
+	boolean := calculation.IsBoolean(tree)
+	if opts.Optimize {
+		tree = calculation.SimplifyWith(tree, backend)
+		app.logger().Debug("Simplified expression", "tree", treeValue{tree})
+	}
 
-			tasks = append(tasks, task)
-			stack = append(stack, task.ID)
-			log.Printf("ParseExpression: Task ID %d added to stack", task.ID)
-			app.nextTaskID++
-		}
+	// Переводим дерево в граф задач
+	builder := newTaskBuilder(app, backend, scale, opts.Optimize)
+	rootID, err := builder.build(tree)
+	if err != nil {
+		return 0, false, err
 	}
 
-	// Если все задачи созданы правильно
-	if len(stack) != 1 {
-		return 0, errors.New("invalid expression: stack not reduced to single result")
+	// Выражение корректно: фиксируем результаты чисел и ставим задачи в очереди
+	app.nextTaskID = builder.nextTaskID
+	values := make([]int, 0, len(builder.results))
+	for taskID, value := range builder.results {
+		app.taskResults[taskID] = value
+		values = append(values, taskID)
 	}
+	tasks := builder.tasks
 
 	// Создаем выражение
-	exprID := app.nextExpressionID
+	exprID = app.nextExpressionID
 	app.nextExpressionID++
 
 	expr := &Expression{
-		ID:     exprID,
-		Value:  expression,
-		Tasks:  tasks,
-		Status: "pending",
+		ID:       exprID,
+		Value:    expression,
+		Owner:    opts.Owner,
+		Tasks:    tasks,
+		Status:   "pending",
+		Mode:     backend.Mode(),
+		Scale:    scale,
+		Boolean:  boolean,
+		Deadline: opts.Deadline,
+
+		RequestID: opts.RequestID,
+		TraceID:   traceIDOf(opts.Span),
+
+		span:    opts.Span,
+		rootID:  rootID,
+		values:  values,
+		backend: backend,
+	}
+	if opts.Optimize {
+		expr.TasksSaved = operations - len(tasks)
 	}
 
 	app.expressions[exprID] = expr
-	log.Printf("ParseExpression: Created expression ID %d with %d tasks", exprID, len(tasks))
-	return exprID, nil
+	app.rememberKey(expr, opts, now)
+	for _, task := range tasks {
+		task.RequestID = opts.RequestID
+		app.tasks[task.ID] = task
+		app.taskToExpression[task.ID] = exprID
+	}
+	if expr.Owner != "" {
+		app.pendingByOwner[expr.Owner]++
+	}
+	expr.span.SetAttribute("expression.id", exprID)
+	expr.span.SetAttribute("expression.mode", string(expr.Mode))
+	expr.span.SetAttribute("expression.tasks", len(tasks))
+
+	// Выражение из одного числа не содержит задач и готово сразу
+	if len(tasks) == 0 {
+		app.completeExpression(expr, app.taskResults[rootID])
+	}
+
+	// Зависимые и готовые задачи ставим в очереди после регистрации выражения: задачи из кэша завершаются сразу
+	app.addDependent(builder.dependent)
+	for _, task := range builder.ready {
+		if err := app.enqueueReady(task); err != nil {
+			app.logger().Error("Failed to enqueue task", "expression_id", exprID, "task_id", task.ID, "error", err)
+		}
+	}
+
+	// Выражение, не вычисленное к сроку, завершается по таймеру
+	if !opts.Deadline.IsZero() && expr.Status == "pending" {
+		expr.timer = time.AfterFunc(time.Until(opts.Deadline), func() { app.expireExpression(exprID) })
+	}
+	app.logger().Info("Created expression", "expression_id", exprID, "tasks", len(tasks), "request_id", opts.RequestID)
+	return exprID, true, nil
 }
 
-// CompleteTask принимает результат выполнения задачи
-func (app *Application) CompleteTask(taskID int, result float64) error {
+// traceIDOf возвращает идентификатор трассы span'а или пустую строку без трассировки
+func traceIDOf(span *tracing.Span) string {
+	sc := span.Context()
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID.String()
+}
+
+// completeExpression переводит выражение в статус completed и освобождает квоту клиента
+func (app *Application) completeExpression(expr *Expression, value string) {
+	if expr.Status != "pending" {
+		return
+	}
+	expr.Status = "completed"
+	expr.finishedAt = time.Now()
+	expr.ResultValue = value
+	expr.Result = expr.backend.Float(value)
+	app.releaseOwner(expr)
+	app.forgetResults(expr)
+	if expr.timer != nil {
+		expr.timer.Stop()
+	}
+	expr.span.SetAttribute("expression.status", expr.Status)
+	expr.span.SetAttribute("expression.result", value)
+	expr.span.End()
+}
+
+// failExpression переводит выражение в статус failed
+func (app *Application) failExpression(expr *Expression, reason error) {
+	app.abortExpression(expr, "failed", reason)
+}
+
+// expireExpression переводит выражение, не вычисленное к сроку, в статус timeout
+func (app *Application) expireExpression(exprID int) {
 	app.mu.Lock()
 	defer app.mu.Unlock()
 
-	// Сохраняем результат в карте результатов
-	app.taskResults[taskID] = result
-	log.Printf("CompleteTask: Task ID %d completed with result: %.2f", taskID, result)
-
-	// Находим задачу в выражении и обновляем её статус и результат
-	var taskUpdated bool
-	for exprID, expr := range app.expressions {
-		for _, task := range expr.Tasks {
-			if task.ID == taskID {
-				task.Status = "completed"
-				task.Result = result
-				taskUpdated = true
-				log.Printf("CompleteTask: Updated task ID %d status to 'completed' in expression ID %d", taskID, exprID)
-
-				// Проверяем, все ли задачи в выражении завершены
-				allCompleted := true
-				for _, t := range expr.Tasks {
-					if t.Status != "completed" {
-						allCompleted = false
-						break
-					}
-				}
-
-				// Если все задачи завершены, обновляем статус выражения
-				if allCompleted {
-					// Последняя задача в списке должна содержать финальный результат
-					finalTask := expr.Tasks[len(expr.Tasks)-1]
-					expr.Status = "completed"
-					expr.Result = finalTask.Result
-					log.Printf("CompleteTask: Expression ID %d completed with result %.2f", exprID, expr.Result)
-				}
-				break
-			}
-		}
-		if taskUpdated {
-			break
+	if expr, exists := app.expressions[exprID]; exists {
+		app.abortExpression(expr, "timeout", ErrExpressionTimeout)
+	}
+}
+
+// abortExpression завершает невычисленное выражение со статусом status, снимает
+// его задачи с очередей и освобождает квоту клиента
+func (app *Application) abortExpression(expr *Expression, status string, reason error) {
+	if expr.Status != "pending" {
+		return
+	}
+	expr.Status = status
+	expr.finishedAt = time.Now()
+	expr.Error = reason.Error()
+	app.releaseOwner(expr)
+	app.withdrawTasks(expr)
+	app.forgetResults(expr)
+	if expr.timer != nil {
+		expr.timer.Stop()
+	}
+	expr.span.SetAttribute("expression.status", status)
+	expr.span.SetError(reason)
+	expr.span.End()
+	app.logger().Info("Expression aborted", "expression_id", expr.ID, "status", status, "reason", reason)
+}
+
+// expressionOf возвращает выражение, которому принадлежит задача
+func (app *Application) expressionOf(task *Task) *Expression {
+	return app.expressions[app.taskToExpression[task.ID]]
+}
+
+// withdrawTasks убирает задачи выражения из очередей. Если снятая задача (в очереди или
+// уже выданная агенту) была единственной отправляемой агентам среди одинаковых,
+// ее место занимает ожидавшая задача: результат снятой задачи больше не принимается.
+func (app *Application) withdrawTasks(expr *Expression) {
+	withdrawn := make(map[int]bool, len(expr.Tasks))
+	for _, task := range expr.Tasks {
+		withdrawn[task.ID] = true
+	}
+	app.dropFollowers(withdrawn)
+
+	var taskQueue []*Task
+	for _, task := range app.taskQueue {
+		if !withdrawn[task.ID] {
+			taskQueue = append(taskQueue, task)
 		}
 	}
+	app.taskQueue = taskQueue
 
-	// Проверяем, была ли найдена и обновлена задача
-	if !taskUpdated {
-		log.Printf("CompleteTask: Task ID %d not found", taskID)
-		return fmt.Errorf("task with ID %d not found", taskID)
-	}
-
-	// Обновляем зависимые задачи
-	var newDependentQueue []*Task
-	for _, task := range app.dependentQueue {
-		ready := true
-		log.Printf("CompleteTask: Checking dependencies for task ID %d", task.ID)
-
-		// Проверяем все родительские задачи
-		for i, parentID := range task.ParentTasks {
-			if res, exists := app.taskResults[parentID]; exists {
-				// Подставляем результаты в соответствующие аргументы задачи
-				if i == 0 {
-					task.Arg1 = res
-					log.Printf("CompleteTask: Arg1 for task ID %d set to %f from parent ID %d", task.ID, res, parentID)
-				} else if i == 1 {
-					task.Arg2 = res
-					log.Printf("CompleteTask: Arg2 for task ID %d set to %f from parent ID %d", task.ID, res, parentID)
-				}
-			} else {
-				ready = false
-				log.Printf("CompleteTask: Task ID %d is not ready, waiting for parent task ID %d", task.ID, parentID)
-				break
+	for _, task := range expr.Tasks {
+		delete(app.dependent, task.ID)
+		app.handOver(task)
+	}
+}
+
+// handOver передает роль отправляемой агентам задачи первой ожидавшей ее результата
+// задаче невыполненного выражения и ставит ту в очередь
+func (app *Application) handOver(task *Task) {
+	if !app.cache.enabled() {
+		return
+	}
+	key := taskCacheKey(task)
+	if app.inflight[key] != task.ID {
+		return
+	}
+	delete(app.inflight, key)
+	followers := app.followers[task.ID]
+	delete(app.followers, task.ID)
+	for i, follower := range followers {
+		if app.expressionOf(follower).Status == "pending" {
+			app.inflight[key] = follower.ID
+			if rest := followers[i+1:]; len(rest) > 0 {
+				app.followers[follower.ID] = rest
 			}
+			app.pushTask(follower)
+			return
 		}
+	}
+}
 
-		// Если все зависимости выполнены, добавляем задачу в taskQueue
-		if ready {
-			// Проверка деления на ноль перед добавлением в очередь
-			if task.Operation == "/" && task.Arg2 == 0 {
-				log.Printf("CompleteTask: Division by zero detected for task ID %d", task.ID)
-				return errors.New("division by zero detected")
+// dropFollowers убирает задачи из списков ожидающих результата такой же задачи:
+// снятые задачи не должны завершаться и завершать ошибкой чужие выражения
+func (app *Application) dropFollowers(dropped map[int]bool) {
+	for primaryID, followers := range app.followers {
+		var kept []*Task
+		for _, follower := range followers {
+			if !dropped[follower.ID] {
+				kept = append(kept, follower)
 			}
-			// Логируем изменение флага готовности
-			if !task.IsReady {
-				task.IsReady = true
-				log.Printf("CompleteTask: Task ID %d is now ready (IsReady = %v)", task.ID, task.IsReady)
-			}
-			app.taskQueue = append(app.taskQueue, task)
-			log.Printf("CompleteTask: Task ID %d added to taskQueue", task.ID)
+		}
+		if len(kept) == len(followers) {
+			continue
+		}
+		if len(kept) == 0 {
+			delete(app.followers, primaryID)
 		} else {
-			newDependentQueue = append(newDependentQueue, task)
-			log.Printf("CompleteTask: Task ID %d is not ready, remaining in dependentQueue", task.ID)
+			app.followers[primaryID] = kept
 		}
 	}
+}
+
+// forgetResults удаляет результаты чисел и задач завершенного выражения и его зависимости:
+// другим выражениям они не нужны, а итог хранится в самом выражении
+func (app *Application) forgetResults(expr *Expression) {
+	for _, taskID := range expr.values {
+		delete(app.taskResults, taskID)
+	}
+	for _, task := range expr.Tasks {
+		delete(app.taskResults, task.ID)
+		delete(app.dependent, task.ID)
+		delete(app.children, task.ID)
+	}
+}
+
+// releaseOwner уменьшает счетчик невыполненных выражений клиента
+func (app *Application) releaseOwner(expr *Expression) {
+	if expr.Owner != "" {
+		app.pendingByOwner[expr.Owner]--
+		if app.pendingByOwner[expr.Owner] <= 0 {
+			delete(app.pendingByOwner, expr.Owner)
+		}
+	}
+}
+
+// CompleteTask принимает результат выполнения задачи в виде float64
+func (app *Application) CompleteTask(taskID int, result float64) error {
+	return app.CompleteTaskContext(context.Background(), taskID, result)
+}
+
+// CompleteTaskContext принимает результат в виде float64, если контекст запроса еще не отменен
+func (app *Application) CompleteTaskContext(ctx context.Context, taskID int, result float64) error {
+	return app.CompleteTaskValueContext(ctx, taskID, strconv.FormatFloat(result, 'g', -1, 64))
+}
+
+// CompleteTaskValue принимает точный результат задачи в записи числового режима ее выражения
+func (app *Application) CompleteTaskValue(taskID int, value string) error {
+	return app.CompleteTaskValueContext(context.Background(), taskID, value)
+}
+
+// CompleteTaskValueContext принимает точный результат, если контекст запроса еще не отменен
+func (app *Application) CompleteTaskValueContext(ctx context.Context, taskID int, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	span := app.startTaskSpan(ctx, taskID, "CompleteTask")
+	defer span.End()
+	if err := app.checkIssued(taskID); err != nil {
+		span.SetError(err)
+		return err
+	}
+	if err := app.completeTask(taskID, value); err != nil {
+		span.SetError(err)
+		return err
+	}
+	if task := app.tasks[taskID]; app.observer != nil && !task.issuedAt.IsZero() {
+		app.observer.TaskCompleted(task.Operation, time.Since(task.issuedAt))
+	}
+	return nil
+}
+
+// checkIssued проверяет, что результат задачи можно принять от агента: задача выдана
+// и еще не выполнена, а ее выражение не завершено. Результаты задач, ждущих других задач,
+// повторные и опоздавшие результаты отклоняются с ErrTaskNotInFlight.
+// Вызывается под мьютексом приложения.
+func (app *Application) checkIssued(taskID int) error {
+	task, exists := app.tasks[taskID]
+	if !exists {
+		app.logger().Warn("Task not found", "task_id", taskID)
+		return fmt.Errorf("task with ID %d: %w", taskID, ErrTaskNotFound)
+	}
+	if !app.inFlight(task) {
+		app.logger().Warn("Result for task not issued to an agent", "task_id", taskID, "status", task.Status)
+		return fmt.Errorf("task with ID %d: %w", taskID, ErrTaskNotInFlight)
+	}
+	return nil
+}
+
+// completeTask сохраняет результат задачи, завершает выражение и продвигает зависимые задачи.
+// Вызывается под мьютексом приложения.
+func (app *Application) completeTask(taskID int, value string) error {
+	// Находим задачу по индексу и обновляем её статус и результат
+	task, exists := app.tasks[taskID]
+	if !exists {
+		app.logger().Warn("Task not found", "task_id", taskID)
+		return fmt.Errorf("task with ID %d: %w", taskID, ErrTaskNotFound)
+	}
+	result, err := task.backend.Parse(value)
+	if err != nil {
+		app.logger().Warn("Invalid task result", "task_id", taskID, "result", value, "error", err)
+		return fmt.Errorf("task with ID %d: %w", taskID, err)
+	}
+
+	task.Status = "completed"
+	task.ResultValue = result
+	task.Result = task.backend.Float(result)
+
+	// Выражение уже завершено (например, ошибкой): результат нужен только кэшу
+	// и задачам других выражений, ожидавшим такую же задачу
+	expr := app.expressionOf(task)
+	app.logger().Debug("Task completed", "expression_id", expr.ID, "task_id", taskID, "result", result)
+	if expr.Status != "pending" {
+		return app.resolveInflight(task, result)
+	}
+
+	// Сохраняем результат в карте результатов
+	app.taskResults[taskID] = result
+
+	// Выражение готово, когда известен результат его корневой задачи
+	if taskID == expr.rootID {
+		app.completeExpression(expr, result)
+		app.logger().Info("Expression completed", "expression_id", expr.ID, "result", expr.ResultValue)
+	}
+
+	// Запоминаем результат и завершаем такие же задачи, ожидавшие этот результат
+	if err := app.resolveInflight(task, result); err != nil {
+		return err
+	}
+
+	// Продвигаем задачи, ожидавшие этот результат
+	return app.advance(taskID)
+}
+
+// FailTask принимает от агента ошибку выполнения задачи (переполнение, деление на ноль).
+// Выражение задачи и выражения, ожидавшие такую же задачу, переходят в статус failed.
+func (app *Application) FailTask(taskID int, reason string) error {
+	return app.FailTaskContext(context.Background(), taskID, reason)
+}
+
+// FailTaskContext принимает ошибку выполнения задачи; контекст передает родительский span трассы
+func (app *Application) FailTaskContext(ctx context.Context, taskID int, reason string) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	span := app.startTaskSpan(ctx, taskID, "FailTask")
+	defer span.End()
+	if err := app.checkIssued(taskID); err != nil {
+		span.SetError(err)
+		return err
+	}
+
+	task := app.tasks[taskID]
+	err := fmt.Errorf("task with ID %d: %s", taskID, reason)
+	span.SetError(err)
+	app.failTask(task, err)
+	if app.observer != nil {
+		app.observer.TaskFailed(task.Operation)
+	}
+	return nil
+}
+
+// failTask помечает задачу ошибочной вместе с ее выражением и объединенными с ней задачами
+func (app *Application) failTask(task *Task, reason error) {
+	task.Status = "failed"
+
+	// Ожидавшие задачи забираются до завершения выражения, чтобы роль задачи
+	// не перешла к ним (см. withdrawTasks)
+	var followers []*Task
+	if key := taskCacheKey(task); app.cache.enabled() && app.inflight[key] == task.ID {
+		delete(app.inflight, key)
+		followers = app.followers[task.ID]
+		delete(app.followers, task.ID)
+	}
+
+	app.failExpression(app.expressionOf(task), reason)
+	for _, follower := range followers {
+		app.failTask(follower, reason)
+	}
+}
+
+// enqueueReady ставит готовую задачу в очередь агентов. Если результат такой же операции
+// уже известен, задача завершается из кэша; если такая же задача уже в работе,
+// задача ждет ее результата и агентам не отправляется.
+func (app *Application) enqueueReady(task *Task) error {
+	if !app.cache.enabled() {
+		app.pushTask(task)
+		return nil
+	}
+
+	key := taskCacheKey(task)
+	if result, exists := app.cache.get(key); exists {
+		app.cache.hits++
+		app.logger().Debug("Task completed from cache", "task_id", task.ID, "key", key)
+		return app.completeTask(task.ID, result)
+	}
+	if primaryID, exists := app.inflight[key]; exists {
+		app.cache.coalesced++
+		app.followers[primaryID] = append(app.followers[primaryID], task)
+		app.logger().Debug("Task coalesced with running task", "task_id", task.ID, "running_task_id", primaryID, "key", key)
+		return nil
+	}
 
-	// Обновляем очередь зависимых задач
-	app.dependentQueue = newDependentQueue
-	log.Printf("CompleteTask: Updated dependentQueue. Number of tasks remaining: %d", len(app.dependentQueue))
+	app.cache.misses++
+	app.inflight[key] = task.ID
+	app.pushTask(task)
+	return nil
+}
 
+// pushTask ставит задачу в очередь агентов и будит ожидающих задачу
+func (app *Application) pushTask(task *Task) {
+	app.taskQueue = append(app.taskQueue, task)
+	app.notifyTaskReady()
+	app.logger().Debug("Task added to queue", "task_id", task.ID)
+}
+
+// notifyTaskReady будит всех, кто ждет задачу в GetNextTaskContext
+func (app *Application) notifyTaskReady() {
+	close(app.taskReady)
+	app.taskReady = make(chan struct{})
+}
+
+// resolveInflight сохраняет результат выполненной агентом задачи в кэш
+// и завершает объединенные с ней задачи
+func (app *Application) resolveInflight(task *Task, result string) error {
+	if !app.cache.enabled() {
+		return nil
+	}
+	key := taskCacheKey(task)
+	if app.inflight[key] != task.ID {
+		return nil
+	}
+	delete(app.inflight, key)
+	app.cache.put(key, result)
+
+	followers := app.followers[task.ID]
+	delete(app.followers, task.ID)
+	for _, follower := range followers {
+		if err := app.completeTask(follower.ID, result); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// GetNextTask выдает агенту следующую задачу
+// CacheStats возвращает счетчики кэша результатов операций
+func (app *Application) CacheStats() CacheStats {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.cache.stats()
+}
+
+// GetNextTask выдает агенту следующую задачу; если очередь пуста, возвращает nil
 func (app *Application) GetNextTask() (*Task, error) {
 	app.mu.Lock()
 	defer app.mu.Unlock()
 
+	task, _ := app.popTask()
+	return task, nil
+}
+
+// GetNextTaskContext выдает агенту следующую задачу, при пустой очереди ждет ее появления.
+// Если контекст отменен или истек его срок раньше, возвращает ошибку контекста.
+func (app *Application) GetNextTaskContext(ctx context.Context) (*Task, error) {
+	for {
+		app.mu.Lock()
+		task, ready := app.popTask()
+		app.mu.Unlock()
+		if task != nil {
+			return task, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ready:
+		}
+	}
+}
+
+// popTask извлекает снимок задачи из начала очереди. Если очередь пуста или выдача
+// приостановлена, возвращает nil и канал, который закроется при появлении задач или
+// возобновлении выдачи. Вызывается под мьютексом приложения.
+func (app *Application) popTask() (*Task, <-chan struct{}) {
+	if app.paused {
+		return nil, app.taskReady
+	}
+
+	// Повторно поставленная в очередь задача могла быть выполнена первым агентом
+	for len(app.taskQueue) > 0 && app.taskQueue[0].Status != "pending" {
+		app.taskQueue = app.taskQueue[1:]
+	}
 	if len(app.taskQueue) == 0 {
-		return nil, nil // Очередь пуста
+		return nil, app.taskReady // Очередь пуста
 	}
 
 	task := app.taskQueue[0]
 	app.taskQueue = app.taskQueue[1:]
+	task.issuedAt = time.Now()
+	if app.observer != nil {
+		app.observer.TaskIssued(task.Operation)
+	}
 
-	log.Printf("GetNextTask: Issued task ID %d to agent", task.ID)
-	return task, nil
+	// Агент продолжает трассу выражения: контекст выдачи передается вместе с задачей
+	span := app.tracer.StartWithParent(app.expressionOf(task).span.Context(), "GetNextTask")
+	span.SetAttribute("task.id", task.ID)
+	span.SetAttribute("task.operation", task.Operation)
+	task.Traceparent = span.Context().Traceparent()
+	span.End()
+
+	app.logger().Debug("Issued task to agent", "expression_id", app.taskToExpression[task.ID], "task_id", task.ID, "operation", task.Operation)
+	return task.snapshot(), nil
 }
 
 // getOperationTime возвращает время выполнения операции
@@ -295,103 +853,218 @@ func (app *Application) getOperationTime(operation string) int64 {
 		envVar = "TIME_SUBTRACTION_MS"
 	case "*":
 		envVar = "TIME_MULTIPLICATIONS_MS"
-	case "/":
+	case "/", "%":
 		envVar = "TIME_DIVISIONS_MS"
+	case "==", "!=", "<", "<=", ">", ">=":
+		envVar = "TIME_COMPARISONS_MS"
+	case "?", "&&", "||":
+		return 0 // Управляющие задачи выполняет оркестратор без агента
 	default:
 		return 1000 // Значение по умолчанию, если операция неизвестна
 	}
 
 	valueStr := os.Getenv(envVar)
 	if valueStr == "" {
-		log.Printf("Warning: %s is not set. Using default value (1000ms)", envVar)
+		slog.Warn("Operation time is not set, using default value", "variable", envVar, "default_ms", 1000)
 		return 1000
 	}
 
 	value, err := strconv.ParseInt(valueStr, 10, 64)
 	if err != nil || value <= 0 {
-		log.Printf("Error: %s is not a valid positive number. Using default value (1000ms)", envVar)
+		slog.Error("Operation time is not a valid positive number, using default value", "variable", envVar, "value", valueStr, "default_ms", 1000)
 		return 1000
 	}
 
 	return value
 }
 
-// GetExpressionByID возвращает выражение по ID
+// getEnvLimit возвращает неотрицательный лимит из переменной среды или значение по умолчанию.
+// Значение 0 отключает лимит.
+func getEnvLimit(envVar string, defaultValue int) int {
+	valueStr := os.Getenv(envVar)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value < 0 {
+		slog.Error("Limit is not a valid non-negative number, using default value", "variable", envVar, "value", valueStr, "default", defaultValue)
+		return defaultValue
+	}
+
+	return value
+}
+
+// getResultCacheSize возвращает размер кэша результатов из RESULT_CACHE_SIZE.
+// RESULT_CACHE_ENABLED=false отключает кэш и объединение одинаковых задач.
+func getResultCacheSize() int {
+	if enabled, err := strconv.ParseBool(os.Getenv("RESULT_CACHE_ENABLED")); err == nil && !enabled {
+		return 0
+	}
+	return getEnvLimit("RESULT_CACHE_SIZE", defaultResultCacheSize)
+}
 
+// GetExpressionByID возвращает снимок выражения по ID. Статус выражения обновляет
+// планировщик при выполнении его корневой задачи, поэтому метод ничего не изменяет.
 func (app *Application) GetExpressionByID(id int) (*Expression, error) {
-	app.mu.Lock()
-	defer app.mu.Unlock()
+	app.mu.RLock()
+	defer app.mu.RUnlock()
 
 	expr, exists := app.expressions[id]
 	if !exists {
-		return nil, fmt.Errorf("expression ID %d not found", id)
+		return nil, app.missingExpression(id)
 	}
+	return expr.snapshot(), nil
+}
 
-	// Проверяем, все ли задачи выполнены
-	allCompleted := true
-	for _, task := range expr.Tasks {
-		if task.Status != "completed" {
-			allCompleted = false
-			log.Printf("GetExpressionByID: Expression ID %d has incomplete task ID %d with status %s", id, task.ID, task.Status)
-			break
-		}
+// GetAllExpressions возвращает снимки всех выражений
+func (app *Application) GetAllExpressions() map[int]*Expression {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	expressions := make(map[int]*Expression, len(app.expressions))
+	for id, expr := range app.expressions {
+		expressions[id] = expr.snapshot()
 	}
+	return expressions
+}
 
-	// Если все задачи выполнены, но статус выражения еще не обновлен
-	if allCompleted && expr.Status != "completed" {
-		// Финальный результат находится в последней задаче
-		if len(expr.Tasks) > 0 {
-			finalTask := expr.Tasks[len(expr.Tasks)-1]
-			expr.Status = "completed"
-			expr.Result = finalTask.Result
-			log.Printf("GetExpressionByID: Expression ID %d status updated to 'completed' with result %.2f", id, expr.Result)
-		} else {
-			// Обработка случая, когда в выражении нет задач (хотя такого быть не должно)
-			expr.Status = "completed"
-			expr.Result = 0
-			log.Printf("GetExpressionByID: Warning - Expression ID %d has no tasks but marked as completed", id)
-		}
+// SetTracer включает трассировку этапов выражений
+func (app *Application) SetTracer(tracer *tracing.Tracer) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	app.tracer = tracer
+}
+
+// startTaskSpan начинает span этапа задачи. Родитель — span из контекста (например,
+// span агента из заголовка traceparent), иначе корневой span выражения задачи.
+// Вызывается под мьютексом приложения.
+func (app *Application) startTaskSpan(ctx context.Context, taskID int, name string) *tracing.Span {
+	if app.tracer == nil {
+		return nil
 	}
+	parent, ok := tracing.SpanContextFrom(ctx)
+	task, exists := app.tasks[taskID]
+	if !ok && exists {
+		parent = app.expressionOf(task).span.Context()
+	}
+	span := app.tracer.StartWithParent(parent, name)
+	span.SetAttribute("task.id", taskID)
+	if exists {
+		span.SetAttribute("task.operation", task.Operation)
+	}
+	return span
+}
 
-	return expr, nil
+// SetLogger задает журнал приложения. Подробности разбора и планирования задач
+// пишутся на уровне Debug, создание и завершение выражений — на уровне Info.
+func (app *Application) SetLogger(logger *slog.Logger) {
+	app.log.Store(logger)
 }
 
-// GetAllExpressions возвращает все выражения
-func (app *Application) GetAllExpressions() map[int]*Expression {
+// logger возвращает журнал приложения
+func (app *Application) logger() *slog.Logger {
+	if logger := app.log.Load(); logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
+
+// treeValue печатает дерево выражения, только если запись попадает в журнал
+type treeValue struct{ node calculation.Node }
+
+func (v treeValue) LogValue() slog.Value { return slog.StringValue(v.node.String()) }
+
+// SetTaskObserver задает получателя событий задач
+func (app *Application) SetTaskObserver(observer TaskObserver) {
 	app.mu.Lock()
 	defer app.mu.Unlock()
+	app.observer = observer
+}
 
-	return app.expressions
+// ExpressionCounts возвращает количество выражений в каждом статусе
+func (app *Application) ExpressionCounts() map[string]int {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, expr := range app.expressions {
+		counts[expr.Status]++
+	}
+	return counts
 }
 
-// isNumber проверяет, является ли токен числом
-func isNumber(token string) bool {
-	_, err := strconv.ParseFloat(token, 64)
-	return err == nil
+// QueueLengths возвращает количество готовых задач в очереди и задач, ожидающих результатов других
+func (app *Application) QueueLengths() (ready, dependent int) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	return len(app.taskQueue), len(app.dependent)
 }
 
-// isOperator проверяет, является ли токен оператором
-func isOperator(token string) bool {
-	return token == "+" || token == "-" || token == "*" || token == "/"
+// PendingExpressions возвращает количество выражений, которые еще вычисляются
+func (app *Application) PendingExpressions() int {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	pending := 0
+	for _, expr := range app.expressions {
+		if expr.Status == "pending" {
+			pending++
+		}
+	}
+	return pending
+}
+
+// drainPollInterval — период проверки невыполненных выражений в Drain
+const drainPollInterval = 100 * time.Millisecond
+
+// Drain ждет, пока все принятые выражения будут вычислены или завершатся ошибкой.
+// Если контекст отменен раньше, возвращает ошибку контекста.
+func (app *Application) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		pending := app.PendingExpressions()
+		if pending == 0 {
+			return nil
+		}
+		app.logger().Debug("Waiting for pending expressions", "pending", pending)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
 }
 
 // GetExpressionResult возвращает результат выражения по его ID
 func (app *Application) GetExpressionResult(exprID int) (float64, error) {
-	app.mu.Lock()
-	defer app.mu.Unlock()
+	app.mu.RLock()
+	defer app.mu.RUnlock()
 
 	expr, exists := app.expressions[exprID]
 	if !exists {
-		log.Printf("GetExpressionResult: Expression ID %d not found", exprID)
-		return 0, errors.New("expression not found")
+		app.logger().Debug("Expression not found", "expression_id", exprID)
+		return 0, app.missingExpression(exprID)
+	}
+
+	if expr.Status == "failed" {
+		app.logger().Debug("Expression failed", "expression_id", exprID, "error", expr.Error)
+		return 0, fmt.Errorf("expression ID %d: %w: %s", exprID, ErrExpressionFailed, expr.Error)
+	}
+	if expr.Status == "timeout" {
+		app.logger().Debug("Expression timed out", "expression_id", exprID)
+		return 0, fmt.Errorf("expression ID %d: %w", exprID, ErrExpressionTimeout)
 	}
 
 	// Проверяем, завершены ли все задачи в выражении
 	if expr.Status != "completed" {
-		log.Printf("GetExpressionResult: Expression ID %d is not completed yet", exprID)
-		return 0, errors.New("result not ready")
+		app.logger().Debug("Expression is not completed yet", "expression_id", exprID)
+		return 0, fmt.Errorf("expression ID %d: %w", exprID, ErrResultNotReady)
 	}
 
-	log.Printf("GetExpressionResult: Returning result %.2f for expression ID %d", expr.Result, exprID)
+	app.logger().Debug("Returning expression result", "expression_id", exprID, "result", expr.Result)
 	return expr.Result, nil
 }
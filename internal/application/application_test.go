@@ -1,9 +1,21 @@
 package application
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/syirnik/GO_Yandex/pkg/calculation"
 )
 
 // Тест инициализации приложения
@@ -93,6 +105,9 @@ func TestParseExpressionErrors(t *testing.T) {
 	}{
 		{"Division By Zero", "5/0"},
 		{"Invalid Expression", "2++3"},
+		{"Adjacent Numbers", "1 2 + 3"},
+		{"Malformed Number", "1..2+3"},
+		{"Lone Dot", ". + 1"},
 	}
 
 	for _, tc := range testCases {
@@ -214,39 +229,6 @@ func TestGetExpressions(t *testing.T) {
 	}
 }
 
-// Тест вспомогательных функций
-func TestHelperFunctions(t *testing.T) {
-	// Тест isNumber
-	numbers := []string{"123", "0.123", "-123.456"}
-	for _, num := range numbers {
-		if !isNumber(num) {
-			t.Errorf("Expected '%s' to be recognized as a number", num)
-		}
-	}
-
-	notNumbers := []string{"abc", "", "+"}
-	for _, notNum := range notNumbers {
-		if isNumber(notNum) {
-			t.Errorf("Expected '%s' to not be recognized as a number", notNum)
-		}
-	}
-
-	// Тест isOperator
-	operators := []string{"+", "-", "*", "/"}
-	for _, op := range operators {
-		if !isOperator(op) {
-			t.Errorf("Expected '%s' to be recognized as an operator", op)
-		}
-	}
-
-	notOperators := []string{"123", "abc", ""}
-	for _, notOp := range notOperators {
-		if isOperator(notOp) {
-			t.Errorf("Expected '%s' to not be recognized as an operator", notOp)
-		}
-	}
-}
-
 // Тест многопоточности
 func TestConcurrency(t *testing.T) {
 	app := New()
@@ -329,6 +311,9 @@ func TestVariousExpressions(t *testing.T) {
 		{"8/2", 4.0},
 		{"2+3*4", 14.0},
 		{"(2+3)*4", 20.0},
+		{"-2*3", -6.0},
+		{"-(2+3)*2", -10.0},
+		{"5", 5.0},
 	}
 
 	for _, tc := range testCases {
@@ -514,3 +499,1163 @@ func TestExpressionStatusUpdate(t *testing.T) {
 		t.Errorf("Expected result 5.0, got %f", expr.Result)
 	}
 }
+
+// Тест квот на количество невыполненных выражений и ограничения на количество задач
+func TestExpressionQuotas(t *testing.T) {
+	app := New()
+	app.maxPendingExpressions = 2
+	app.limits.MaxTasks = 2
+
+	opts := ExpressionOptions{Owner: "10.0.0.1"}
+	for _, expression := range []string{"2+3", "4+5"} {
+		if _, err := app.ParseExpressionWithOptions(expression, opts); err != nil {
+			t.Fatalf("ParseExpressionWithOptions returned error: %v", err)
+		}
+	}
+
+	if _, err := app.ParseExpressionWithOptions("2+3", opts); !errors.Is(err, ErrTooManyPendingExpressions) {
+		t.Errorf("Expected ErrTooManyPendingExpressions, got %v", err)
+	}
+
+	// Другой клиент не ограничен чужой квотой
+	if _, err := app.ParseExpressionWithOptions("1+2+3+4", ExpressionOptions{Owner: "10.0.0.2"}); !errors.Is(err, calculation.ErrTooManyTasks) {
+		t.Errorf("Expected ErrTooManyTasks, got %v", err)
+	}
+	if len(app.taskQueue) != 2 {
+		t.Errorf("Rejected expression must not enqueue tasks, got %d tasks in queue", len(app.taskQueue))
+	}
+
+	// Выполнение выражения освобождает квоту
+	processAllTasks(t, app)
+	if _, err := app.ParseExpressionWithOptions("2+3", opts); err != nil {
+		t.Errorf("Expected quota to be released, got %v", err)
+	}
+}
+
+// Тест оптимизации выражения: свертка чисел, упрощение и объединение общих подвыражений
+func TestOptimizedExpression(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   float64
+		tasks      int
+		saved      int
+	}{
+		{"(2+3)*(2+3)", 25.0, 0, 3},
+		{"(4-1)*1 + 0", 3.0, 0, 3},
+		{"--(6/3)", 2.0, 0, 3},
+		{"(1+2)*(1+2) - (1+2)*(1+2)", 0.0, 0, 7},
+		{"(2+3)*(3+2) > 1 ? 2*3 : 4", 6.0, 4, 2},
+		{"(1-1) && 2*3", 0.0, 2, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			app := New()
+			exprID, err := app.ParseExpressionWithOptions(tc.expression, ExpressionOptions{Optimize: true})
+			if err != nil {
+				t.Fatalf("ParseExpressionWithOptions returned error: %v", err)
+			}
+
+			expr, _ := app.GetExpressionByID(exprID)
+			if len(expr.Tasks) != tc.tasks {
+				t.Errorf("Expected %d tasks, got %d", tc.tasks, len(expr.Tasks))
+			}
+			if expr.TasksSaved != tc.saved {
+				t.Errorf("Expected %d tasks saved, got %d", tc.saved, expr.TasksSaved)
+			}
+
+			processAllTasksExact(t, app)
+
+			expr, _ = app.GetExpressionByID(exprID)
+			if expr.Status != "completed" || expr.Result != tc.expected {
+				t.Errorf("Expected completed with %f, got %s with %f", tc.expected, expr.Status, expr.Result)
+			}
+		})
+	}
+
+	// Свернутое сравнение остается логическим результатом
+	app := New()
+	exprID, _ := app.ParseExpressionWithOptions("1 < 2", ExpressionOptions{Optimize: true})
+	if expr, _ := app.GetExpressionByID(exprID); !expr.Boolean || expr.Status != "completed" {
+		t.Errorf("Expected completed boolean expression, got %s, boolean %v", expr.Status, expr.Boolean)
+	}
+}
+
+// Тест кэша результатов: одинаковые задачи выполняются агентом один раз
+func TestResultCache(t *testing.T) {
+	app := New()
+
+	// Две одинаковые задачи в работе объединяются в одну
+	id1, _ := app.ParseExpression("2+3")
+	id2, _ := app.ParseExpression("(2+3)*2")
+	if len(app.taskQueue) != 1 {
+		t.Fatalf("Expected identical tasks to be coalesced, got %d tasks in queue", len(app.taskQueue))
+	}
+
+	processAllTasks(t, app)
+	for id, expected := range map[int]float64{id1: 5, id2: 10} {
+		expr, _ := app.GetExpressionByID(id)
+		if expr.Status != "completed" || expr.Result != expected {
+			t.Errorf("Expression ID %d: expected completed with %f, got %s with %f", id, expected, expr.Status, expr.Result)
+		}
+	}
+
+	// Известные результаты берутся из кэша без агента
+	id3, _ := app.ParseExpression("(2+3)*2 + 1")
+	if task, _ := app.GetNextTask(); task == nil || task.Operation != "+" || task.Arg1 != 10 {
+		t.Fatalf("Expected only the last addition to be dispatched, got %+v", task)
+	}
+
+	stats := app.CacheStats()
+	if stats.Hits != 2 || stats.Misses != 3 || stats.Coalesced != 1 {
+		t.Errorf("Unexpected cache stats: %+v", stats)
+	}
+	if expr, _ := app.GetExpressionByID(id3); expr.Status != "pending" {
+		t.Errorf("Expected expression ID %d to wait for the agent, got %s", id3, expr.Status)
+	}
+}
+
+// Тест отключения кэша и вытеснения старых результатов
+func TestResultCacheLimits(t *testing.T) {
+	t.Setenv("RESULT_CACHE_ENABLED", "false")
+	app := New()
+	app.ParseExpression("2+3")
+	app.ParseExpression("2+3")
+	if len(app.taskQueue) != 2 || app.CacheStats().Enabled {
+		t.Errorf("Expected disabled cache to dispatch every task, got %d tasks in queue", len(app.taskQueue))
+	}
+
+	cache := newResultCache(2)
+	cache.put("a", "1")
+	cache.put("b", "2")
+	cache.get("a")
+	cache.put("c", "3")
+	if _, exists := cache.get("b"); exists {
+		t.Error("Expected least recently used entry to be evicted")
+	}
+	if result, exists := cache.get("a"); !exists || result != "1" {
+		t.Error("Expected recently used entry to stay in cache")
+	}
+}
+
+// Вспомогательная функция: выполняет все задачи точно, как агент, в числовом режиме задачи
+func processAllTasksExact(t *testing.T, app *Application) {
+	for {
+		task, err := app.GetNextTask()
+		if err != nil {
+			t.Fatalf("GetNextTask returned error: %v", err)
+		}
+		if task == nil {
+			return
+		}
+
+		backend, err := calculation.NewBackend(task.Mode, task.Scale)
+		if err != nil {
+			t.Fatalf("NewBackend returned error: %v", err)
+		}
+		value, err := backend.Apply(task.Operation, task.Arg1Value, task.Arg2Value)
+		if err != nil {
+			t.Fatalf("Apply returned error for task ID %d: %v", task.ID, err)
+		}
+		if err := app.CompleteTaskValue(task.ID, value); err != nil {
+			t.Fatalf("CompleteTaskValue returned error: %v", err)
+		}
+	}
+}
+
+// Тест приема результатов: принимаются только результаты выданных агенту и еще
+// не выполненных задач
+func TestCompleteTaskNotIssued(t *testing.T) {
+	app := New()
+	exprID, _ := app.ParseExpression("(1+2)*3")
+	expr := app.expressions[exprID]
+	root := app.tasks[expr.rootID]
+	addition := app.taskQueue[0]
+
+	// Задача ждет результата сложения и агенту не выдана
+	if err := app.CompleteTask(root.ID, 100); !errors.Is(err, ErrTaskNotInFlight) {
+		t.Errorf("Expected ErrTaskNotInFlight for dependent root task, got %v", err)
+	}
+	if err := app.FailTask(root.ID, "stray"); !errors.Is(err, ErrTaskNotInFlight) {
+		t.Errorf("Expected ErrTaskNotInFlight for failing dependent task, got %v", err)
+	}
+	// Задача в очереди, но агенту еще не выдана
+	if err := app.CompleteTask(addition.ID, 3); !errors.Is(err, ErrTaskNotInFlight) {
+		t.Errorf("Expected ErrTaskNotInFlight for queued task, got %v", err)
+	}
+	if expr.Status != "pending" || root.Status != "pending" {
+		t.Fatalf("Expected expression to stay pending, got %s", expr.Status)
+	}
+
+	// Повторный результат выполненной задачи отклоняется
+	task, _ := app.GetNextTask()
+	if err := app.CompleteTask(task.ID, 3); err != nil {
+		t.Fatalf("CompleteTask returned error: %v", err)
+	}
+	if err := app.CompleteTask(task.ID, 4); !errors.Is(err, ErrTaskNotInFlight) {
+		t.Errorf("Expected ErrTaskNotInFlight for completed task, got %v", err)
+	}
+
+	processAllTasks(t, app)
+	if expr.Status != "completed" || expr.Result != 9 {
+		t.Errorf("Expected completed with 9, got %s with %f", expr.Status, expr.Result)
+	}
+}
+
+// Тест числовых режимов: точные значения передаются задачам строками
+func TestNumericModes(t *testing.T) {
+	testCases := []struct {
+		expression string
+		opts       ExpressionOptions
+		expected   string
+	}{
+		{"0.1 + 0.2", ExpressionOptions{}, "0.30000000000000004"},
+		{"0.1 + 0.2", ExpressionOptions{Mode: calculation.ModeDecimal}, "0.3"},
+		{"10 / 3 * 3", ExpressionOptions{Mode: calculation.ModeDecimal, Scale: 2}, "9.99"},
+		{"10 / 3 * 3", ExpressionOptions{Mode: calculation.ModeRational}, "10"},
+		{"-(1/3) + 1", ExpressionOptions{Mode: calculation.ModeRational}, "2/3"},
+		{"9007199254740993 * 3", ExpressionOptions{Mode: calculation.ModeRational}, "27021597764222979"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.opts.Mode)+" "+tc.expression, func(t *testing.T) {
+			app := New()
+			exprID, err := app.ParseExpressionWithOptions(tc.expression, tc.opts)
+			if err != nil {
+				t.Fatalf("ParseExpressionWithOptions returned error: %v", err)
+			}
+
+			processAllTasksExact(t, app)
+
+			expr, _ := app.GetExpressionByID(exprID)
+			if expr.Status != "completed" || expr.ResultValue != tc.expected {
+				t.Errorf("Expected completed with %s, got %s with %s", tc.expected, expr.Status, expr.ResultValue)
+			}
+		})
+	}
+}
+
+// Тест ошибок числовых режимов
+func TestNumericModeErrors(t *testing.T) {
+	app := New()
+	if _, err := app.ParseExpressionWithOptions("1+2", ExpressionOptions{Mode: "complex"}); !errors.Is(err, calculation.ErrUnknownMode) {
+		t.Errorf("Expected ErrUnknownMode, got %v", err)
+	}
+	if _, err := app.ParseExpressionWithOptions("1/(2-2)", ExpressionOptions{Mode: calculation.ModeRational}); err != nil {
+		t.Fatalf("ParseExpressionWithOptions returned error: %v", err)
+	}
+
+	task, _ := app.GetNextTask()
+	if task.Arg1Value != "2" || task.Arg2Value != "2" || task.Mode != calculation.ModeRational {
+		t.Fatalf("Expected exact rational arguments, got %+v", task)
+	}
+	if err := app.CompleteTaskValue(task.ID, "not a number"); !errors.Is(err, calculation.ErrInvalidValue) {
+		t.Errorf("Expected ErrInvalidValue, got %v", err)
+	}
+
+	// Деление на ноль обнаруживается при готовности задачи: выражение завершается ошибкой
+	if err := app.CompleteTaskValue(task.ID, "0"); err != nil {
+		t.Fatalf("CompleteTaskValue returned error: %v", err)
+	}
+	expr, _ := app.GetExpressionByID(1)
+	if expr.Status != "failed" || !strings.Contains(expr.Error, calculation.ErrDivisionByZero.Error()) {
+		t.Errorf("Expected failed expression with division by zero, got %s: %q", expr.Status, expr.Error)
+	}
+	if _, err := app.GetExpressionResult(1); !errors.Is(err, ErrExpressionFailed) {
+		t.Errorf("Expected ErrExpressionFailed, got %v", err)
+	}
+	if task, _ := app.GetNextTask(); task != nil {
+		t.Errorf("Expected failed expression tasks to be withdrawn, got task ID %d", task.ID)
+	}
+}
+
+// Тест целочисленного режима: деление с отбрасыванием дробной части, остаток и переполнение
+func TestIntegerMode(t *testing.T) {
+	intMode := ExpressionOptions{Mode: calculation.ModeInt}
+	testCases := []struct {
+		expression string
+		expected   string
+	}{
+		{"7 / 2", "3"},
+		{"-7 / 2", "-3"},
+		{"7 % 3", "1"},
+		{"-7 % 3", "-1"},
+		{"20 * 19 * 18 / (3 * 2)", "1140"},
+		{"9223372036854775807 - 1 + 1", "9223372036854775807"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			app := New()
+			exprID, err := app.ParseExpressionWithOptions(tc.expression, intMode)
+			if err != nil {
+				t.Fatalf("ParseExpressionWithOptions returned error: %v", err)
+			}
+			processAllTasksExact(t, app)
+
+			expr, _ := app.GetExpressionByID(exprID)
+			if expr.Status != "completed" || expr.ResultValue != tc.expected {
+				t.Errorf("Expected completed with %s, got %s with %s", tc.expected, expr.Status, expr.ResultValue)
+			}
+		})
+	}
+
+	app := New()
+	if _, err := app.ParseExpressionWithOptions("1.5 + 2", intMode); !errors.Is(err, calculation.ErrFractionalNumber) {
+		t.Errorf("Expected ErrFractionalNumber, got %v", err)
+	}
+	if _, err := app.ParseExpressionWithOptions("9223372036854775808 + 1", intMode); !errors.Is(err, calculation.ErrIntegerOverflow) {
+		t.Errorf("Expected ErrIntegerOverflow, got %v", err)
+	}
+
+	// Переполнение на агенте сообщается оркестратору и завершает выражение ошибкой
+	exprID, _ := app.ParseExpressionWithOptions("9223372036854775807 + 1", intMode)
+	other, _ := app.ParseExpressionWithOptions("(9223372036854775807 + 1) * 2", intMode)
+	task, _ := app.GetNextTask()
+	backend, _ := calculation.NewBackend(task.Mode, task.Scale)
+	_, err := backend.Apply(task.Operation, task.Arg1Value, task.Arg2Value)
+	var overflowErr *calculation.OverflowError
+	if !errors.As(err, &overflowErr) {
+		t.Fatalf("Expected OverflowError, got %v", err)
+	}
+	if err := app.FailTask(task.ID, err.Error()); err != nil {
+		t.Fatalf("FailTask returned error: %v", err)
+	}
+	for _, id := range []int{exprID, other} {
+		if expr, _ := app.GetExpressionByID(id); expr.Status != "failed" || !strings.Contains(expr.Error, "integer overflow") {
+			t.Errorf("Expression ID %d: expected failed with overflow, got %s: %q", id, expr.Status, expr.Error)
+		}
+	}
+	if err := app.FailTask(999, "unknown"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+
+	// Оптимизация не сворачивает и не убирает двойное отрицание, на котором возникает переполнение
+	optimized := intMode
+	optimized.Optimize = true
+	exprID, _ = app.ParseExpressionWithOptions("--(0 - 9223372036854775807 - 1)", optimized)
+	for task, _ := app.GetNextTask(); task != nil; task, _ = app.GetNextTask() {
+		backend, _ := calculation.NewBackend(task.Mode, task.Scale)
+		value, err := backend.Apply(task.Operation, task.Arg1Value, task.Arg2Value)
+		if err != nil {
+			app.FailTask(task.ID, err.Error())
+			continue
+		}
+		app.CompleteTaskValue(task.ID, value)
+	}
+	if expr, _ := app.GetExpressionByID(exprID); expr.Status != "failed" || !strings.Contains(expr.Error, "integer overflow") {
+		t.Errorf("Expected optimized double negation to overflow, got %s: %q", expr.Status, expr.Error)
+	}
+
+	// Запись -9223372036854775808 переполняет int64 до смены знака с оптимизацией и без нее
+	for _, options := range []ExpressionOptions{intMode, optimized} {
+		if _, err := app.ParseExpressionWithOptions("-9223372036854775808", options); !errors.Is(err, calculation.ErrIntegerOverflow) {
+			t.Errorf("Optimize %v: expected ErrIntegerOverflow, got %v", options.Optimize, err)
+		}
+	}
+}
+
+// Тест оптимизации: свертка не меняет, какие выражения принимаются при разборе
+func TestOptimizeKeepsValidity(t *testing.T) {
+	testCases := []struct {
+		mode       calculation.Mode
+		expression string
+	}{
+		{calculation.ModeInt, "-9223372036854775808"},
+		{calculation.ModeInt, "-(0 - 9223372036854775807 - 1)"},
+		{calculation.ModeInt, "(1 - 1) && 9223372036854775808 + 1"},
+		{calculation.ModeFloat, "5 / (2 - 2)"},
+		{calculation.ModeFloat, "(1 - 1) ? 1 / 0 : 2"},
+		{calculation.ModeFloat, "1 / 0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			app := New()
+			_, plainErr := app.ParseExpressionWithOptions(tc.expression, ExpressionOptions{Mode: tc.mode})
+			_, optimizedErr := app.ParseExpressionWithOptions(tc.expression, ExpressionOptions{Mode: tc.mode, Optimize: true})
+			if (plainErr == nil) != (optimizedErr == nil) || plainErr != nil && plainErr.Error() != optimizedErr.Error() {
+				t.Errorf("Expected the same result with and without optimize, got %v and %v", plainErr, optimizedErr)
+			}
+		})
+	}
+}
+
+// Тест условного оператора: агентам отправляются только задачи выбранной ветви
+func TestConditionalExpression(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+		dispatched int // Сколько задач получили агенты
+	}{
+		{"(1 + 1 > 1) ? 2 * 3 : 4 * 5", "6", 3},
+		{"(1 + 1 < 1) ? 2 * 3 : 4 * 5", "20", 3},
+		{"(1 + 1 > 1) ? ((2 + 2 == 5) ? 1 + 1 : 2 + 2) : 3 * 3", "4", 4},
+		{"(2 - 2) ? 1 / 0 : 7", "7", 1},
+		{"1 < 2 ? 3 + 4 : 1 / 0", "7", 2},
+		{"!(1 + 1) ? 1 : 2", "2", 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			app := New()
+			exprID, err := app.ParseExpression(tc.expression)
+			if err != nil {
+				t.Fatalf("ParseExpression returned error: %v", err)
+			}
+
+			dispatched := 0
+			for {
+				task, _ := app.GetNextTask()
+				if task == nil {
+					break
+				}
+				dispatched++
+				backend, _ := calculation.NewBackend(task.Mode, task.Scale)
+				value, err := backend.Apply(task.Operation, task.Arg1Value, task.Arg2Value)
+				if err != nil {
+					t.Fatalf("Apply returned error for task %s: %v", task.Operation, err)
+				}
+				if err := app.CompleteTaskValue(task.ID, value); err != nil {
+					t.Fatalf("CompleteTaskValue returned error: %v", err)
+				}
+			}
+
+			expr, _ := app.GetExpressionByID(exprID)
+			if expr.Status != "completed" || expr.ResultValue != tc.expected {
+				t.Errorf("Expected completed with %s, got %s with %s", tc.expected, expr.Status, expr.ResultValue)
+			}
+			if dispatched != tc.dispatched {
+				t.Errorf("Expected %d dispatched tasks, got %d", tc.dispatched, dispatched)
+			}
+		})
+	}
+
+	// Деление на ноль в выбранной ветви завершает выражение ошибкой
+	app := New()
+	exprID, _ := app.ParseExpression("(1 + 1 > 1) ? 1 / 0 : 2")
+	processAllTasksExact(t, app)
+	if expr, _ := app.GetExpressionByID(exprID); expr.Status != "failed" {
+		t.Errorf("Expected failed expression, got %s", expr.Status)
+	}
+
+	// Сравнения дают логический результат
+	app = New()
+	exprID, _ = app.ParseExpression("2 * 3 >= 6 && 1 != 2")
+	processAllTasksExact(t, app)
+	expr, _ := app.GetExpressionByID(exprID)
+	if expr.Status != "completed" || expr.Result != 1 || !expr.Boolean {
+		t.Errorf("Expected boolean true, got %+v", expr)
+	}
+	if _, err := app.ParseExpression("1 ? 2"); !errors.Is(err, calculation.ErrUnmatchedConditional) {
+		t.Errorf("Expected ErrUnmatchedConditional, got %v", err)
+	}
+}
+
+// Тест ленивых && и ||: правый операнд не вычисляется, если результат ясен по левому
+func TestShortCircuit(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+		dispatched int
+		skipped    int
+	}{
+		{"(1 - 1) && 2 * 3 > 4", "0", 1, 2},
+		{"(1 + 1) && 2 * 3 > 4", "1", 3, 0},
+		{"(1 + 0) || 1 / 0", "1", 1, 1},
+		{"(1 - 1) || (2 - 2)", "0", 2, 0},
+		{"(2 > 1) && ((1 - 1) || 5 / 5)", "1", 3, 0},
+		{"(2 < 1) && ((1 - 1) || 5 / 5)", "0", 1, 3},
+		{"0 && 1 / 0", "0", 0, 0},
+		{"1 || 2 + 2", "1", 0, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			app := New()
+			exprID, err := app.ParseExpression(tc.expression)
+			if err != nil {
+				t.Fatalf("ParseExpression returned error: %v", err)
+			}
+
+			dispatched := 0
+			for {
+				task, _ := app.GetNextTask()
+				if task == nil {
+					break
+				}
+				dispatched++
+				if isControl(task.Operation) {
+					t.Fatalf("Control task %s dispatched to agent", task.Operation)
+				}
+				backend, _ := calculation.NewBackend(task.Mode, task.Scale)
+				value, err := backend.Apply(task.Operation, task.Arg1Value, task.Arg2Value)
+				if err != nil {
+					t.Fatalf("Apply returned error for task %s: %v", task.Operation, err)
+				}
+				app.CompleteTaskValue(task.ID, value)
+			}
+
+			expr, _ := app.GetExpressionByID(exprID)
+			if expr.Status != "completed" || expr.ResultValue != tc.expected {
+				t.Fatalf("Expected completed with %s, got %s with %s", tc.expected, expr.Status, expr.ResultValue)
+			}
+			skipped := 0
+			for _, task := range expr.Tasks {
+				if task.Status == "skipped" {
+					skipped++
+				} else if task.Status != "completed" {
+					t.Errorf("Task ID %d (%s) left in status %s", task.ID, task.Operation, task.Status)
+				}
+			}
+			if dispatched != tc.dispatched || skipped != tc.skipped {
+				t.Errorf("Expected %d dispatched and %d skipped tasks, got %d and %d", tc.dispatched, tc.skipped, dispatched, skipped)
+			}
+		})
+	}
+}
+
+// Тест очистки: результаты и зависимости выполненных и ошибочных выражений не накапливаются
+func TestResultsPruned(t *testing.T) {
+	app := New()
+	completed, _ := app.ParseExpression("(2 + 3) * (4 - 1) > 1 ? 7 * 7 : 0")
+	processAllTasksExact(t, app)
+	failed, _ := app.ParseExpression("(1 + 1) * 2 / (3 - 3)")
+	processAllTasksExact(t, app)
+
+	if expr, _ := app.GetExpressionByID(completed); expr.Status != "completed" || expr.ResultValue != "49" {
+		t.Errorf("Expected completed with 49, got %s with %s", expr.Status, expr.ResultValue)
+	}
+	if expr, _ := app.GetExpressionByID(failed); expr.Status != "failed" {
+		t.Errorf("Expected failed expression, got %s", expr.Status)
+	}
+	if len(app.taskResults) != 0 || len(app.dependent) != 0 || len(app.children) != 0 {
+		t.Errorf("Expected pruned bookkeeping, got %d results, %d dependent, %d children lists",
+			len(app.taskResults), len(app.dependent), len(app.children))
+	}
+}
+
+// quietLogs отключает логи на время бенчмарка и возвращает функцию их восстановления
+func quietLogs() func() {
+	log.SetOutput(io.Discard)
+	return func() { log.SetOutput(os.Stderr) }
+}
+
+// parseBenchmarkExpressions создает count выражений по две задачи: готовую и зависимую от нее.
+// Числа разные, чтобы задачи не выполнялись из кэша.
+func parseBenchmarkExpressions(b *testing.B, app *Application, from, count int) {
+	for i := from; i < from+count; i++ {
+		if _, err := app.ParseExpression(fmt.Sprintf("(%d + 1) * 2", i)); err != nil {
+			b.Fatalf("ParseExpression returned error: %v", err)
+		}
+	}
+}
+
+// completeNextTask выполняет следующую задачу из очереди
+func completeNextTask(b *testing.B, app *Application) bool {
+	task, _ := app.GetNextTask()
+	if task == nil {
+		return false
+	}
+	value, err := task.backend.Apply(task.Operation, task.Arg1Value, task.Arg2Value)
+	if err != nil {
+		b.Fatalf("Apply returned error: %v", err)
+	}
+	if err := app.CompleteTaskValue(task.ID, value); err != nil {
+		b.Fatalf("CompleteTaskValue returned error: %v", err)
+	}
+	return true
+}
+
+// BenchmarkCompleteTask выполняет 100 000 задач 50 000 выражений
+func BenchmarkCompleteTask(b *testing.B) {
+	defer quietLogs()()
+	const expressions = 50000
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		app := New()
+		parseBenchmarkExpressions(b, app, 0, expressions)
+		b.StartTimer()
+
+		completedTasks := 0
+		for completeNextTask(b, app) {
+			completedTasks++
+		}
+		if completedTasks != 2*expressions {
+			b.Fatalf("Expected %d tasks, completed %d", 2*expressions, completedTasks)
+		}
+	}
+	b.ReportMetric(float64(2*expressions*b.N)/b.Elapsed().Seconds(), "tasks/s")
+}
+
+// BenchmarkCompleteTaskWithQueuedTasks измеряет выполнение одной задачи, когда в очередях
+// ждут 100 000 задач: время не зависит от их количества
+func BenchmarkCompleteTaskWithQueuedTasks(b *testing.B) {
+	defer quietLogs()()
+	const expressions = 50000
+
+	app := New()
+	parseBenchmarkExpressions(b, app, 0, expressions)
+	next := expressions
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if !completeNextTask(b, app) {
+			b.StopTimer()
+			parseBenchmarkExpressions(b, app, next, expressions)
+			next += expressions
+			b.StartTimer()
+			completeNextTask(b, app)
+		}
+	}
+}
+
+// Нагрузочный тест многопоточности: сотни горутин одновременно создают выражения,
+// выполняют задачи и читают выражения. Запускается с детектором гонок: go test -race
+func TestConcurrentStress(t *testing.T) {
+	defer quietLogs()()
+	const producers, perProducer, workers, readers = 50, 20, 100, 50
+
+	app := New()
+	var mu sync.Mutex
+	expected := make(map[int]float64)
+
+	var producing sync.WaitGroup
+	producing.Add(producers)
+	for p := 0; p < producers; p++ {
+		go func(p int) {
+			defer producing.Done()
+			for i := 0; i < perProducer; i++ {
+				// Повторяющиеся подвыражения разных выражений проверяют кэш и объединение задач
+				expression := fmt.Sprintf("(%d + %d) * (%d - 1) > 10 ? (%d + 1) / 2 : (%d && %d - 3)", p%7, i%5, i, p, i%2, p%4)
+				want, err := calculation.Calc(expression)
+				if err != nil {
+					t.Errorf("Calc(%s) returned error: %v", expression, err)
+					return
+				}
+				id, err := app.ParseExpression(expression)
+				if err != nil {
+					t.Errorf("ParseExpression(%s) returned error: %v", expression, err)
+					return
+				}
+				mu.Lock()
+				expected[id] = want
+				mu.Unlock()
+			}
+		}(p)
+	}
+
+	stop := make(chan struct{})
+	var running sync.WaitGroup
+	running.Add(workers + readers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer running.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				task, _ := app.GetNextTask()
+				if task == nil {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				value, err := task.backend.Apply(task.Operation, task.Arg1Value, task.Arg2Value)
+				if err != nil {
+					app.FailTask(task.ID, err.Error())
+					continue
+				}
+				if err := app.CompleteTaskValue(task.ID, value); err != nil {
+					t.Errorf("CompleteTaskValue returned error: %v", err)
+				}
+			}
+		}()
+	}
+	for r := 0; r < readers; r++ {
+		go func(r int) {
+			defer running.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Список всех выражений — дорогой снимок, поэтому запрашивается реже
+				if i%50 == 0 {
+					for _, expr := range app.GetAllExpressions() {
+						_ = expr.Status
+					}
+				}
+				id := (r+i)%(producers*perProducer) + 1
+				if expr, err := app.GetExpressionByID(id); err == nil && len(expr.Tasks) > 0 {
+					_ = expr.Tasks[0].Status
+				}
+				app.GetExpressionResult(id)
+				app.CacheStats()
+				time.Sleep(100 * time.Microsecond)
+			}
+		}(r)
+	}
+
+	producing.Wait()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		pending := 0
+		for _, expr := range app.GetAllExpressions() {
+			if expr.Status == "pending" {
+				pending++
+			}
+		}
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d expressions still pending", pending)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	running.Wait()
+
+	if len(expected) != producers*perProducer {
+		t.Fatalf("Expected %d expressions, got %d", producers*perProducer, len(expected))
+	}
+	for id, want := range expected {
+		expr, _ := app.GetExpressionByID(id)
+		if expr.Status != "completed" || expr.Result != want {
+			t.Errorf("Expression %q: expected completed with %v, got %s with %v", expr.Value, want, expr.Status, expr.Result)
+		}
+	}
+}
+
+// Тест вариантов API с контекстом: отмена и ожидание задачи
+func TestContextAPI(t *testing.T) {
+	app := New()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := app.ParseExpressionContext(canceled, "2+3", ExpressionOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if len(app.GetAllExpressions()) != 0 {
+		t.Errorf("Expected no expressions after canceled parse")
+	}
+
+	// Пустая очередь: ожидание заканчивается по сроку контекста
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if task, err := app.GetNextTaskContext(ctx); task != nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v, %v", task, err)
+	}
+
+	// Ожидающий агент получает задачу, как только она появляется
+	got := make(chan *Task, 1)
+	go func() {
+		task, err := app.GetNextTaskContext(context.Background())
+		if err != nil {
+			t.Errorf("GetNextTaskContext returned error: %v", err)
+		}
+		got <- task
+	}()
+	time.Sleep(10 * time.Millisecond)
+	exprID, _ := app.ParseExpression("2+3")
+
+	var task *Task
+	select {
+	case task = <-got:
+	case <-time.After(time.Second):
+		t.Fatal("GetNextTaskContext did not return a queued task")
+	}
+	if err := app.CompleteTaskValueContext(canceled, task.ID, "5"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := app.CompleteTaskValueContext(context.Background(), task.ID, "5"); err != nil {
+		t.Fatalf("CompleteTaskValueContext returned error: %v", err)
+	}
+	if expr, _ := app.GetExpressionByID(exprID); expr.Status != "completed" || expr.Result != 5 {
+		t.Errorf("Expected completed with 5, got %s with %v", expr.Status, expr.Result)
+	}
+}
+
+// Тест срока вычисления: выражение, не вычисленное к сроку, получает статус timeout
+func TestExpressionDeadline(t *testing.T) {
+	app := New()
+	exprID, _ := app.ParseExpressionWithOptions("(2 + 3) * 4", ExpressionOptions{Deadline: time.Now().Add(20 * time.Millisecond)})
+	fast, _ := app.ParseExpressionWithOptions("7 - 1", ExpressionOptions{Deadline: time.Now().Add(time.Hour)})
+
+	first, _ := app.GetNextTask()
+	second, _ := app.GetNextTask()
+	if err := app.CompleteTaskValue(second.ID, "6"); err != nil {
+		t.Fatalf("CompleteTaskValue returned error: %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	expr, _ := app.GetExpressionByID(exprID)
+	if expr.Status != "timeout" || expr.Deadline.IsZero() {
+		t.Fatalf("Expected timeout status with deadline, got %s", expr.Status)
+	}
+	if _, err := app.GetExpressionResult(exprID); !errors.Is(err, ErrExpressionTimeout) {
+		t.Errorf("Expected ErrExpressionTimeout, got %v", err)
+	}
+	if expr, _ := app.GetExpressionByID(fast); expr.Status != "completed" {
+		t.Errorf("Expected expression completed before its deadline, got %s", expr.Status)
+	}
+
+	// Результат агента после срока не принимается, зависимые задачи сняты с очередей
+	if err := app.CompleteTaskValue(first.ID, "5"); !errors.Is(err, ErrTaskNotInFlight) {
+		t.Fatalf("Expected ErrTaskNotInFlight for late result, got %v", err)
+	}
+	if task, _ := app.GetNextTask(); task != nil {
+		t.Errorf("Expected withdrawn tasks, got task %s", task.Operation)
+	}
+	if expr, _ := app.GetExpressionByID(exprID); expr.Status != "timeout" {
+		t.Errorf("Expected timeout status to stay, got %s", expr.Status)
+	}
+}
+
+// TestLogger проверяет структурированные записи журнала приложения: поля
+// expression_id и task_id и уровни событий.
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	app := New()
+	app.SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	exprID, err := app.ParseExpressionWithOptions("2*3", ExpressionOptions{RequestID: "req-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, _ := app.GetNextTask()
+	if err := app.CompleteTask(task.ID, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var record map[string]any
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record["level"] != "INFO" || record["expression_id"] != float64(exprID) {
+			t.Errorf("expected INFO record for expression %d, got %v", exprID, record)
+		}
+		messages = append(messages, record["msg"].(string))
+	}
+	// Выдача и выполнение задач пишутся на уровне Debug и здесь не видны
+	if strings.Join(messages, "; ") != "Created expression; Expression completed" {
+		t.Errorf("unexpected records: %v", messages)
+	}
+}
+
+// TestAdminOperations проверяет просмотр очередей и ручное вмешательство: возврат
+// зависшей задачи в очередь, приостановку выдачи, принудительную ошибку и удаление
+// завершенных выражений.
+func TestAdminOperations(t *testing.T) {
+	app := New()
+	first, _ := app.ParseExpression("(1+2)*3")
+	second, _ := app.ParseExpression("(1+2)*4")
+
+	states := func() map[string]int {
+		counts := make(map[string]int)
+		for _, info := range app.ListTasks() {
+			counts[info.State]++
+		}
+		return counts
+	}
+	if got := states(); got[TaskStateReady] != 1 || got[TaskStateDependent] != 2 || got[TaskStateCoalesced] != 1 {
+		t.Fatalf("Unexpected task states: %v", got)
+	}
+
+	// Выданная агенту задача видна как in_flight и может быть возвращена в очередь
+	issued, _ := app.GetNextTask()
+	infos := app.ListTasks()
+	if infos[0].Task.ID != issued.ID || infos[0].State != TaskStateInFlight || infos[0].IssuedAt.IsZero() || infos[0].ExpressionID != first {
+		t.Fatalf("Expected issued task in flight, got %+v", infos[0])
+	}
+	if err := app.RequeueTask(infos[1].Task.ID); !errors.Is(err, ErrTaskNotInFlight) {
+		t.Errorf("Expected ErrTaskNotInFlight for dependent task, got %v", err)
+	}
+	if err := app.RequeueTask(999); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+	if err := app.RequeueTask(issued.ID); err != nil {
+		t.Fatalf("RequeueTask returned error: %v", err)
+	}
+
+	// Приостановленная выдача задерживает агентов до возобновления
+	app.PauseDispatch()
+	if task, _ := app.GetNextTask(); task != nil || !app.DispatchPaused() {
+		t.Fatalf("Expected no tasks while dispatch is paused, got %+v", task)
+	}
+	received := make(chan *Task)
+	go func() {
+		task, _ := app.GetNextTaskContext(context.Background())
+		received <- task
+	}()
+	time.Sleep(20 * time.Millisecond)
+	app.ResumeDispatch()
+	if task := <-received; task == nil || task.ID != issued.ID {
+		t.Fatalf("Expected requeued task after resume, got %+v", task)
+	}
+
+	// Первый агент все же прислал результат: повторная выдача пропускается,
+	// а результат второго агента отклоняется
+	app.RequeueTask(issued.ID)
+	if err := app.RequeueTask(issued.ID); !errors.Is(err, ErrTaskNotInFlight) {
+		t.Errorf("Expected ErrTaskNotInFlight for task already in queue, got %v", err)
+	}
+	if err := app.CompleteTask(issued.ID, 3); err != nil {
+		t.Fatalf("CompleteTask returned error: %v", err)
+	}
+	if err := app.CompleteTask(issued.ID, 4); !errors.Is(err, ErrTaskNotInFlight) {
+		t.Errorf("Expected ErrTaskNotInFlight for second result, got %v", err)
+	}
+	if result := app.tasks[issued.ID].ResultValue; result != "3" {
+		t.Errorf("Expected first result to stay, got %s", result)
+	}
+	multiplication, _ := app.GetNextTask()
+	if multiplication == nil || multiplication.Operation != "*" {
+		t.Fatalf("Expected multiplication after completed requeued task, got %+v", multiplication)
+	}
+
+	// Принудительная ошибка снимает задачи выражения с очередей; результат выданной агенту задачи не принимается
+	failed, pending := first, second
+	if app.taskToExpression[multiplication.ID] != first {
+		failed, pending = second, first
+	}
+	if err := app.FailExpression(failed, "stuck"); err != nil {
+		t.Fatalf("FailExpression returned error: %v", err)
+	}
+	if expr, _ := app.GetExpressionByID(failed); expr.Status != "failed" || !strings.Contains(expr.Error, "stuck") {
+		t.Errorf("Expected failed expression with reason, got %s: %s", expr.Status, expr.Error)
+	}
+	if err := app.FailExpression(failed, ""); !errors.Is(err, ErrExpressionNotPending) {
+		t.Errorf("Expected ErrExpressionNotPending, got %v", err)
+	}
+	if err := app.FailExpression(999, ""); !errors.Is(err, ErrExpressionNotFound) {
+		t.Errorf("Expected ErrExpressionNotFound, got %v", err)
+	}
+	if err := app.CompleteTask(multiplication.ID, 9); !errors.Is(err, ErrTaskNotInFlight) {
+		t.Errorf("Expected ErrTaskNotInFlight for task of failed expression, got %v", err)
+	}
+	processAllTasks(t, app)
+	if expr, _ := app.GetExpressionByID(pending); expr.Status != "completed" {
+		t.Fatalf("Expected expression ID %d completed, got %s", pending, expr.Status)
+	}
+
+	// Удаляются завершенные выражения старше заданного возраста
+	if purged := app.PurgeExpressions(time.Hour); purged != 0 {
+		t.Errorf("Expected no expressions older than an hour, purged %d", purged)
+	}
+	if purged := app.PurgeExpressions(0); purged != 2 {
+		t.Errorf("Expected both expressions to be purged, purged %d", purged)
+	}
+	if _, err := app.GetExpressionByID(pending); !errors.Is(err, ErrExpressionEvicted) {
+		t.Errorf("Expected purged expression to be gone, got %v", err)
+	}
+	if len(app.expressions) != 0 || len(app.tasks) != 0 || len(app.taskToExpression) != 0 {
+		t.Errorf("Expected empty indexes, got %d expressions, %d tasks", len(app.expressions), len(app.tasks))
+	}
+}
+
+// TestFailExpressionHandsOverTask проверяет принудительную ошибку выражения, чья выданная
+// агенту задача нужна другому выражению: та же операция выдается заново для него
+func TestFailExpressionHandsOverTask(t *testing.T) {
+	app := New()
+	failed, _ := app.ParseExpression("6/2")
+	issued, _ := app.GetNextTask()
+	waiting, _ := app.ParseExpression("6/2")
+	if err := app.FailExpression(failed, ""); err != nil {
+		t.Fatalf("FailExpression returned error: %v", err)
+	}
+	if err := app.CompleteTask(issued.ID, 3); !errors.Is(err, ErrTaskNotInFlight) {
+		t.Errorf("Expected ErrTaskNotInFlight for task of failed expression, got %v", err)
+	}
+	if purged := app.PurgeExpressions(0); purged != 1 {
+		t.Errorf("Expected the failed expression to be purged, purged %d", purged)
+	}
+
+	processAllTasks(t, app)
+	if expr, _ := app.GetExpressionByID(waiting); expr.Status != "completed" || expr.Result != 3 {
+		t.Errorf("Expected completed with 3, got %s with %f", expr.Status, expr.Result)
+	}
+}
+
+// TestAdminPurgeCoalescedFollower проверяет удаление администратором выражения с ошибкой,
+// чья задача ждала результата такой же задачи, выданной агенту
+func TestAdminPurgeCoalescedFollower(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		app := New()
+		primary, _ := app.ParseExpression("7*8")
+		task, _ := app.GetNextTask()
+		failed, _ := app.ParseExpression("7*8")
+		waiting, _ := app.ParseExpression("7*8")
+		if err := app.FailExpression(failed, ""); err != nil {
+			t.Fatalf("Failed to fail expression: %v", err)
+		}
+		if purged := app.PurgeExpressions(0); purged != 1 {
+			t.Fatalf("Expected the failed expression to be purged, purged %d", purged)
+		}
+		for _, info := range app.ListTasks() {
+			if info.ExpressionID == failed {
+				t.Errorf("Expected no tasks of the purged expression, got task %d in state %s", info.Task.ID, info.State)
+			}
+		}
+
+		expected := "completed"
+		if fail {
+			expected = "failed"
+			if err := app.FailTask(task.ID, "agent error"); err != nil {
+				t.Fatalf("Failed to fail task: %v", err)
+			}
+		} else if err := app.CompleteTask(task.ID, 56); err != nil {
+			t.Fatalf("Failed to complete task: %v", err)
+		}
+		for _, id := range []int{primary, waiting} {
+			if expr, _ := app.GetExpressionByID(id); expr.Status != expected {
+				t.Errorf("Expression ID %d: expected %s, got %s", id, expected, expr.Status)
+			}
+		}
+	}
+}
+
+// TestRetention проверяет удаление завершенных выражений по возрасту и количеству
+// и ошибку ErrExpressionEvicted для удаленных
+func TestRetention(t *testing.T) {
+	app := New()
+	var finished []int
+	for i := 0; i < 3; i++ {
+		id, _ := app.ParseExpression(fmt.Sprintf("%d+1", i))
+		finished = append(finished, id)
+		processAllTasks(t, app)
+		time.Sleep(2 * time.Millisecond) // Различимое время завершения
+	}
+	pending, _ := app.ParseExpression("10*10")
+
+	// По количеству остаются самые новые
+	if evicted := app.EvictExpressions(Retention{MaxCount: 2}); evicted != 1 {
+		t.Fatalf("Expected one expression evicted by count, got %d", evicted)
+	}
+	if _, err := app.GetExpressionByID(finished[0]); !errors.Is(err, ErrExpressionEvicted) {
+		t.Errorf("Expected ErrExpressionEvicted for the oldest expression, got %v", err)
+	}
+	if _, err := app.GetExpressionResult(finished[0]); !errors.Is(err, ErrExpressionEvicted) {
+		t.Errorf("Expected ErrExpressionEvicted for the result, got %v", err)
+	}
+	if _, err := app.GetExpressionByID(999); !errors.Is(err, ErrExpressionNotFound) {
+		t.Errorf("Expected ErrExpressionNotFound for an unknown ID, got %v", err)
+	}
+
+	// По возрасту удаляются все завершенные; невычисленное выражение остается
+	if evicted := app.EvictExpressions(Retention{MaxAge: time.Hour}); evicted != 0 {
+		t.Errorf("Expected no expressions older than an hour, got %d", evicted)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.RunJanitor(ctx, Retention{MaxAge: time.Millisecond, Interval: 5 * time.Millisecond})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	expressions := app.GetAllExpressions()
+	if len(expressions) != 1 || expressions[pending] == nil {
+		t.Errorf("Expected only the pending expression to stay, got %d expressions", len(expressions))
+	}
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	if len(app.tasks) != 1 || len(app.taskToExpression) != 1 || len(app.taskResults) != 2 {
+		t.Errorf("Expected only the pending expression's task and numbers, got %d tasks, %d results", len(app.tasks), len(app.taskResults))
+	}
+}
+
+// TestRetentionCoalescedFollower проверяет удаление выражения с ошибкой, чья задача
+// ждала результата такой же задачи: результат и ошибка выданной задачи доходят до остальных
+func TestRetentionCoalescedFollower(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		app := New()
+		primary, _ := app.ParseExpression("2+3")
+		task, _ := app.GetNextTask()
+		aborted, _ := app.ParseExpression("2+3")
+		waiting, _ := app.ParseExpression("2+3")
+		if err := app.FailExpression(aborted, "aborted"); err != nil {
+			t.Fatalf("Failed to fail expression: %v", err)
+		}
+		if evicted := app.EvictExpressions(Retention{MaxAge: time.Nanosecond}); evicted != 1 {
+			t.Fatalf("Expected the failed expression to be evicted, got %d", evicted)
+		}
+
+		expected := "completed"
+		if fail {
+			expected = "failed"
+			if err := app.FailTask(task.ID, "agent error"); err != nil {
+				t.Fatalf("Failed to fail task: %v", err)
+			}
+		} else if err := app.CompleteTask(task.ID, 5); err != nil {
+			t.Fatalf("Failed to complete task: %v", err)
+		}
+		for _, id := range []int{primary, waiting} {
+			if expr, _ := app.GetExpressionByID(id); expr.Status != expected {
+				t.Errorf("Expression ID %d: expected %s, got %s", id, expected, expr.Status)
+			}
+		}
+	}
+}
+
+// TestIdempotency проверяет повторную отправку выражения с ключом: тот же ключ и тело
+// возвращают созданное выражение, другое тело — ошибку, ключи разных клиентов независимы
+func TestIdempotency(t *testing.T) {
+	app := New()
+	ctx := context.Background()
+	opts := ExpressionOptions{Owner: "client", IdempotencyKey: "key-1", RequestHash: "hash-1"}
+
+	first, created, err := app.SubmitExpression(ctx, "2+3", opts)
+	if err != nil || !created {
+		t.Fatalf("Expected new expression, got created=%v, err=%v", created, err)
+	}
+	again, created, err := app.SubmitExpression(ctx, "2+3", opts)
+	if err != nil || created || again != first {
+		t.Errorf("Expected replay of expression %d, got %d, created=%v, err=%v", first, again, created, err)
+	}
+	if len(app.GetAllExpressions()) != 1 || len(app.taskQueue) != 1 {
+		t.Errorf("Expected replay not to create expressions or tasks")
+	}
+
+	conflict := opts
+	conflict.RequestHash = "hash-2"
+	if _, _, err := app.SubmitExpression(ctx, "2+4", conflict); !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("Expected ErrIdempotencyConflict, got %v", err)
+	}
+
+	other := opts
+	other.Owner = "other-client"
+	if id, created, _ := app.SubmitExpression(ctx, "2+3", other); !created || id == first {
+		t.Errorf("Expected another client's key to create a new expression, got %d, created=%v", id, created)
+	}
+
+	// Удаленное выражение освобождает свой ключ
+	processAllTasks(t, app)
+	app.EvictExpressions(Retention{MaxAge: time.Nanosecond})
+	if id, created, _ := app.SubmitExpression(ctx, "2+3", opts); !created || id == first {
+		t.Errorf("Expected key of evicted expression to create a new one, got %d, created=%v", id, created)
+	}
+
+	// Ключ забывается по истечении срока
+	app.idempotencyTTL = time.Millisecond
+	expiring := ExpressionOptions{IdempotencyKey: "key-2", RequestHash: "hash"}
+	id, _, _ := app.SubmitExpression(ctx, "1+1", expiring)
+	time.Sleep(5 * time.Millisecond)
+	if again, created, _ := app.SubmitExpression(ctx, "1+1", expiring); !created || again == id {
+		t.Errorf("Expected expired key to create a new expression, got %d, created=%v", again, created)
+	}
+	time.Sleep(5 * time.Millisecond)
+	app.EvictExpressions(Retention{})
+	if _, exists := app.idempotency[idempotencyKey(expiring)]; exists || len(app.idempotency) != 1 {
+		t.Errorf("Expected expired keys to be pruned, got %d keys", len(app.idempotency))
+	}
+}
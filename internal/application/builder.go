@@ -0,0 +1,321 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/syirnik/GO_Yandex/pkg/calculation"
+)
+
+// taskBuilder переводит дерево выражения в граф задач. Числа становятся
+// "выполненными задачами" с известным результатом, каждая операция — задачей для агента.
+// Задачи накапливаются в builder и попадают в очереди приложения только после успешного разбора.
+type taskBuilder struct {
+	app        *Application
+	backend    calculation.Backend // Арифметика числового режима выражения
+	scale      int                 // Знаков после точки в режиме decimal
+	nextTaskID int
+	results    map[int]string // Результаты чисел до фиксации выражения
+	tasks      []*Task
+	ready      []*Task // Задачи, оба аргумента которых известны
+	dependent  []*Task // Задачи, ожидающие результатов других задач
+	guards     []Guard // Условия ветвей условных операторов, внутри которых строятся задачи
+
+	// Устранение общих подвыражений: одинаковые числа и операции над одними и теми же
+	// задачами получают один ID, поэтому (a+b)*(a+b) вычисляет a+b один раз
+	dedupe   bool
+	valueIDs map[string]int
+	taskIDs  map[string]int
+}
+
+// newTaskBuilder создает построитель задач; вызывается под мьютексом приложения.
+// dedupe включает устранение общих подвыражений.
+func newTaskBuilder(app *Application, backend calculation.Backend, scale int, dedupe bool) *taskBuilder {
+	return &taskBuilder{
+		app:        app,
+		backend:    backend,
+		scale:      scale,
+		nextTaskID: app.nextTaskID,
+		results:    make(map[int]string),
+		dedupe:     dedupe,
+		valueIDs:   make(map[string]int),
+		taskIDs:    make(map[string]int),
+	}
+}
+
+// build рекурсивно создает задачи для узла и возвращает ID задачи с его результатом
+func (b *taskBuilder) build(node calculation.Node) (int, error) {
+	switch n := node.(type) {
+	case *calculation.NumberNode:
+		value, err := b.parseLiteral(n, false)
+		if err != nil {
+			return 0, err
+		}
+		return b.addValue(value), nil
+
+	case *calculation.UnaryNode:
+		// Унарный минус числа сворачивается сразу, иначе вычисляется как 0 - x
+		if number, ok := n.Operand.(*calculation.NumberNode); ok && n.Op == "-" {
+			value, err := b.parseLiteral(number, true)
+			if err != nil {
+				return 0, err
+			}
+			return b.addValue(value), nil
+		}
+		operandID, err := b.build(n.Operand)
+		if err != nil {
+			return 0, err
+		}
+		if n.Op == "!" {
+			// Отрицание вычисляется как x == 0; для известного x — сразу
+			if value, known := b.resultOf(operandID); known {
+				result, err := b.backend.Apply("==", value, "0")
+				if err != nil {
+					return 0, err
+				}
+				return b.addValue(result), nil
+			}
+			return b.addTask("==", operandID, b.addValue("0"), n.Loc)
+		}
+		return b.addTask("-", b.addValue("0"), operandID, n.Loc)
+
+	case *calculation.TernaryNode:
+		return b.buildTernary(n)
+
+	case *calculation.BinaryNode:
+		if n.Op == "&&" || n.Op == "||" {
+			return b.buildShortCircuit(n)
+		}
+		task1ID, err := b.build(n.Left)
+		if err != nil {
+			return 0, err
+		}
+		task2ID, err := b.build(n.Right)
+		if err != nil {
+			return 0, err
+		}
+		return b.addTask(n.Op, task1ID, task2ID, n.Right.Span())
+
+	case *calculation.VariableNode:
+		return 0, &calculation.SyntaxError{Err: calculation.ErrUnknownIdentifier, Pos: n.Loc.Start, Token: n.Name}
+
+	case *calculation.CallNode:
+		return 0, &calculation.SyntaxError{Err: calculation.ErrUnknownIdentifier, Pos: n.Loc.Start, Token: n.Name}
+
+	default:
+		return 0, calculation.ErrInvalidExpression
+	}
+}
+
+// buildTernary строит условный оператор. Задачи ветвей получают условие (Guard) и ждут
+// результата задачи условия: задачи невыбранной ветви пропускаются и агентам не отправляются.
+// Итог выбирает задача "?", которую оркестратор завершает сам.
+func (b *taskBuilder) buildTernary(n *calculation.TernaryNode) (int, error) {
+	condID, err := b.build(n.Cond)
+	if err != nil {
+		return 0, err
+	}
+
+	// Условие известно при разборе: строим только выбранную ветвь
+	if cond, known := b.resultOf(condID); known {
+		if b.backend.IsZero(cond) {
+			return b.build(n.Else)
+		}
+		return b.build(n.Then)
+	}
+
+	b.guards = append(b.guards, Guard{TaskID: condID, When: true})
+	thenID, err := b.build(n.Then)
+	b.guards = b.guards[:len(b.guards)-1]
+	if err != nil {
+		return 0, err
+	}
+
+	b.guards = append(b.guards, Guard{TaskID: condID, When: false})
+	elseID, err := b.build(n.Else)
+	b.guards = b.guards[:len(b.guards)-1]
+	if err != nil {
+		return 0, err
+	}
+
+	task := b.newTask("?", []int{condID, thenID, elseID})
+	b.dependent = append(b.dependent, task)
+	return b.appendTask(task)
+}
+
+// buildShortCircuit строит ленивые && и ||: правый операнд вычисляется, только если
+// левый не определяет результат. Задачи правого операнда ждут левый, как ветвь условного
+// оператора, а итог вычисляет управляющая задача без агента.
+func (b *taskBuilder) buildShortCircuit(n *calculation.BinaryNode) (int, error) {
+	leftID, err := b.build(n.Left)
+	if err != nil {
+		return 0, err
+	}
+
+	// Правый операнд нужен для && при истинном левом, для || — при ложном
+	needRight := n.Op == "&&"
+	left, leftKnown := b.resultOf(leftID)
+	if leftKnown && b.backend.IsZero(left) == needRight {
+		if n.Op == "&&" {
+			return b.addValue("0"), nil
+		}
+		return b.addValue("1"), nil
+	}
+
+	if !leftKnown {
+		b.guards = append(b.guards, Guard{TaskID: leftID, When: needRight})
+	}
+	rightID, err := b.build(n.Right)
+	if !leftKnown {
+		b.guards = b.guards[:len(b.guards)-1]
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Оба операнда известны при разборе: результат сворачивается сразу
+	if right, rightKnown := b.resultOf(rightID); leftKnown && rightKnown {
+		value, err := b.backend.Apply(n.Op, left, right)
+		if err != nil {
+			return 0, err
+		}
+		return b.addValue(value), nil
+	}
+
+	task := b.newTask(n.Op, []int{leftID, rightID})
+	b.dependent = append(b.dependent, task)
+	return b.appendTask(task)
+}
+
+// newTask создает задачу в числовом режиме выражения с условиями текущей ветви
+func (b *taskBuilder) newTask(operation string, parents []int) *Task {
+	task := &Task{
+		ID:            b.nextTaskID,
+		Operation:     operation,
+		Status:        "pending",
+		ParentTasks:   parents,
+		OperationTime: b.app.getOperationTime(operation),
+		Mode:          b.backend.Mode(),
+		Scale:         b.scale,
+		backend:       b.backend,
+	}
+	if len(b.guards) > 0 {
+		task.Guards = append([]Guard(nil), b.guards...)
+	}
+	b.nextTaskID++
+	return task
+}
+
+// appendTask добавляет задачу в выражение и проверяет ограничение на количество задач
+func (b *taskBuilder) appendTask(task *Task) (int, error) {
+	b.tasks = append(b.tasks, task)
+
+	limits := b.app.limits
+	if limits.MaxTasks > 0 && len(b.tasks) > limits.MaxTasks {
+		b.app.logger().Debug("Expression exceeds tasks limit", "limit", limits.MaxTasks)
+		return 0, &calculation.LimitError{Err: calculation.ErrTooManyTasks, Limit: limits.MaxTasks, Actual: len(b.tasks)}
+	}
+	return task.ID, nil
+}
+
+// parseLiteral переводит число в значение числового режима, при negate — со сменой знака
+func (b *taskBuilder) parseLiteral(number *calculation.NumberNode, negate bool) (string, error) {
+	value, err := b.backend.Parse(number.Value)
+	if err == nil && negate {
+		value, err = b.backend.Apply("-", "0", value)
+	}
+	if err != nil {
+		cause := calculation.ErrMalformedNumber
+		if errors.Is(err, calculation.ErrIntegerOverflow) {
+			cause = calculation.ErrIntegerOverflow
+		}
+		return "", &calculation.SyntaxError{Err: cause, Pos: number.Loc.Start, Token: number.Value}
+	}
+	return value, nil
+}
+
+// addValue регистрирует уже известное значение как выполненную задачу
+func (b *taskBuilder) addValue(value string) int {
+	if b.dedupe {
+		if taskID, exists := b.valueIDs[value]; exists {
+			return taskID
+		}
+	}
+
+	taskID := b.nextTaskID
+	if b.dedupe {
+		b.valueIDs[value] = taskID
+	}
+	b.nextTaskID++
+	b.results[taskID] = value // Записываем число как результат
+	b.app.logger().Debug("Created task for number", "task_id", taskID, "value", value)
+	return taskID
+}
+
+// resultOf ищет уже известный результат задачи
+func (b *taskBuilder) resultOf(taskID int) (string, bool) {
+	if res, exists := b.results[taskID]; exists {
+		return res, true
+	}
+	res, exists := b.app.taskResults[taskID]
+	return res, exists
+}
+
+// dedupeKey строит ключ операции для устранения общих подвыражений.
+// Для коммутативных операций порядок аргументов не важен: a+b и b+a дают один ключ.
+// Задачи разных ветвей условного оператора не объединяются.
+func dedupeKey(operation string, task1ID, task2ID int, guards []Guard) string {
+	switch operation {
+	case "+", "*", "==", "!=", "&&", "||":
+		if task2ID < task1ID {
+			task1ID, task2ID = task2ID, task1ID
+		}
+	}
+	return fmt.Sprintf("%s|%d|%d|%v", operation, task1ID, task2ID, guards)
+}
+
+// addTask создает задачу на операцию над результатами двух задач.
+// divisorSpan указывает на делитель для сообщения о делении на ноль.
+func (b *taskBuilder) addTask(operation string, task1ID, task2ID int, divisorSpan calculation.Span) (int, error) {
+	var key string
+	if b.dedupe {
+		key = dedupeKey(operation, task1ID, task2ID, b.guards)
+		if taskID, exists := b.taskIDs[key]; exists {
+			b.app.logger().Debug("Reusing task", "task_id", taskID, "key", key)
+			return taskID, nil
+		}
+	}
+
+	b.app.logger().Debug("Creating task for operator", "operation", operation, "arg1_task_id", task1ID, "arg2_task_id", task2ID)
+
+	// Создание задачи на операцию
+	task := b.newTask(operation, []int{task1ID, task2ID})
+
+	// Присваиваем аргументы, если они уже вычислены
+	res1, exists1 := b.resultOf(task1ID)
+	if exists1 {
+		task.setArg(0, res1)
+	}
+	res2, exists2 := b.resultOf(task2ID)
+	if exists2 {
+		task.setArg(1, res2)
+	}
+
+	// Проверяем, известны ли оба аргумента. Задача внутри ветви условного оператора
+	// ждет условия, и деление на ноль в ней проверяется, только если ветвь выбрана.
+	if exists1 && exists2 && len(task.Guards) == 0 {
+		// Проверка деления на ноль
+		if task.divisionByZero() {
+			return 0, &calculation.SyntaxError{Err: calculation.ErrDivisionByZero, Pos: divisorSpan.Start, Token: "0"}
+		}
+		task.IsReady = true
+		b.ready = append(b.ready, task)
+	} else {
+		b.dependent = append(b.dependent, task) // Иначе откладываем задачу
+	}
+
+	if b.dedupe {
+		b.taskIDs[key] = task.ID
+	}
+	return b.appendTask(task)
+}
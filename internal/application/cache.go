@@ -0,0 +1,98 @@
+package application
+
+import (
+	"container/list"
+	"strconv"
+)
+
+// defaultResultCacheSize — количество результатов операций в кэше по умолчанию
+const defaultResultCacheSize = 10000
+
+// CacheStats — счетчики кэша результатов операций
+type CacheStats struct {
+	Enabled   bool `json:"enabled"`
+	Size      int  `json:"size"`      // Сколько результатов сейчас в кэше
+	Capacity  int  `json:"capacity"`  // Максимальное количество результатов
+	Hits      int  `json:"hits"`      // Задачи, выполненные из кэша без агента
+	Misses    int  `json:"misses"`    // Задачи, отправленные агентам
+	Coalesced int  `json:"coalesced"` // Задачи, дождавшиеся такой же задачи в работе
+}
+
+// resultCache — LRU-кэш результатов операций по ключу (операция, аргумент 1, аргумент 2).
+// Используется под мьютексом приложения.
+type resultCache struct {
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // Ключи от недавно использованных к давно использованным
+
+	hits      int
+	misses    int
+	coalesced int
+}
+
+// cacheEntry — запись кэша
+type cacheEntry struct {
+	key    string
+	result string
+}
+
+// newResultCache создает кэш на capacity результатов; capacity 0 отключает кэш
+func newResultCache(capacity int) *resultCache {
+	return &resultCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// enabled сообщает, включен ли кэш
+func (c *resultCache) enabled() bool {
+	return c.capacity > 0
+}
+
+// get возвращает результат по ключу и отмечает его как недавно использованный
+func (c *resultCache) get(key string) (string, bool) {
+	element, exists := c.entries[key]
+	if !exists {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).result, true
+}
+
+// put сохраняет результат, вытесняя самый давно использованный при переполнении
+func (c *resultCache) put(key string, result string) {
+	if !c.enabled() {
+		return
+	}
+	if element, exists := c.entries[key]; exists {
+		element.Value.(*cacheEntry).result = result
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, result: result})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// stats возвращает снимок счетчиков кэша
+func (c *resultCache) stats() CacheStats {
+	return CacheStats{
+		Enabled:   c.enabled(),
+		Size:      c.order.Len(),
+		Capacity:  c.capacity,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Coalesced: c.coalesced,
+	}
+}
+
+// taskCacheKey строит ключ задачи по числовому режиму, операции и значениям аргументов
+func taskCacheKey(task *Task) string {
+	return string(task.Mode) + "|" + strconv.Itoa(task.Scale) + "|" +
+		task.Operation + "|" + task.Arg1Value + "|" + task.Arg2Value
+}
@@ -0,0 +1,19 @@
+package application
+
+import "errors"
+
+// defaultMaxPendingExpressions — квота невыполненных выражений на клиента по умолчанию
+const defaultMaxPendingExpressions = 100
+
+var (
+	ErrTooManyPendingExpressions = errors.New("too many pending expressions")
+	ErrExpressionNotFound        = errors.New("expression not found")
+	ErrExpressionEvicted         = errors.New("expression was removed after its retention period")
+	ErrTaskNotFound              = errors.New("task not found")
+	ErrResultNotReady            = errors.New("result not ready")
+	ErrExpressionFailed          = errors.New("expression evaluation failed")
+	ErrExpressionTimeout         = errors.New("expression deadline exceeded")
+	ErrExpressionNotPending      = errors.New("expression is already finished")
+	ErrTaskNotInFlight           = errors.New("task is not issued to an agent")
+	ErrIdempotencyConflict       = errors.New("idempotency key was already used with a different request")
+)
@@ -0,0 +1,79 @@
+package application
+
+import "time"
+
+// Повторная отправка выражения. Клиент, не дождавшийся ответа, повторяет запрос с тем же
+// ключом (заголовок Idempotency-Key); в течение idempotencyTTL такой запрос получает
+// уже созданное выражение. Ключи разных клиентов не пересекаются.
+
+// defaultIdempotencyTTLMs — сколько помнить ключ повторной отправки по умолчанию (сутки)
+const defaultIdempotencyTTLMs = 24 * 60 * 60 * 1000
+
+// idempotencyRecord — выражение, созданное запросом с ключом повторной отправки
+type idempotencyRecord struct {
+	exprID      int
+	requestHash string    // Отпечаток тела запроса
+	expires     time.Time // После этого ключ можно использовать для нового выражения
+}
+
+// idempotencyKey возвращает ключ записи: ключ клиента в пространстве его владельца
+func idempotencyKey(opts ExpressionOptions) string {
+	return opts.Owner + "\x00" + opts.IdempotencyKey
+}
+
+// replay ищет выражение, созданное раньше запросом с тем же ключом. Если тело запроса
+// отличалось, возвращает ErrIdempotencyConflict. Вызывается под мьютексом приложения.
+func (app *Application) replay(opts ExpressionOptions, now time.Time) (int, bool, error) {
+	if opts.IdempotencyKey == "" {
+		return 0, false, nil
+	}
+	record, exists := app.idempotency[idempotencyKey(opts)]
+	if !exists || now.After(record.expires) {
+		return 0, false, nil
+	}
+	if record.requestHash != opts.RequestHash {
+		return 0, false, ErrIdempotencyConflict
+	}
+	return record.exprID, true, nil
+}
+
+// ReplayExpression возвращает выражение, уже созданное запросом с ключом повторной
+// отправки opts.IdempotencyKey, не создавая нового. Если тело запроса отличалось,
+// возвращает ErrIdempotencyConflict.
+func (app *Application) ReplayExpression(opts ExpressionOptions) (exprID int, replayed bool, err error) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	return app.replay(opts, time.Now())
+}
+
+// rememberKey запоминает ключ повторной отправки созданного выражения.
+// Вызывается под мьютексом приложения.
+func (app *Application) rememberKey(expr *Expression, opts ExpressionOptions, now time.Time) {
+	if opts.IdempotencyKey == "" || app.idempotencyTTL <= 0 {
+		return
+	}
+	expr.idempotencyKey = idempotencyKey(opts)
+	app.idempotency[expr.idempotencyKey] = idempotencyRecord{
+		exprID:      expr.ID,
+		requestHash: opts.RequestHash,
+		expires:     now.Add(app.idempotencyTTL),
+	}
+}
+
+// forgetKey удаляет ключ повторной отправки удаленного выражения, если ключ
+// еще указывает на него. Вызывается под мьютексом приложения.
+func (app *Application) forgetKey(expr *Expression) {
+	if record, exists := app.idempotency[expr.idempotencyKey]; exists && record.exprID == expr.ID {
+		delete(app.idempotency, expr.idempotencyKey)
+	}
+}
+
+// pruneKeys удаляет ключи повторной отправки с истекшим сроком.
+// Вызывается под мьютексом приложения.
+func (app *Application) pruneKeys(now time.Time) {
+	for key, record := range app.idempotency {
+		if now.After(record.expires) {
+			delete(app.idempotency, key)
+		}
+	}
+}
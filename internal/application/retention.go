@@ -0,0 +1,133 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Хранение завершенных выражений. Выражение с результатом или ошибкой остается в памяти,
+// пока клиент может его запросить; затем фоновая очистка удаляет его вместе с задачами.
+// Идентификаторы выражений не используются повторно, поэтому удаленное выражение
+// отличается от несуществующего: его ID меньше следующего выдаваемого.
+
+// defaultJanitorInterval — период фоновой очистки по умолчанию
+const defaultJanitorInterval = time.Minute
+
+// Retention задает, сколько хранятся завершенные (completed, failed, timeout) выражения
+type Retention struct {
+	MaxAge   time.Duration // Сколько хранить после завершения; 0 — без ограничения
+	MaxCount int           // Сколько хранить самых новых; 0 — без ограничения
+	Interval time.Duration // Период очистки; 0 — defaultJanitorInterval
+}
+
+// EvictExpressions удаляет завершенные выражения, которые старше r.MaxAge или не входят
+// в r.MaxCount самых новых. Выражения, чьи задачи еще выполняются агентами для других
+// выражений, остаются до получения результата. Заодно забывает ключи повторной отправки
+// с истекшим сроком. Возвращает количество удаленных выражений.
+func (app *Application) EvictExpressions(r Retention) int {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	var finished []*Expression
+	for _, expr := range app.expressions {
+		if app.evictable(expr) {
+			finished = append(finished, expr)
+		}
+	}
+	// Самые старые — в начале
+	sort.Slice(finished, func(i, j int) bool {
+		if !finished[i].finishedAt.Equal(finished[j].finishedAt) {
+			return finished[i].finishedAt.Before(finished[j].finishedAt)
+		}
+		return finished[i].ID < finished[j].ID
+	})
+
+	evict := 0
+	if r.MaxCount > 0 && len(finished) > r.MaxCount {
+		evict = len(finished) - r.MaxCount
+	}
+	if r.MaxAge > 0 {
+		cutoff := time.Now().Add(-r.MaxAge)
+		for evict < len(finished) && finished[evict].finishedAt.Before(cutoff) {
+			evict++
+		}
+	}
+
+	app.removeExpressions(finished[:evict])
+	app.pruneKeys(time.Now())
+	if evict > 0 {
+		app.logger().Info("Expressions evicted", "count", evict, "retained", len(finished)-evict)
+	}
+	return evict
+}
+
+// RunJanitor периодически удаляет завершенные выражения по правилам r, пока ctx не отменен
+func (app *Application) RunJanitor(ctx context.Context, r Retention) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultJanitorInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.EvictExpressions(r)
+		}
+	}
+}
+
+// evictable сообщает, что выражение завершено. Задачи завершенного выражения сняты
+// с очередей, и результатов его задач не ждут другие выражения (см. withdrawTasks).
+func (app *Application) evictable(expr *Expression) bool {
+	return expr.Status != "pending"
+}
+
+// removeExpressions удаляет завершенные выражения вместе с их задачами.
+// Вызывается под мьютексом приложения.
+func (app *Application) removeExpressions(exprs []*Expression) {
+	if len(exprs) == 0 {
+		return
+	}
+	removed := make(map[int]bool)
+	for _, expr := range exprs {
+		for _, task := range expr.Tasks {
+			delete(app.tasks, task.ID)
+			delete(app.taskToExpression, task.ID)
+			removed[task.ID] = true
+		}
+		app.forgetKey(expr)
+		delete(app.expressions, expr.ID)
+	}
+	app.dropFollowers(removed)
+
+	// Повторно выданные задачи удаленных выражений могли остаться в очереди
+	taskQueue := app.taskQueue[:0]
+	for _, task := range app.taskQueue {
+		if !removed[task.ID] {
+			taskQueue = append(taskQueue, task)
+		}
+	}
+	app.taskQueue = taskQueue
+}
+
+// evicted сообщает, что выражение с таким ID существовало и было удалено.
+// Вызывается под мьютексом приложения.
+func (app *Application) evicted(id int) bool {
+	_, exists := app.expressions[id]
+	return !exists && id > 0 && id < app.nextExpressionID
+}
+
+// missingExpression возвращает ошибку для отсутствующего выражения: ErrExpressionEvicted,
+// если оно было удалено, иначе ErrExpressionNotFound. Вызывается под мьютексом приложения.
+func (app *Application) missingExpression(id int) error {
+	if app.evicted(id) {
+		return fmt.Errorf("expression ID %d: %w", id, ErrExpressionEvicted)
+	}
+	return fmt.Errorf("expression ID %d: %w", id, ErrExpressionNotFound)
+}
@@ -0,0 +1,244 @@
+package application
+
+import (
+	"fmt"
+
+	"github.com/syirnik/GO_Yandex/pkg/calculation"
+)
+
+// Планировщик зависимых задач. Выражение — граф задач: задача ждет результатов
+// родительских задач (ParentTasks) и условий ветвей (Guards). Для каждой задачи известны
+// зависимые от нее задачи (children) и счетчик еще неизвестных результатов (waiting), поэтому
+// новый результат затрагивает только прямые зависимые. Для каждой из них планировщик
+// принимает решение: ждать, пропустить, отправить агентам, вычислить самому или
+// завершить выражение ошибкой.
+//
+// Управляющие задачи — условный оператор "?" и ленивые && и || — агентам не отправляются:
+// их результат выбирает оркестратор, как только известно условие и нужный операнд.
+// Задачи ветви, которая не понадобилась, получают статус skipped и никогда не выполняются.
+
+// decision — решение планировщика о зависимой задаче
+type decision int
+
+const (
+	waitTask     decision = iota // Результаты родителей или условий еще неизвестны
+	skipTask                     // Ветвь не выбрана: задача не выполняется
+	dispatchTask                 // Аргументы известны: задача отправляется агентам
+	resolveTask                  // Результат управляющей задачи известен без агента
+	rejectTask                   // Деление на ноль: выражение завершается ошибкой
+)
+
+// Состояния условий задачи (см. guardState)
+const (
+	guardPassed  = iota // Все условия выполнены
+	guardPending        // Результат какого-то условия еще неизвестен
+	guardFailed         // Какое-то условие не выполнено: ветвь не выбрана
+)
+
+// isControl сообщает, что задачу выполняет сам оркестратор
+func isControl(operation string) bool {
+	return operation == "?" || operation == "&&" || operation == "||"
+}
+
+// resolution — управляющая задача и ее результат
+type resolution struct {
+	task  *Task
+	value string
+}
+
+// addDependent регистрирует зависимые задачи нового выражения: каждая задача попадает
+// в списки ожидающих тех родителей и условий, результатов которых еще нет
+func (app *Application) addDependent(tasks []*Task) {
+	var candidates []*Task
+	for _, task := range tasks {
+		app.dependent[task.ID] = task
+		waitsFor := make(map[int]bool, len(task.ParentTasks)+len(task.Guards))
+		for _, parentID := range task.ParentTasks {
+			waitsFor[parentID] = true
+		}
+		for _, guard := range task.Guards {
+			waitsFor[guard.TaskID] = true
+		}
+		for id := range waitsFor {
+			if _, known := app.taskResults[id]; !known {
+				app.children[id] = append(app.children[id], task)
+				task.waiting++
+			}
+		}
+		if task.waiting == 0 {
+			candidates = append(candidates, task)
+		}
+		app.logger().Debug("Task waits for results", "task_id", task.ID, "waiting", task.waiting)
+	}
+	if err := app.schedule(candidates); err != nil {
+		app.logger().Error("Failed to schedule tasks", "error", err)
+	}
+}
+
+// advance продвигает задачи, ожидавшие результата задачи taskID: затрагиваются
+// только ее прямые зависимые. Вызывается под мьютексом приложения.
+func (app *Application) advance(taskID int) error {
+	children := app.children[taskID]
+	delete(app.children, taskID)
+
+	var candidates []*Task
+	for _, child := range children {
+		if _, waiting := app.dependent[child.ID]; !waiting {
+			continue // Задача уже пропущена, отправлена агентам или снята с очереди
+		}
+		child.waiting--
+		// Обычная задача ждет всех родителей; управляющая задача и задача ветви
+		// проверяются сразу: они могут завершиться или пропуститься раньше
+		if child.waiting > 0 && !isControl(child.Operation) && len(child.Guards) == 0 {
+			continue
+		}
+		candidates = append(candidates, child)
+	}
+	return app.schedule(candidates)
+}
+
+// schedule принимает решения о задачах-кандидатах и выполняет их
+func (app *Application) schedule(candidates []*Task) error {
+	var ready, rejected, skipped []*Task
+	var resolved []resolution
+	for _, task := range candidates {
+		decided, value := app.decide(task)
+		if decided == waitTask {
+			continue
+		}
+		// Задача больше не ждет: убираем ее из зависимых до постановки готовых,
+		// потому что задача из кэша завершается сразу и продвигает свои зависимые
+		delete(app.dependent, task.ID)
+		switch decided {
+		case skipTask:
+			skipped = append(skipped, task)
+		case dispatchTask:
+			ready = append(ready, task)
+		case resolveTask:
+			resolved = append(resolved, resolution{task: task, value: value})
+		case rejectTask:
+			rejected = append(rejected, task)
+		}
+	}
+
+	for _, task := range skipped {
+		task.Status = "skipped"
+		app.logger().Debug("Task skipped: branch not selected", "task_id", task.ID)
+	}
+
+	// Деление на ноль завершает выражение ошибкой; результат агента при этом принят
+	for _, task := range rejected {
+		task.Status = "failed"
+		app.failExpression(app.expressionOf(task), fmt.Errorf("task with ID %d: %w", task.ID, calculation.ErrDivisionByZero))
+	}
+
+	for _, task := range ready {
+		if app.expressionOf(task).Status != "pending" {
+			continue
+		}
+		if err := app.enqueueReady(task); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range resolved {
+		if r.task.Status != "pending" || app.expressionOf(r.task).Status != "pending" {
+			continue
+		}
+		app.logger().Debug("Control task resolved", "task_id", r.task.ID, "operation", r.task.Operation, "result", r.value)
+		if err := app.completeTask(r.task.ID, r.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decide принимает решение о зависимой задаче; для resolveTask возвращает ее результат
+func (app *Application) decide(task *Task) (decision, string) {
+	// Задачи ветвей ждут условия; задачи невыбранной ветви пропускаются
+	switch app.guardState(task) {
+	case guardFailed:
+		return skipTask, ""
+	case guardPending:
+		return waitTask, ""
+	}
+
+	if isControl(task.Operation) {
+		if value, ok := app.controlValue(task); ok {
+			return resolveTask, value
+		}
+		return waitTask, ""
+	}
+
+	// Подставляем результаты родительских задач в аргументы
+	for i, parentID := range task.ParentTasks {
+		res, exists := app.taskResults[parentID]
+		if !exists {
+			app.logger().Debug("Task is waiting for parent task", "task_id", task.ID, "parent_task_id", parentID)
+			return waitTask, ""
+		}
+		task.setArg(i, res)
+	}
+
+	if task.divisionByZero() {
+		app.logger().Debug("Division by zero detected", "task_id", task.ID)
+		return rejectTask, ""
+	}
+	if !task.IsReady {
+		task.IsReady = true
+		app.logger().Debug("Task is ready", "task_id", task.ID)
+	}
+	return dispatchTask, ""
+}
+
+// guardState проверяет условия ветвей, внутри которых находится задача
+func (app *Application) guardState(task *Task) int {
+	state := guardPassed
+	for _, guard := range task.Guards {
+		cond, exists := app.taskResults[guard.TaskID]
+		if !exists {
+			state = guardPending
+			continue
+		}
+		if task.backend.IsZero(cond) == guard.When {
+			return guardFailed
+		}
+	}
+	return state
+}
+
+// controlValue вычисляет результат управляющей задачи, если известны условие (первый
+// родитель) и нужный операнд. Для && при ложном и для || при истинном условии
+// второй операнд не нужен.
+func (app *Application) controlValue(task *Task) (string, bool) {
+	cond, exists := app.taskResults[task.ParentTasks[0]]
+	if !exists {
+		return "", false
+	}
+	truthy := !task.backend.IsZero(cond)
+
+	switch task.Operation {
+	case "?":
+		branch := task.ParentTasks[1]
+		if !truthy {
+			branch = task.ParentTasks[2]
+		}
+		value, exists := app.taskResults[branch]
+		return value, exists
+	case "&&":
+		if !truthy {
+			return "0", true
+		}
+	case "||":
+		if truthy {
+			return "1", true
+		}
+	}
+
+	other, exists := app.taskResults[task.ParentTasks[1]]
+	if !exists {
+		return "", false
+	}
+	value, err := task.backend.Apply(task.Operation, cond, other)
+	return value, err == nil
+}
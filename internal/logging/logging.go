@@ -0,0 +1,56 @@
+// Package logging настраивает структурированный журнал (log/slog) оркестратора
+// и агента: уровень подробности и формат вывода задаются переменными среды.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Форматы вывода журнала
+const (
+	FormatText = "text" // key=value, удобно читать в терминале
+	FormatJSON = "json" // Одна JSON-запись на строку, для сборщиков логов
+)
+
+// ParseLevel разбирает уровень журнала: debug, info, warn или error (без учета регистра).
+// Пустая строка означает info.
+func ParseLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got: %s", value)
+}
+
+// New создает журнал, пишущий в w записи не ниже level в формате format (text или json)
+func New(w io.Writer, level slog.Level, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("LOG_FORMAT must be text or json, got: %s", format)
+}
+
+// FromEnv создает журнал в stderr по переменным среды:
+//   - LOG_LEVEL: debug, info (по умолчанию), warn или error;
+//   - LOG_FORMAT: text (по умолчанию) или json.
+func FromEnv() (*slog.Logger, error) {
+	level, err := ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		return nil, err
+	}
+	return New(os.Stderr, level, os.Getenv("LOG_FORMAT"))
+}
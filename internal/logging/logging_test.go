@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestParseLevel проверяет разбор LOG_LEVEL.
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"", slog.LevelInfo, false},
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"warning", slog.LevelWarn, false},
+		{" error ", slog.LevelError, false},
+		{"verbose", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v; expected %v (error: %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestNew проверяет формат вывода и отбрасывание записей ниже уровня.
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, slog.LevelInfo, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden")
+	logger.Info("Task completed", "task_id", 7)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "Task completed" || record["task_id"] != float64(7) || record["level"] != "INFO" {
+		t.Errorf("unexpected record: %v", record)
+	}
+
+	buf.Reset()
+	logger, _ = New(&buf, slog.LevelDebug, "")
+	logger.Debug("Task completed", "task_id", 7)
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), "task_id=7") {
+		t.Errorf("expected text record, got %q", buf.String())
+	}
+
+	if _, err := New(&buf, slog.LevelInfo, "xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+// TestFromEnv проверяет ошибки в LOG_LEVEL и LOG_FORMAT.
+func TestFromEnv(t *testing.T) {
+	defer os.Unsetenv("LOG_LEVEL")
+	defer os.Unsetenv("LOG_FORMAT")
+
+	os.Setenv("LOG_LEVEL", "debug")
+	os.Setenv("LOG_FORMAT", "json")
+	logger, err := FromEnv()
+	if err != nil || !logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Errorf("expected debug logger, got error %v", err)
+	}
+
+	os.Setenv("LOG_LEVEL", "loud")
+	if _, err := FromEnv(); err == nil {
+		t.Error("expected error for invalid LOG_LEVEL")
+	}
+	os.Setenv("LOG_LEVEL", "info")
+	os.Setenv("LOG_FORMAT", "yaml")
+	if _, err := FromEnv(); err == nil {
+		t.Error("expected error for invalid LOG_FORMAT")
+	}
+}
@@ -0,0 +1,361 @@
+// Package metrics реализует счетчики, показатели и гистограммы с выводом
+// в текстовом формате Prometheus (text exposition format 0.0.4).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType — тип содержимого ответа /metrics
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets — границы гистограммы по умолчанию (секунды), подходят для времени HTTP-запросов
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector — метрика реестра
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry хранит метрики и выводит их в порядке регистрации
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+// NewRegistry создает пустой реестр
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register добавляет метрику; повторное имя — ошибка программы
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText выводит все метрики в текстовом формате Prometheus
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler отдает метрики по HTTP
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		if err := r.WriteText(w); err != nil {
+			slog.Warn("Failed to write metrics", "error", err)
+		}
+	})
+}
+
+// desc — имя, описание и метки метрики
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// writeHeader выводит строки HELP и TYPE
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// key склеивает значения меток в ключ серии
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// series — значения серий метрики по ключу меток
+type series[T any] struct {
+	mu     sync.Mutex
+	values map[string]T
+	labels map[string][]string
+}
+
+// sortedKeys возвращает ключи серий по возрастанию, чтобы вывод был стабильным
+func (s *series[T]) sortedKeys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter — монотонно растущий счетчик с метками
+type Counter struct {
+	desc
+	series[float64]
+}
+
+// NewCounter регистрирует счетчик
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter", labels}}
+	c.values = make(map[string]float64)
+	c.series.labels = make(map[string][]string)
+	r.register(name, c)
+	return c
+}
+
+// Inc увеличивает счетчик серии на 1
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add увеличивает счетчик серии на v (v >= 0)
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.name + " cannot decrease")
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.values[key]; !exists {
+		c.series.labels[key] = append([]string(nil), labelValues...)
+	}
+	c.values[key] += v
+}
+
+// Value возвращает значение серии
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range c.sortedKeys() {
+		writeSample(w, c.name, c.desc.labels, c.series.labels[key], "", "", c.values[key])
+	}
+}
+
+// Gauge — показатель, который может расти и уменьшаться
+type Gauge struct {
+	desc
+	series[float64]
+}
+
+// NewGauge регистрирует показатель
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name, help, "gauge", labels}}
+	g.values = make(map[string]float64)
+	g.series.labels = make(map[string][]string)
+	r.register(name, g)
+	return g
+}
+
+// Set задает значение серии
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.update(labelValues, func(float64) float64 { return v })
+}
+
+// Add изменяет значение серии на v
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.update(labelValues, func(old float64) float64 { return old + v })
+}
+
+// Inc увеличивает значение серии на 1
+func (g *Gauge) Inc(labelValues ...string) { g.Add(1, labelValues...) }
+
+// Dec уменьшает значение серии на 1
+func (g *Gauge) Dec(labelValues ...string) { g.Add(-1, labelValues...) }
+
+// Value возвращает значение серии
+func (g *Gauge) Value(labelValues ...string) float64 {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[key]
+}
+
+func (g *Gauge) update(labelValues []string, fn func(float64) float64) {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, exists := g.values[key]; !exists {
+		g.series.labels[key] = append([]string(nil), labelValues...)
+	}
+	g.values[key] = fn(g.values[key])
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range g.sortedKeys() {
+		writeSample(w, g.name, g.desc.labels, g.series.labels[key], "", "", g.values[key])
+	}
+}
+
+// gaugeFunc — показатель, значения которого вычисляются при каждом выводе
+type gaugeFunc struct {
+	desc
+	collect func(emit func(v float64, labelValues ...string))
+}
+
+// NewGaugeFunc регистрирует показатель, значения которого collect передает в emit
+// при каждом выводе метрик (например, длины очередей)
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func(emit func(v float64, labelValues ...string))) {
+	r.register(name, &gaugeFunc{desc: desc{name, help, "gauge", labels}, collect: collect})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.collect(func(v float64, labelValues ...string) {
+		g.key(labelValues) // Проверяем количество меток
+		writeSample(w, g.name, g.labels, labelValues, "", "", v)
+	})
+}
+
+// Histogram — распределение наблюдаемых значений по интервалам
+type Histogram struct {
+	desc
+	series[*histogramData]
+	buckets []float64
+}
+
+// histogramData — накопленные значения одной серии гистограммы
+type histogramData struct {
+	counts []uint64 // Количество наблюдений не больше соответствующей границы (без накопления)
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram регистрирует гистограмму с возрастающими границами buckets
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: histogram " + name + " buckets must be sorted")
+	}
+	h := &Histogram{desc: desc{name, help, "histogram", labels}, buckets: buckets}
+	h.values = make(map[string]*histogramData)
+	h.series.labels = make(map[string][]string)
+	r.register(name, h)
+	return h
+}
+
+// Observe добавляет наблюдение в серию
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	data, exists := h.values[key]
+	if !exists {
+		data = &histogramData{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = data
+		h.series.labels[key] = append([]string(nil), labelValues...)
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		data.counts[i]++
+	}
+	data.count++
+	data.sum += v
+}
+
+// Count возвращает количество наблюдений серии
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if data, exists := h.values[key]; exists {
+		return data.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range h.sortedKeys() {
+		data, labelValues := h.values[key], h.series.labels[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += data.counts[i]
+			writeSample(w, h.name+"_bucket", h.desc.labels, labelValues, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.desc.labels, labelValues, "le", "+Inf", float64(data.count))
+		writeSample(w, h.name+"_sum", h.desc.labels, labelValues, "", "", data.sum)
+		writeSample(w, h.name+"_count", h.desc.labels, labelValues, "", "", float64(data.count))
+	}
+}
+
+// writeSample выводит строку значения: имя{метки} значение.
+// extraName и extraValue добавляют метку le у интервалов гистограммы.
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(labelValues[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// formatFloat записывает число так, как ожидает Prometheus
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabel экранирует обратную косую черту, кавычку и перевод строки в значении метки
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// escapeHelp экранирует обратную косую черту и перевод строки в описании метрики
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestWriteText проверяет вывод счетчиков, показателей и гистограмм в формате Prometheus.
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Requests by method.", "method")
+	busy := reg.NewGauge("busy", "Busy workers.")
+	reg.NewGaugeFunc("queue_length", "Queue length.", []string{"queue"}, func(emit func(float64, ...string)) {
+		emit(3, "ready")
+	})
+	latency := reg.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+
+	requests.Inc("POST")
+	requests.Add(2, "GET")
+	busy.Inc()
+	busy.Inc()
+	busy.Dec()
+	latency.Observe(0.05, "+")
+	latency.Observe(0.1, "+")
+	latency.Observe(5, "+")
+	requests.Inc(`say "hi"` + "\n")
+
+	rr := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Header().Get("Content-Type") != ContentType {
+		t.Errorf("expected Content-Type %q, got %q", ContentType, rr.Header().Get("Content-Type"))
+	}
+
+	want := `# HELP requests_total Requests by method.
+# TYPE requests_total counter
+requests_total{method="GET"} 2
+requests_total{method="POST"} 1
+requests_total{method="say \"hi\"\n"} 1
+# HELP busy Busy workers.
+# TYPE busy gauge
+busy 1
+# HELP queue_length Queue length.
+# TYPE queue_length gauge
+queue_length{queue="ready"} 3
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="+",le="0.1"} 2
+latency_seconds_bucket{op="+",le="1"} 2
+latency_seconds_bucket{op="+",le="+Inf"} 3
+latency_seconds_sum{op="+"} 5.15
+latency_seconds_count{op="+"} 3
+`
+	if got := rr.Body.String(); got != want {
+		t.Errorf("unexpected metrics output:\n%s\nwant:\n%s", got, want)
+	}
+	if latency.Count("+") != 3 || requests.Value("GET") != 2 || busy.Value() != 1 {
+		t.Errorf("unexpected values: count %d, GET %v, busy %v", latency.Count("+"), requests.Value("GET"), busy.Value())
+	}
+}
+
+// TestRegistryMisuse проверяет, что повторное имя метрики и неверное число меток — ошибки программы.
+func TestRegistryMisuse(t *testing.T) {
+	reg := NewRegistry()
+	counter := reg.NewCounter("dup_total", "Duplicate.", "a")
+
+	for name, fn := range map[string]func(){
+		"duplicate name":   func() { reg.NewGauge("dup_total", "Duplicate.") },
+		"missing label":    func() { counter.Inc() },
+		"negative counter": func() { counter.Add(-1, "x") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+	var out strings.Builder
+	reg.WriteText(&out)
+	if strings.Contains(out.String(), "dup_total{") {
+		t.Errorf("expected no samples after failed updates, got:\n%s", out.String())
+	}
+}
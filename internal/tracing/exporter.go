@@ -0,0 +1,231 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WriterExporter пишет span'ы в w по одному JSON-объекту на строку
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter создает экспортер в поток (os.Stdout, файл)
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// Export записывает span'ы
+func (e *WriterExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	encoder := json.NewEncoder(e.w)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPExporter отправляет span'ы коллектору OpenTelemetry по OTLP/HTTP в формате JSON
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLPExporter создает экспортер на адрес url (например, http://localhost:4318/v1/traces)
+func NewOTLPExporter(url string) *OTLPExporter {
+	return &OTLPExporter{url: url, client: &http.Client{}}
+}
+
+// Export отправляет span'ы одним запросом, сгруппировав их по сервису
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP endpoint %s responded with status %d", e.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// Структуры OTLP/JSON (ExportTraceServiceRequest)
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 1 — OK, 2 — ERROR
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// instrumentationScope — имя библиотеки, создавшей span'ы
+const instrumentationScope = "github.com/syirnik/GO_Yandex/internal/tracing"
+
+// otlpRequest группирует span'ы по сервису и переводит в формат OTLP
+func otlpRequest(spans []SpanData) otlpTraces {
+	byService := make(map[string][]otlpSpan)
+	var services []string
+	for _, span := range spans {
+		if _, exists := byService[span.Service]; !exists {
+			services = append(services, span.Service)
+		}
+		byService[span.Service] = append(byService[span.Service], otlpSpanOf(span))
+	}
+
+	var request otlpTraces
+	for _, service := range services {
+		request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+			Resource:   otlpResource{Attributes: []otlpKeyValue{otlpAttribute("service.name", service)}},
+			ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: instrumentationScope}, Spans: byService[service]}},
+		})
+	}
+	return request
+}
+
+// otlpSpanOf переводит span в формат OTLP
+func otlpSpanOf(span SpanData) otlpSpan {
+	result := otlpSpan{
+		TraceID:           span.TraceID,
+		SpanID:            span.SpanID,
+		ParentSpanID:      span.ParentSpanID,
+		Name:              span.Name,
+		Kind:              1, // SPAN_KIND_INTERNAL
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: 1},
+	}
+	if span.Error {
+		result.Status = otlpStatus{Code: 2, Message: span.StatusMessage}
+	}
+
+	keys := make([]string, 0, len(span.Attributes))
+	for key := range span.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Attributes = append(result.Attributes, otlpAttribute(key, span.Attributes[key]))
+	}
+	return result
+}
+
+// otlpAttribute переводит атрибут в пару ключ-значение OTLP
+func otlpAttribute(key string, value any) otlpKeyValue {
+	var v otlpValue
+	switch value := value.(type) {
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	case bool:
+		v.BoolValue = &value
+	case string:
+		v.StringValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
+
+// defaultOTLPEndpoint — адрес коллектора OpenTelemetry по умолчанию (OTLP/HTTP)
+const defaultOTLPEndpoint = "http://localhost:4318"
+
+// ExporterFromEnv создает экспортер по переменным среды:
+//   - TRACING_EXPORTER: пусто или none — без трассировки, stdout, file или otlp;
+//   - TRACING_FILE: файл для file (по умолчанию traces.jsonl), span'ы дописываются в конец;
+//   - OTEL_EXPORTER_OTLP_ENDPOINT: адрес коллектора для otlp (по умолчанию http://localhost:4318),
+//     span'ы отправляются на <адрес>/v1/traces.
+//
+// Возвращает также функцию закрытия файла (для остальных экспортеров ничего не делает).
+func ExporterFromEnv() (Exporter, func() error, error) {
+	noop := func() error { return nil }
+	switch kind := os.Getenv("TRACING_EXPORTER"); kind {
+	case "", "none":
+		return nil, noop, nil
+	case "stdout":
+		return NewWriterExporter(os.Stdout), noop, nil
+	case "file":
+		path := os.Getenv("TRACING_FILE")
+		if path == "" {
+			path = "traces.jsonl"
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, noop, fmt.Errorf("TRACING_FILE: %w", err)
+		}
+		return NewWriterExporter(file), file.Close, nil
+	case "otlp":
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if endpoint == "" {
+			endpoint = defaultOTLPEndpoint
+		}
+		return NewOTLPExporter(strings.TrimRight(endpoint, "/") + "/v1/traces"), noop, nil
+	default:
+		return nil, noop, fmt.Errorf("TRACING_EXPORTER must be none, stdout, file or otlp, got: %s", kind)
+	}
+}
@@ -0,0 +1,319 @@
+// Package tracing реализует трассировку в духе OpenTelemetry: span'ы с W3C
+// traceparent для передачи контекста между оркестратором и агентами и экспорт
+// завершенных span'ов пачками через Exporter (OTLP/HTTP или JSON-строки в файл).
+//
+// Нулевой *Tracer и нулевой *Span допустимы и ничего не делают: так трассировка
+// отключается без проверок в вызывающем коде.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader — заголовок W3C Trace Context
+const TraceparentHeader = "traceparent"
+
+// TraceID — идентификатор трассы
+type TraceID [16]byte
+
+// SpanID — идентификатор span'а
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext — идентификаторы span'а, передаваемые между процессами
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid сообщает, что оба идентификатора ненулевые
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent записывает контекст в формате W3C: 00-<trace-id>-<span-id>-01
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01"
+}
+
+// ParseTraceparent разбирает заголовок traceparent версии 00
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	return sc, sc.IsValid()
+}
+
+// contextKey — ключ родительского span'а в контексте
+type contextKey struct{}
+
+// ContextWithSpanContext кладет в контекст удаленный родительский span (например, из заголовка)
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// SpanContextFrom возвращает родительский span из контекста
+func SpanContextFrom(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Extract кладет в контекст родительский span из заголовка traceparent запроса
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if sc, ok := ParseTraceparent(header.Get(TraceparentHeader)); ok {
+		return ContextWithSpanContext(ctx, sc)
+	}
+	return ctx
+}
+
+// Inject записывает span в заголовок traceparent
+func Inject(span *Span, header http.Header) {
+	if traceparent := span.Context().Traceparent(); traceparent != "" {
+		header.Set(TraceparentHeader, traceparent)
+	}
+}
+
+// SpanData — завершенный span, передаваемый экспортеру
+type SpanData struct {
+	Service       string         `json:"service"`
+	Name          string         `json:"name"`
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentSpanID  string         `json:"parent_span_id,omitempty"`
+	Start         time.Time      `json:"start"`
+	End           time.Time      `json:"end"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	Error         bool           `json:"error,omitempty"`
+	StatusMessage string         `json:"status_message,omitempty"`
+}
+
+// Span — этап обработки. Методы безопасны для одновременного вызова; End можно
+// вызвать только один раз, повторные вызовы игнорируются.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Context возвращает идентификаторы span'а
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttribute добавляет атрибут: строку, число или bool
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any)
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError отмечает span как завершившийся ошибкой
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = true
+	s.data.StatusMessage = err.Error()
+}
+
+// End завершает span и передает его на экспорт
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.enqueue(data)
+}
+
+// Exporter отправляет завершенные span'ы
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Параметры отправки пачек span'ов
+const (
+	queueSize     = 4096            // Span'ы сверх очереди отбрасываются
+	batchSize     = 256             // Максимум span'ов в одной отправке
+	flushInterval = 1 * time.Second // Как часто отправляется неполная пачка
+	exportTimeout = 10 * time.Second
+)
+
+// Tracer создает span'ы и отправляет завершенные экспортеру в фоне пачками
+type Tracer struct {
+	service  string
+	exporter Exporter
+
+	queue   chan SpanData
+	done    chan struct{}
+	dropped int64
+	mu      sync.Mutex // Защищает dropped и закрытие queue
+	closed  bool
+}
+
+// NewTracer создает трассировщик сервиса service; exporter == nil отключает трассировку
+func NewTracer(service string, exporter Exporter) *Tracer {
+	if exporter == nil {
+		return nil
+	}
+	t := &Tracer{
+		service:  service,
+		exporter: exporter,
+		queue:    make(chan SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start начинает span. Родитель — span из контекста (локальный или удаленный);
+// без родителя начинается новая трасса. Возвращает контекст с новым span'ом.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent, _ := SpanContextFrom(ctx)
+	span := t.StartWithParent(parent, name)
+	if span == nil {
+		return ctx, nil
+	}
+	return ContextWithSpanContext(ctx, span.sc), span
+}
+
+// StartWithParent начинает span с явным родителем; невалидный родитель начинает новую трассу
+func (t *Tracer) StartWithParent(parent SpanContext, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	span := &Span{tracer: t}
+	if parent.IsValid() {
+		span.sc.TraceID = parent.TraceID
+		span.data.ParentSpanID = parent.SpanID.String()
+	} else {
+		rand.Read(span.sc.TraceID[:])
+	}
+	rand.Read(span.sc.SpanID[:])
+
+	span.data.Service = t.service
+	span.data.Name = name
+	span.data.TraceID = span.sc.TraceID.String()
+	span.data.SpanID = span.sc.SpanID.String()
+	span.data.Start = time.Now()
+	return span
+}
+
+// enqueue ставит завершенный span в очередь на экспорт, не блокируя вызывающего
+func (t *Tracer) enqueue(data SpanData) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		t.dropped++
+		if t.dropped == 1 || t.dropped%1000 == 0 {
+			slog.Warn("Tracing export queue is full, spans dropped", "dropped", t.dropped)
+		}
+	}
+}
+
+// run собирает span'ы в пачки и отправляет экспортеру
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			slog.Warn("Failed to export spans", "spans", len(batch), "error", err)
+		}
+		cancel()
+		batch = nil
+	}
+
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown отправляет оставшиеся span'ы и останавливает трассировщик
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("tracing shutdown: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryExporter запоминает экспортированные span'ы
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *memoryExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// TestTraceparent проверяет запись и разбор заголовка W3C traceparent.
+func TestTraceparent(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(value)
+	if !ok || sc.Traceparent() != value {
+		t.Fatalf("expected round trip of %q, got %q (ok=%v)", value, sc.Traceparent(), ok)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+	} {
+		if _, ok := ParseTraceparent(invalid); ok {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+
+	header := http.Header{}
+	header.Set(TraceparentHeader, value)
+	if parent, ok := SpanContextFrom(Extract(context.Background(), header)); !ok || parent != sc {
+		t.Errorf("expected extracted parent %v, got %v", sc, parent)
+	}
+}
+
+// TestTracer проверяет связи span'ов, атрибуты, ошибки и отправку при остановке.
+func TestTracer(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer("test", exporter)
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("answer", 42)
+	child.SetError(errors.New("boom"))
+	child.End()
+	child.End() // Повторное завершение игнорируется
+	root.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(exporter.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(exporter.spans))
+	}
+	got, parent := exporter.spans[0], exporter.spans[1]
+	if got.Name != "child" || got.TraceID != parent.TraceID || got.ParentSpanID != parent.SpanID || parent.ParentSpanID != "" {
+		t.Errorf("unexpected span relations: %+v, %+v", got, parent)
+	}
+	if got.Attributes["answer"] != 42 || !got.Error || got.StatusMessage != "boom" || got.Service != "test" {
+		t.Errorf("unexpected child span: %+v", got)
+	}
+
+	// Отключенная трассировка: нулевые трассировщик и span ничего не делают
+	var disabled *Tracer
+	_, span := disabled.Start(context.Background(), "noop")
+	span.SetAttribute("key", "value")
+	span.End()
+	if span.Context().IsValid() || NewTracer("test", nil) != nil {
+		t.Error("expected disabled tracer to create no spans")
+	}
+}
+
+// TestExporters проверяет запись JSON-строк, формат OTLP/JSON и выбор экспортера по переменным среды.
+func TestExporters(t *testing.T) {
+	span := SpanData{Service: "orchestrator", Name: "expression", TraceID: strings.Repeat("a", 32), SpanID: strings.Repeat("b", 16),
+		Attributes: map[string]any{"expression.id": 1, "expression.status": "failed"}, Error: true, StatusMessage: "division by zero"}
+
+	var buf bytes.Buffer
+	NewWriterExporter(&buf).Export(context.Background(), []SpanData{span, span})
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 || !strings.Contains(buf.String(), `"name":"expression"`) {
+		t.Errorf("expected 2 JSON lines, got %q", buf.String())
+	}
+
+	var received otlpTraces
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer collector.Close()
+
+	os.Setenv("TRACING_EXPORTER", "otlp")
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL+"/")
+	defer os.Unsetenv("TRACING_EXPORTER")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	exporter, _, err := ExporterFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Export(context.Background(), []SpanData{span}); err != nil {
+		t.Fatal(err)
+	}
+	if len(received.ResourceSpans) != 1 {
+		t.Fatalf("expected 1 resource, got %+v", received)
+	}
+	resource := received.ResourceSpans[0]
+	got := resource.ScopeSpans[0].Spans[0]
+	if *resource.Resource.Attributes[0].Value.StringValue != "orchestrator" || got.TraceID != span.TraceID ||
+		got.Status.Code != 2 || *got.Attributes[0].Value.IntValue != "1" {
+		t.Errorf("unexpected OTLP request: %+v", received)
+	}
+
+	os.Setenv("TRACING_EXPORTER", "jaeger")
+	if _, _, err := ExporterFromEnv(); err == nil {
+		t.Error("expected error for unknown exporter")
+	}
+}
@@ -0,0 +1,175 @@
+package calculation
+
+import (
+	"strings"
+)
+
+// Span — диапазон символов (рун) исходного выражения [Start, End)
+type Span struct {
+	Start int
+	End   int
+}
+
+// Node — узел абстрактного синтаксического дерева выражения
+type Node interface {
+	// Span возвращает диапазон исходного выражения, из которого построен узел
+	Span() Span
+	// String возвращает выражение узла в каноническом виде (см. Format)
+	String() string
+}
+
+// NumberNode — числовой литерал в исходной записи
+type NumberNode struct {
+	Value string
+	Loc   Span
+}
+
+// BinaryNode — бинарная операция: Left Op Right
+type BinaryNode struct {
+	Op    string
+	Left  Node
+	Right Node
+	Loc   Span
+}
+
+// UnaryNode — унарная операция: Op Operand
+type UnaryNode struct {
+	Op      string
+	Operand Node
+	Loc     Span
+}
+
+// TernaryNode — условный оператор: Cond ? Then : Else
+type TernaryNode struct {
+	Cond Node
+	Then Node
+	Else Node
+	Loc  Span
+}
+
+// CallNode — вызов функции: Name(Args...)
+type CallNode struct {
+	Name string
+	Args []Node
+	Loc  Span
+}
+
+// VariableNode — обращение к переменной по имени
+type VariableNode struct {
+	Name string
+	Loc  Span
+}
+
+func (n *NumberNode) Span() Span   { return n.Loc }
+func (n *BinaryNode) Span() Span   { return n.Loc }
+func (n *UnaryNode) Span() Span    { return n.Loc }
+func (n *TernaryNode) Span() Span  { return n.Loc }
+func (n *CallNode) Span() Span     { return n.Loc }
+func (n *VariableNode) Span() Span { return n.Loc }
+
+func (n *NumberNode) String() string   { return Format(n) }
+func (n *BinaryNode) String() string   { return Format(n) }
+func (n *UnaryNode) String() string    { return Format(n) }
+func (n *TernaryNode) String() string  { return Format(n) }
+func (n *CallNode) String() string     { return Format(n) }
+func (n *VariableNode) String() string { return Format(n) }
+
+const (
+	// ternaryPrecedence — приоритет условного оператора: ниже любого бинарного
+	ternaryPrecedence = 0
+	// unaryPrecedence — приоритет унарных операторов: выше любого бинарного
+	unaryPrecedence = 100
+)
+
+// IsBoolean сообщает, что значение узла логическое (1 или 0):
+// сравнение, логическая операция или условный оператор с логическими ветвями
+func IsBoolean(node Node) bool {
+	switch n := node.(type) {
+	case *BinaryNode:
+		return IsComparison(n.Op) || n.Op == "&&" || n.Op == "||"
+	case *UnaryNode:
+		return n.Op == "!"
+	case *TernaryNode:
+		return IsBoolean(n.Then) && IsBoolean(n.Else)
+	default:
+		return false
+	}
+}
+
+// IsComparison сообщает, что оператор — сравнение
+func IsComparison(operator string) bool {
+	switch operator {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	default:
+		return false
+	}
+}
+
+// Format печатает дерево обратно в строку, расставляя только необходимые скобки
+func Format(node Node) string {
+	var sb strings.Builder
+	formatNode(&sb, node)
+	return sb.String()
+}
+
+// formatNode дописывает запись узла в sb
+func formatNode(sb *strings.Builder, node Node) {
+	switch n := node.(type) {
+	case *NumberNode:
+		sb.WriteString(n.Value)
+	case *VariableNode:
+		sb.WriteString(n.Name)
+	case *CallNode:
+		sb.WriteString(n.Name)
+		sb.WriteByte('(')
+		for i, arg := range n.Args {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			formatNode(sb, arg)
+		}
+		sb.WriteByte(')')
+	case *UnaryNode:
+		sb.WriteString(n.Op)
+		formatOperand(sb, n.Operand, nodePrecedence(n.Operand) < unaryPrecedence)
+	case *BinaryNode:
+		prec := binaryPrecedence[n.Op]
+		formatOperand(sb, n.Left, nodePrecedence(n.Left) < prec)
+		sb.WriteString(" " + n.Op + " ")
+		// Правый операнд с тем же приоритетом берется в скобки: a - (b - c), a / (b * c)
+		formatOperand(sb, n.Right, nodePrecedence(n.Right) <= prec)
+	case *TernaryNode:
+		// Условный оператор правоассоциативен: вложенный в условие берется в скобки
+		formatOperand(sb, n.Cond, nodePrecedence(n.Cond) <= ternaryPrecedence)
+		sb.WriteString(" ? ")
+		formatNode(sb, n.Then)
+		sb.WriteString(" : ")
+		formatNode(sb, n.Else)
+	}
+}
+
+// formatOperand печатает операнд, при необходимости в скобках
+func formatOperand(sb *strings.Builder, node Node, parenthesize bool) {
+	if parenthesize {
+		sb.WriteByte('(')
+	}
+	formatNode(sb, node)
+	if parenthesize {
+		sb.WriteByte(')')
+	}
+}
+
+// nodePrecedence возвращает приоритет узла для расстановки скобок
+func nodePrecedence(node Node) int {
+	switch n := node.(type) {
+	case *BinaryNode:
+		return binaryPrecedence[n.Op]
+	case *UnaryNode:
+		return unaryPrecedence
+	case *TernaryNode:
+		return ternaryPrecedence
+	default:
+		return unaryPrecedence + 1
+	}
+}
@@ -1,6 +1,11 @@
 package calculation
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
 	"testing"
 )
 
@@ -30,6 +35,11 @@ func TestCalc(t *testing.T) {
 			expression:     "1/2",
 			expectedResult: 0.5,
 		},
+		{
+			name:           "unary minus",
+			expression:     "-2*-(1+2)",
+			expectedResult: 6,
+		},
 	}
 
 	// Тесты с ожидаемым успешным результатом
@@ -75,7 +85,7 @@ func TestCalc(t *testing.T) {
 			if err == nil {
 				t.Fatalf("error case %s should return an error", testCase.expression)
 			}
-			if err != testCase.expectedErr {
+			if !errors.Is(err, testCase.expectedErr) {
 				t.Fatalf("expected error %v, got %v", testCase.expectedErr, err)
 			}
 		})
@@ -92,3 +102,492 @@ func TestCalc(t *testing.T) {
 		}
 	})
 }
+
+func TestValidateExpressionWithLimits(t *testing.T) {
+	limits := Limits{MaxBytes: 20, MaxTokens: 9, MaxDepth: 2}
+
+	testCases := []struct {
+		name        string
+		expression  string
+		expectedErr error
+	}{
+		{name: "within limits", expression: "((1+2))*3", expectedErr: nil},
+		{name: "too long", expression: "1+1+1+1+1+1+1+1+1+1+1", expectedErr: ErrExpressionTooLong},
+		{name: "too many tokens", expression: "1+2+3+4+5+6", expectedErr: ErrTooManyTokens},
+		{name: "too deep", expression: "(((1)))", expectedErr: ErrNestingTooDeep},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := ValidateExpressionWithLimits(testCase.expression, limits)
+			if !errors.Is(err, testCase.expectedErr) {
+				t.Fatalf("expected error %v, got %v", testCase.expectedErr, err)
+			}
+
+			var limitErr *LimitError
+			if testCase.expectedErr != nil && !errors.As(err, &limitErr) {
+				t.Fatalf("expected *LimitError, got %T", err)
+			}
+		})
+	}
+}
+
+// TestCheckLimitsTokens проверяет, что токены считаются так же, как их выделяет лексер
+func TestCheckLimitsTokens(t *testing.T) {
+	testCases := []struct {
+		expression string
+		tokens     int
+	}{
+		{"1 == 2 && 3 != 4", 7},
+		{"x <= 10 || y", 5},
+		{"max(a, 2.5)", 6},
+		{"1 > 0 ? 2 : 3", 7},
+		{"1..2 + 3", 3},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			if err := CheckLimits(testCase.expression, Limits{MaxTokens: testCase.tokens}); err != nil {
+				t.Fatalf("expected %d tokens within limit, got %v", testCase.tokens, err)
+			}
+			var limitErr *LimitError
+			err := CheckLimits(testCase.expression, Limits{MaxTokens: testCase.tokens - 1})
+			if !errors.As(err, &limitErr) || limitErr.Actual != testCase.tokens {
+				t.Fatalf("expected ErrTooManyTokens with %d tokens, got %v", testCase.tokens, err)
+			}
+		})
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	type span struct {
+		code       string
+		start, end int
+	}
+
+	testCases := []struct {
+		name       string
+		expression string
+		expected   []span
+	}{
+		{name: "valid", expression: "(1 + 2) * 3.5", expected: nil},
+		{name: "empty", expression: "  ", expected: []span{{DiagEmptyExpression, 0, 0}}},
+		{
+			name:       "several problems",
+			expression: "2 ++ x * (1.2.3",
+			expected: []span{
+				{DiagConsecutiveOperators, 2, 4},
+				{DiagInvalidCharacter, 5, 6},
+				{DiagUnmatchedParenthesis, 9, 10},
+				{DiagMalformedNumber, 10, 15},
+			},
+		},
+		{
+			name:       "empty parentheses and trailing operator",
+			expression: "()+1-",
+			expected: []span{
+				{DiagEmptyParentheses, 0, 2},
+				{DiagTrailingOperator, 4, 5},
+			},
+		},
+		{
+			name:       "unmatched closing and missing operator",
+			expression: "1)2",
+			expected: []span{
+				{DiagUnmatchedParenthesis, 1, 2},
+				{DiagMissingOperator, 2, 3},
+			},
+		},
+		{name: "leading operator", expression: "*2", expected: []span{{DiagMissingOperand, 0, 1}}},
+		{name: "unary minus", expression: "-2*-(3--1)", expected: nil},
+		{name: "ternary", expression: "1 < 2 ? (3 >= 4 || !0) : 5 % 2", expected: nil},
+		{name: "question without colon", expression: "(1 ? 2) + 3", expected: []span{{DiagUnmatchedConditional, 3, 4}}},
+		{name: "colon without question", expression: "1 : 2", expected: []span{{DiagUnmatchedConditional, 2, 3}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			diagnostics := Diagnose(testCase.expression)
+			if len(diagnostics) != len(testCase.expected) {
+				t.Fatalf("expected %d diagnostics, got %d: %+v", len(testCase.expected), len(diagnostics), diagnostics)
+			}
+			for i, diag := range diagnostics {
+				want := testCase.expected[i]
+				if diag.Code != want.code || diag.Start != want.start || diag.End != want.end {
+					t.Errorf("diagnostic %d: expected %s [%d,%d), got %s [%d,%d)",
+						i, want.code, want.start, want.end, diag.Code, diag.Start, diag.End)
+				}
+			}
+		})
+	}
+}
+
+func TestLex(t *testing.T) {
+	tokens, err := Lex("12 + (3.5*x1)")
+	if err != nil {
+		t.Fatalf("Lex returned error: %v", err)
+	}
+
+	expected := []Token{
+		{Type: TokenNumber, Value: "12", Pos: 0},
+		{Type: TokenOperator, Value: "+", Pos: 3},
+		{Type: TokenLParen, Value: "(", Pos: 5},
+		{Type: TokenNumber, Value: "3.5", Pos: 6},
+		{Type: TokenOperator, Value: "*", Pos: 9},
+		{Type: TokenIdentifier, Value: "x1", Pos: 10},
+		{Type: TokenRParen, Value: ")", Pos: 12},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("token %d: expected %+v, got %+v", i, expected[i], tokens[i])
+		}
+	}
+
+	testCasesFail := []struct {
+		expression  string
+		expectedErr error
+		pos         int
+	}{
+		{"1..2", ErrMalformedNumber, 0},
+		{"2 + 3.4.5", ErrMalformedNumber, 4},
+		{".", ErrMalformedNumber, 0},
+		{"1 + 5.", ErrMalformedNumber, 4},
+		{"2 # 3", ErrInvalidCharacter, 2},
+	}
+	for _, testCase := range testCasesFail {
+		t.Run(testCase.expression, func(t *testing.T) {
+			_, err := Lex(testCase.expression)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) || !errors.Is(err, testCase.expectedErr) {
+				t.Fatalf("expected SyntaxError %v, got %v", testCase.expectedErr, err)
+			}
+			if syntaxErr.Pos != testCase.pos {
+				t.Errorf("expected position %d, got %d", testCase.pos, syntaxErr.Pos)
+			}
+		})
+	}
+}
+
+func TestParseGrammar(t *testing.T) {
+	testCases := []struct {
+		expression  string
+		expectedErr error
+		pos         int
+	}{
+		{"1 2 + 3", ErrUnexpectedToken, 2},
+		{"2 (3)", ErrUnexpectedToken, 2},
+		{"(1)(2)", ErrUnexpectedToken, 3},
+		{"2 ++ 3", ErrInsufficientOperands, 3},
+		{"* 2", ErrInsufficientOperands, 0},
+		{"()", ErrInsufficientOperands, 1},
+		{"(1 + 2", ErrMismatchedParentheses, 0},
+		{"1 + 2)", ErrMismatchedParentheses, 5},
+		{"max(1, 2", ErrMismatchedParentheses, 3},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			_, err := Parse(testCase.expression)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) || !errors.Is(err, testCase.expectedErr) {
+				t.Fatalf("expected SyntaxError %v, got %v", testCase.expectedErr, err)
+			}
+			if syntaxErr.Pos != testCase.pos {
+				t.Errorf("expected position %d, got %d", testCase.pos, syntaxErr.Pos)
+			}
+		})
+	}
+}
+
+func TestParseTree(t *testing.T) {
+	tree, err := Parse("2 + 3*-x")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	add, ok := tree.(*BinaryNode)
+	if !ok || add.Op != "+" {
+		t.Fatalf("expected '+' at the root, got %#v", tree)
+	}
+	if add.Span() != (Span{Start: 0, End: 8}) {
+		t.Errorf("expected root span [0,8), got %+v", add.Span())
+	}
+	mul, ok := add.Right.(*BinaryNode)
+	if !ok || mul.Op != "*" {
+		t.Fatalf("expected '*' on the right, got %#v", add.Right)
+	}
+	neg, ok := mul.Right.(*UnaryNode)
+	if !ok || neg.Span() != (Span{Start: 6, End: 8}) {
+		t.Fatalf("expected unary minus at [6,8), got %#v", mul.Right)
+	}
+	if _, ok := neg.Operand.(*VariableNode); !ok {
+		t.Errorf("expected variable operand, got %#v", neg.Operand)
+	}
+
+	call, err := Parse("max(1, 2 * 3)")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if node, ok := call.(*CallNode); !ok || node.Name != "max" || len(node.Args) != 2 {
+		t.Errorf("expected call of max with 2 arguments, got %#v", call)
+	}
+
+	if _, err := Evaluate(tree); !errors.Is(err, ErrUnknownIdentifier) {
+		t.Errorf("expected ErrUnknownIdentifier, got %v", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+	}{
+		{"(2+3)*4", "(2 + 3) * 4"},
+		{"((2))+(3*4)", "2 + 3 * 4"},
+		{"1-(2-3)", "1 - (2 - 3)"},
+		{"(1-2)-3", "1 - 2 - 3"},
+		{"8/(2*2)", "8 / (2 * 2)"},
+		{"-(1+2)*-3", "-(1 + 2) * -3"},
+		{"f(1,(2))", "f(1, 2)"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			tree, err := Parse(testCase.expression)
+			if err != nil {
+				t.Fatalf("Parse returned error: %v", err)
+			}
+			if got := Format(tree); got != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+	}{
+		{"x*1 + 0", "x"},
+		{"1*(a+b)/1", "a + b"},
+		{"0 + y - 0", "y"},
+		{"--z", "z"},
+		{"-(3)", "-3"},
+		{"-(-3)", "3"},
+		{"x*0", "x * 0"},
+		{"2 - x", "2 - x"},
+		{"(2+3)*x", "5 * x"},
+		{"x + 1/0", "x + 1 / 0"},
+		{"x + 2/(1-1)", "x + 2 / (1 - 1)"},
+		{"(1-1) ? x : 2*3", "1 - 1 ? x : 6"},
+		{"(1-1) && 2*3", "1 - 1 && 6"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			tree, err := Parse(testCase.expression)
+			if err != nil {
+				t.Fatalf("Parse returned error: %v", err)
+			}
+			if got := Format(Simplify(tree)); got != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, got)
+			}
+		})
+	}
+
+	// В режиме int двойное отрицание сохраняется, а переполнение не сворачивается
+	intBackend, _ := NewBackend(ModeInt, 0)
+	for expression, expected := range map[string]string{
+		"--z * 1":                        "--z",
+		"0 - 9223372036854775807 - 1":    "-9223372036854775808",
+		"-(0 - 9223372036854775807 - 1)": "-(0 - 9223372036854775807 - 1)",
+		"9223372036854775807 + 1 + x":    "9223372036854775807 + 1 + x",
+		"7 / 2":                          "3",
+	} {
+		tree, err := ParseInMode(expression, ModeInt)
+		if err != nil {
+			t.Fatalf("ParseInMode(%q) returned error: %v", expression, err)
+		}
+		if got := Format(SimplifyWith(tree, intBackend)); got != expected {
+			t.Errorf("%q: expected %q in int mode, got %q", expression, expected, got)
+		}
+	}
+}
+
+func TestEvaluateValue(t *testing.T) {
+	testCases := []struct {
+		mode       Mode
+		scale      int
+		expression string
+		expected   string
+	}{
+		{ModeFloat, 0, "0.1 + 0.2", "0.30000000000000004"},
+		{ModeDecimal, 10, "0.1 + 0.2", "0.3"},
+		{ModeDecimal, 2, "10 / 3", "3.33"},
+		{ModeDecimal, 2, "2 / 3", "0.67"},
+		{ModeDecimal, 2, "-(2 / 3)", "-0.67"},
+		{ModeDecimal, 0, "5 / 2", "3"},
+		{ModeRational, 0, "1 / 3 + 1 / 6", "1/2"},
+		{ModeRational, 0, "(1 / 3) * 3", "1"},
+		{ModeRational, 0, "9007199254740993 + 0", "9007199254740993"},
+		{ModeRational, 0, "0.1 + 0.2", "3/10"},
+		{ModeRational, 0, "7 % (5 / 2)", "2"},
+		{ModeFloat, 0, "7.5 % 2", "1.5"},
+		{ModeInt, 0, "-7 / 2 * 2 + -7 % 2", "-7"},
+		{ModeInt, 0, "-9223372036854775807 - 1", "-9223372036854775808"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(string(testCase.mode)+" "+testCase.expression, func(t *testing.T) {
+			backend, err := NewBackend(testCase.mode, testCase.scale)
+			if err != nil {
+				t.Fatalf("NewBackend returned error: %v", err)
+			}
+			tree, err := Parse(testCase.expression)
+			if err != nil {
+				t.Fatalf("Parse returned error: %v", err)
+			}
+			got, err := EvaluateValue(tree, backend)
+			if err != nil {
+				t.Fatalf("EvaluateValue returned error: %v", err)
+			}
+			if got != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestNumericModeErrors(t *testing.T) {
+	if _, err := ParseMode("complex"); !errors.Is(err, ErrUnknownMode) {
+		t.Errorf("expected ErrUnknownMode, got %v", err)
+	}
+	if mode, err := ParseMode(""); err != nil || mode != ModeFloat {
+		t.Errorf("expected float64 by default, got %q, %v", mode, err)
+	}
+	if _, err := NewBackend(ModeDecimal, MaxDecimalScale+1); !errors.Is(err, ErrInvalidScale) {
+		t.Errorf("expected ErrInvalidScale, got %v", err)
+	}
+
+	backend, _ := NewBackend(ModeRational, 0)
+	if _, err := backend.Apply("/", "1", "0"); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("expected ErrDivisionByZero, got %v", err)
+	}
+	if _, err := backend.Apply("+", "1", "abc"); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("expected ErrInvalidValue, got %v", err)
+	}
+}
+
+func TestIntegerMode(t *testing.T) {
+	if _, err := LexInMode("1 + 2.5", ModeInt); !errors.Is(err, ErrFractionalNumber) {
+		t.Errorf("expected ErrFractionalNumber, got %v", err)
+	}
+	if _, err := LexInMode("1 + 2.5", ModeDecimal); err != nil {
+		t.Errorf("expected fractional numbers outside int mode, got %v", err)
+	}
+
+	backend, _ := NewBackend(ModeInt, 0)
+	overflows := [][3]string{
+		{"+", "9223372036854775807", "1"},
+		{"*", "4294967296", "4294967296"},
+		{"/", "-9223372036854775808", "-1"},
+	}
+	for _, args := range overflows {
+		_, err := backend.Apply(args[0], args[1], args[2])
+		var overflowErr *OverflowError
+		if !errors.As(err, &overflowErr) || !errors.Is(err, ErrIntegerOverflow) {
+			t.Errorf("%s %s %s: expected OverflowError, got %v", args[1], args[0], args[2], err)
+		}
+	}
+	if _, err := backend.Apply("%", "1", "0"); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("expected ErrDivisionByZero, got %v", err)
+	}
+	if _, err := backend.Parse("9223372036854775808"); !errors.Is(err, ErrIntegerOverflow) {
+		t.Errorf("expected ErrIntegerOverflow, got %v", err)
+	}
+}
+
+func TestConditionalOperators(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   float64
+	}{
+		{"1 < 2", 1},
+		{"2 <= 1", 0},
+		{"1 + 1 == 2", 1},
+		{"3 != 3", 0},
+		{"1 < 2 && 2 < 1 || 1", 1},
+		{"!0 && !(2 > 1)", 0},
+		{"1 ? 2 : 3", 2},
+		{"0 ? 2 : 1 ? 3 : 4", 3},
+		{"2 > 1 ? 10 / 2 : 1 / 0", 5},
+		{"(1 ? 2 : 3) * 4", 8},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			got, err := Calc(testCase.expression)
+			if err != nil {
+				t.Fatalf("Calc returned error: %v", err)
+			}
+			if got != testCase.expected {
+				t.Errorf("expected %v, got %v", testCase.expected, got)
+			}
+		})
+	}
+
+	backend, _ := NewBackend(ModeRational, 0)
+	tree, _ := Parse("1/3 + 1/6 == 1/2 ? 1/3 : 0")
+	if got, err := EvaluateValue(tree, backend); err != nil || got != "1/3" {
+		t.Errorf("expected exact comparison to select 1/3, got %s, %v", got, err)
+	}
+	if !IsBoolean(tree.(*TernaryNode).Cond) || IsBoolean(tree) {
+		t.Errorf("expected only the condition to be boolean")
+	}
+
+	tokens, _ := Lex("a>=1&&b!=2")
+	var operators []string
+	for _, token := range tokens {
+		if token.Type == TokenOperator {
+			operators = append(operators, token.Value)
+		}
+	}
+	if len(operators) != 3 || operators[0] != ">=" || operators[1] != "&&" || operators[2] != "!=" {
+		t.Errorf("expected two-character operators, got %v", operators)
+	}
+
+	for _, expression := range []string{"1 ? 2", "1 : 2", "1 ? 2 : 3 : 4"} {
+		if _, err := Parse(expression); !errors.Is(err, ErrUnmatchedConditional) {
+			t.Errorf("%s: expected ErrUnmatchedConditional, got %v", expression, err)
+		}
+	}
+}
+
+// TestSetLogger проверяет, что пакет молчит по умолчанию и пишет подробности
+// в переданный журнал на уровне Debug.
+func TestSetLogger(t *testing.T) {
+	if logger().Enabled(context.Background(), slog.LevelError) {
+		t.Fatal("expected package logger to be disabled by default")
+	}
+
+	var buf bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer SetLogger(nil)
+
+	if _, err := Calc("1+4/0"); !errors.Is(err, ErrDivisionByZero) {
+		t.Fatalf("expected division by zero, got %v", err)
+	}
+	if !strings.Contains(buf.String(), `msg="Division by zero" position=4`) {
+		t.Errorf("expected structured division by zero record, got %q", buf.String())
+	}
+
+	SetLogger(nil)
+	buf.Reset()
+	Calc("1/0")
+	if buf.Len() != 0 {
+		t.Errorf("expected no records after SetLogger(nil), got %q", buf.String())
+	}
+}
@@ -0,0 +1,163 @@
+package calculation
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Коды диагностик
+const (
+	DiagEmptyExpression      = "empty_expression"
+	DiagInvalidCharacter     = "invalid_character"
+	DiagUnmatchedParenthesis = "unmatched_parenthesis"
+	DiagConsecutiveOperators = "consecutive_operators"
+	DiagMissingOperand       = "missing_operand"
+	DiagTrailingOperator     = "trailing_operator"
+	DiagMissingOperator      = "missing_operator"
+	DiagEmptyParentheses     = "empty_parentheses"
+	DiagMalformedNumber      = "malformed_number"
+	DiagUnmatchedConditional = "unmatched_conditional"
+)
+
+// Diagnostic описывает одну проблему в выражении. Start и End — смещения в символах (рунах),
+// End не включается в диапазон.
+type Diagnostic struct {
+	Code    string
+	Message string
+	Start   int
+	End     int
+}
+
+// diagToken — токен, выделяемый при диагностике
+type diagToken struct {
+	kind  byte // 'n' — число, 'o' — оператор (включая ? и :), '(' и ')' — скобки, 'x' — недопустимый символ
+	text  string
+	start int
+	end   int
+}
+
+// Diagnose проверяет выражение целиком и возвращает все найденные проблемы,
+// упорядоченные по позиции. Пустой результат означает, что выражение корректно.
+func Diagnose(expression string) []Diagnostic {
+	var diagnostics []Diagnostic
+	add := func(code, message string, start, end int) {
+		diagnostics = append(diagnostics, Diagnostic{Code: code, Message: message, Start: start, End: end})
+	}
+
+	if strings.TrimSpace(expression) == "" {
+		add(DiagEmptyExpression, "expression is empty", 0, 0)
+		return diagnostics
+	}
+
+	// 1. Разбиваем выражение на токены, отмечая недопустимые символы
+	runes := []rune(expression)
+	var tokens []diagToken
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		switch {
+		case unicode.IsSpace(char):
+		case unicode.IsDigit(char) || char == '.':
+			start := i
+			for i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+			text := string(runes[start : i+1])
+			if !isWellFormedNumber(text) {
+				add(DiagMalformedNumber, "malformed number "+text, start, i+1)
+			}
+			tokens = append(tokens, diagToken{kind: 'n', text: text, start: start, end: i + 1})
+		case matchOperator(runes, i) != "":
+			operator := matchOperator(runes, i)
+			tokens = append(tokens, diagToken{kind: 'o', text: operator, start: i, end: i + len(operator)})
+			i += len(operator) - 1
+		case char == '?' || char == ':':
+			tokens = append(tokens, diagToken{kind: 'o', text: string(char), start: i, end: i + 1})
+		case char == '(' || char == ')':
+			tokens = append(tokens, diagToken{kind: byte(char), text: string(char), start: i, end: i + 1})
+		default:
+			// Недопустимый символ остается в последовательности, чтобы не порождать ложных диагностик рядом с ним
+			add(DiagInvalidCharacter, "invalid character "+string(char), i, i+1)
+			tokens = append(tokens, diagToken{kind: 'x', text: string(char), start: i, end: i + 1})
+		}
+	}
+
+	// 2. Проверяем последовательность токенов
+	var prev *diagToken
+	var openParentheses []diagToken
+	// Незакрытые ? условного оператора на каждом уровне скобок
+	questions := [][]diagToken{nil}
+	for i := range tokens {
+		token := &tokens[i]
+		switch token.kind {
+		case 'n', '(':
+			if prev != nil && (prev.kind == 'n' || prev.kind == ')') {
+				add(DiagMissingOperator, "missing operator before "+token.text, token.start, token.end)
+			}
+			if token.kind == '(' {
+				openParentheses = append(openParentheses, *token)
+				questions = append(questions, nil)
+			}
+		case 'o':
+			level := len(questions) - 1
+			switch token.text {
+			case "?":
+				questions[level] = append(questions[level], *token)
+			case ":":
+				if len(questions[level]) == 0 {
+					add(DiagUnmatchedConditional, "':' without matching '?'", token.start, token.end)
+				} else {
+					questions[level] = questions[level][:len(questions[level])-1]
+				}
+			}
+
+			// Минус и отрицание перед операндом — унарные и допустимы в любом месте, где ожидается операнд
+			if (token.text == "-" || token.text == "!") && (prev == nil || prev.kind == 'o' || prev.kind == '(') {
+				break
+			}
+			if token.text == "!" {
+				add(DiagMissingOperator, "missing operator before "+token.text, token.start, token.end)
+				break
+			}
+			if prev != nil && prev.kind == 'o' {
+				add(DiagConsecutiveOperators, "consecutive operators "+prev.text+token.text, prev.start, token.end)
+			} else if prev == nil || prev.kind == '(' {
+				add(DiagMissingOperand, "missing left operand for "+token.text, token.start, token.end)
+			}
+		case ')':
+			if len(openParentheses) == 0 {
+				add(DiagUnmatchedParenthesis, "unmatched closing parenthesis", token.start, token.end)
+				break
+			}
+			open := openParentheses[len(openParentheses)-1]
+			openParentheses = openParentheses[:len(openParentheses)-1]
+			for _, question := range questions[len(questions)-1] {
+				add(DiagUnmatchedConditional, "'?' without matching ':'", question.start, question.end)
+			}
+			questions = questions[:len(questions)-1]
+			if prev != nil && prev.kind == '(' {
+				add(DiagEmptyParentheses, "empty parentheses", open.start, token.end)
+			} else if prev != nil && prev.kind == 'o' {
+				add(DiagMissingOperand, "missing right operand for "+prev.text, prev.start, prev.end)
+			}
+		}
+		prev = token
+	}
+
+	if prev != nil && prev.kind == 'o' {
+		add(DiagTrailingOperator, "expression ends with operator "+prev.text, prev.start, prev.end)
+	}
+	for _, open := range openParentheses {
+		add(DiagUnmatchedParenthesis, "unclosed parenthesis", open.start, open.end)
+	}
+	for _, level := range questions {
+		for _, question := range level {
+			add(DiagUnmatchedConditional, "'?' without matching ':'", question.start, question.end)
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Start < diagnostics[j].Start
+	})
+	return diagnostics
+}
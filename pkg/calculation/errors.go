@@ -1,6 +1,9 @@
 package calculation
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrInvalidExpression     = errors.New("invalid expression")
@@ -9,4 +12,68 @@ var (
 	ErrInvalidCharacter      = errors.New("invalid character in expression")
 	ErrEmptyExpression       = errors.New("expression is empty")
 	ErrInsufficientOperands  = errors.New("insufficient operands for operation")
+	ErrMalformedNumber       = errors.New("malformed number")
+	ErrUnexpectedToken       = errors.New("unexpected token")
+	ErrUnknownIdentifier     = errors.New("unknown identifier")
+	ErrUnmatchedConditional  = errors.New("conditional operator without matching ? and :")
+
+	// Ошибки превышения ограничений (см. Limits)
+	ErrExpressionTooLong = errors.New("expression is too long")
+	ErrTooManyTokens     = errors.New("expression has too many tokens")
+	ErrNestingTooDeep    = errors.New("parentheses nesting is too deep")
+	ErrTooManyTasks      = errors.New("expression has too many operations")
+
+	// Ошибки числовых режимов (см. Backend)
+	ErrUnknownMode  = errors.New("unknown numeric mode")
+	ErrInvalidScale = errors.New("invalid decimal scale")
+	ErrInvalidValue = errors.New("invalid numeric value")
+
+	// Ошибки целочисленного режима
+	ErrFractionalNumber = errors.New("fractional number in integer mode")
+	ErrIntegerOverflow  = errors.New("integer overflow")
 )
+
+// OverflowError описывает операцию, результат которой не помещается в int64
+type OverflowError struct {
+	Operation string
+	Arg1      string
+	Arg2      string
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("%v: %s %s %s", ErrIntegerOverflow, e.Arg1, e.Operation, e.Arg2)
+}
+
+func (e *OverflowError) Unwrap() error {
+	return ErrIntegerOverflow
+}
+
+// LimitError описывает превышение одного из ограничений Limits
+type LimitError struct {
+	Err    error // Одна из ошибок ErrExpressionTooLong, ErrTooManyTokens, ErrNestingTooDeep, ErrTooManyTasks
+	Limit  int   // Допустимое значение
+	Actual int   // Фактическое значение (для ErrTooManyTasks — первое недопустимое)
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %d exceeds limit %d", e.Err, e.Actual, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// SyntaxError указывает на место ошибки в выражении
+type SyntaxError struct {
+	Err   error  // Одна из ошибок разбора (ErrInvalidCharacter, ErrMismatchedParentheses и т.д.)
+	Pos   int    // Смещение в символах (рунах) от начала выражения
+	Token string // Токен, на котором обнаружена ошибка
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%v at position %d: %q", e.Err, e.Pos, e.Token)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
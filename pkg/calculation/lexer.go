@@ -0,0 +1,159 @@
+package calculation
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenType — тип токена выражения
+type TokenType int
+
+const (
+	TokenNumber     TokenType = iota // Число: 12, 3.5, .5
+	TokenOperator                    // Оператор: + - * / %, сравнения, && || !
+	TokenLParen                      // Открывающая скобка
+	TokenRParen                      // Закрывающая скобка
+	TokenIdentifier                  // Идентификатор: имя переменной или функции
+	TokenComma                       // Запятая между аргументами функции
+	TokenQuestion                    // Знак вопроса условного оператора cond ? a : b
+	TokenColon                       // Двоеточие условного оператора
+)
+
+// operators — операторы выражения; двухсимвольные проверяются раньше односимвольных
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "!"}
+
+// matchOperator возвращает оператор, начинающийся с позиции i, или пустую строку
+func matchOperator(runes []rune, i int) string {
+	for _, operator := range operators {
+		op := []rune(operator)
+		if i+len(op) <= len(runes) && string(runes[i:i+len(op)]) == operator {
+			return operator
+		}
+	}
+	return ""
+}
+
+func (t TokenType) String() string {
+	switch t {
+	case TokenNumber:
+		return "number"
+	case TokenOperator:
+		return "operator"
+	case TokenLParen:
+		return "left parenthesis"
+	case TokenRParen:
+		return "right parenthesis"
+	case TokenIdentifier:
+		return "identifier"
+	case TokenComma:
+		return "comma"
+	case TokenQuestion:
+		return "question mark"
+	case TokenColon:
+		return "colon"
+	default:
+		return "unknown"
+	}
+}
+
+// Token — токен выражения с позицией в исходной строке
+type Token struct {
+	Type  TokenType
+	Value string
+	Pos   int // Смещение в символах (рунах) от начала выражения
+}
+
+// End возвращает позицию символа, следующего за токеном
+func (t Token) End() int {
+	return t.Pos + utf8.RuneCountInString(t.Value)
+}
+
+// Lex разбивает выражение на типизированные токены. Пробелы разделяют токены,
+// некорректные числа (1..2, 3.4.5, .) и недопустимые символы возвращаются как SyntaxError.
+func Lex(expression string) ([]Token, error) {
+	runes := []rune(expression)
+	var tokens []Token
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		token, next, err := nextToken(runes, i)
+		if err != nil {
+			logger().Debug("Invalid token", "value", err.Token, "position", err.Pos, "error", err.Err)
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		i = next
+	}
+
+	return tokens, nil
+}
+
+// nextToken читает токен, начинающийся с позиции i (не пробела), и возвращает позицию
+// следующего за ним символа. При ошибке позиция указывает за некорректный фрагмент.
+func nextToken(runes []rune, i int) (Token, int, *SyntaxError) {
+	if operator := matchOperator(runes, i); operator != "" {
+		return Token{Type: TokenOperator, Value: operator, Pos: i}, i + len(operator), nil
+	}
+
+	char := runes[i]
+	switch {
+	case unicode.IsDigit(char) || char == '.':
+		end := i + 1
+		for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+			end++
+		}
+		text := string(runes[i:end])
+		if !isWellFormedNumber(text) {
+			return Token{}, end, &SyntaxError{Err: ErrMalformedNumber, Pos: i, Token: text}
+		}
+		return Token{Type: TokenNumber, Value: text, Pos: i}, end, nil
+	case char == '?':
+		return Token{Type: TokenQuestion, Value: "?", Pos: i}, i + 1, nil
+	case char == ':':
+		return Token{Type: TokenColon, Value: ":", Pos: i}, i + 1, nil
+	case char == '(':
+		return Token{Type: TokenLParen, Value: "(", Pos: i}, i + 1, nil
+	case char == ')':
+		return Token{Type: TokenRParen, Value: ")", Pos: i}, i + 1, nil
+	case char == ',':
+		return Token{Type: TokenComma, Value: ",", Pos: i}, i + 1, nil
+	case unicode.IsLetter(char) || char == '_':
+		end := i + 1
+		for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_') {
+			end++
+		}
+		return Token{Type: TokenIdentifier, Value: string(runes[i:end]), Pos: i}, end, nil
+	default:
+		return Token{}, i + 1, &SyntaxError{Err: ErrInvalidCharacter, Pos: i, Token: string(char)}
+	}
+}
+
+// LexInMode разбивает выражение на токены с учетом числового режима:
+// в режиме int дробные числа возвращаются как SyntaxError с ErrFractionalNumber
+func LexInMode(expression string, mode Mode) ([]Token, error) {
+	tokens, err := Lex(expression)
+	if err != nil || mode != ModeInt {
+		return tokens, err
+	}
+	for _, token := range tokens {
+		if token.Type == TokenNumber && strings.Contains(token.Value, ".") {
+			logger().Debug("Fractional number in int mode", "value", token.Value, "position", token.Pos)
+			return nil, &SyntaxError{Err: ErrFractionalNumber, Pos: token.Pos, Token: token.Value}
+		}
+	}
+	return tokens, nil
+}
+
+// isWellFormedNumber проверяет запись числа: цифры с не более чем одной точкой,
+// после точки обязательна хотя бы одна цифра (12, 3.5, .5)
+func isWellFormedNumber(text string) bool {
+	intPart, fracPart, hasDot := strings.Cut(text, ".")
+	if !hasDot {
+		return intPart != ""
+	}
+	return fracPart != "" && !strings.Contains(fracPart, ".")
+}
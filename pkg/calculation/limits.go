@@ -0,0 +1,61 @@
+package calculation
+
+import "unicode"
+
+// Limits задает ограничения на размер и сложность выражения. Нулевое значение поля отключает ограничение.
+type Limits struct {
+	MaxBytes  int // Максимальная длина выражения в байтах
+	MaxTokens int // Максимальное количество токенов лексера (чисел, идентификаторов, операторов, скобок)
+	MaxDepth  int // Максимальная глубина вложенности скобок
+	MaxTasks  int // Максимальное количество операций (задач) в выражении
+}
+
+// DefaultLimits — ограничения, применяемые ValidateExpression
+var DefaultLimits = Limits{
+	MaxBytes:  4096,
+	MaxTokens: 1024,
+	MaxDepth:  64,
+	MaxTasks:  1000,
+}
+
+// CheckLimits проверяет длину выражения, количество токенов и глубину вложенности скобок
+func CheckLimits(expression string, limits Limits) error {
+	if limits.MaxBytes > 0 && len(expression) > limits.MaxBytes {
+		logger().Debug("Expression is too long", "bytes", len(expression), "limit", limits.MaxBytes)
+		return &LimitError{Err: ErrExpressionTooLong, Limit: limits.MaxBytes, Actual: len(expression)}
+	}
+
+	// Токены считаются по правилам лексера; некорректный фрагмент считается одним токеном,
+	// а сама ошибка остается синтаксическому разбору
+	runes := []rune(expression)
+	tokens, depth, maxDepth := 0, 0, 0
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		token, next, _ := nextToken(runes, i)
+		tokens++
+		switch token.Type {
+		case TokenLParen:
+			depth++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		case TokenRParen:
+			depth--
+		}
+		i = next
+	}
+
+	if limits.MaxTokens > 0 && tokens > limits.MaxTokens {
+		logger().Debug("Expression has too many tokens", "tokens", tokens, "limit", limits.MaxTokens)
+		return &LimitError{Err: ErrTooManyTokens, Limit: limits.MaxTokens, Actual: tokens}
+	}
+	if limits.MaxDepth > 0 && maxDepth > limits.MaxDepth {
+		logger().Debug("Parentheses nesting is too deep", "depth", maxDepth, "limit", limits.MaxDepth)
+		return &LimitError{Err: ErrNestingTooDeep, Limit: limits.MaxDepth, Actual: maxDepth}
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package calculation
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// currentLogger — журнал пакета. По умолчанию пакет ничего не пишет: библиотека не должна
+// засорять журнал встроившей ее программы.
+var currentLogger atomic.Pointer[slog.Logger]
+
+func init() {
+	currentLogger.Store(slog.New(discardHandler{}))
+}
+
+// SetLogger задает журнал пакета: разбор и вычисление пишут в него подробности
+// на уровне Debug. nil снова отключает журнал.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(discardHandler{})
+	}
+	currentLogger.Store(l)
+}
+
+// logger возвращает текущий журнал пакета
+func logger() *slog.Logger {
+	return currentLogger.Load()
+}
+
+// discardHandler отбрасывает все записи, не форматируя их
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,438 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Mode — числовой режим вычисления выражения
+type Mode string
+
+const (
+	ModeFloat    Mode = "float64"  // Числа с плавающей точкой двойной точности
+	ModeDecimal  Mode = "decimal"  // Десятичные числа с фиксированным количеством знаков после точки
+	ModeRational Mode = "rational" // Точные рациональные дроби (big.Rat)
+	ModeInt      Mode = "int"      // Целые числа int64: деление с отбрасыванием дробной части, контроль переполнения
+)
+
+const (
+	// DefaultDecimalScale — количество знаков после точки в режиме decimal по умолчанию
+	DefaultDecimalScale = 10
+	// MaxDecimalScale — максимальное количество знаков после точки в режиме decimal
+	MaxDecimalScale = 100
+)
+
+// ParseMode разбирает название числового режима; пустая строка означает float64
+func ParseMode(name string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(name)); mode {
+	case "":
+		return ModeFloat, nil
+	case ModeFloat, ModeDecimal, ModeRational, ModeInt:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownMode, name)
+	}
+}
+
+// Backend выполняет арифметику в одном числовом режиме. Значения передаются строками
+// в канонической записи режима, чтобы не терять точность при передаче агентам.
+type Backend interface {
+	// Mode возвращает числовой режим
+	Mode() Mode
+	// Parse переводит запись числа в каноническое значение режима
+	Parse(literal string) (string, error)
+	// Apply выполняет бинарную операцию над значениями. Сравнения и логические
+	// операции возвращают 1 (истина) или 0 (ложь); ненулевое значение считается истинным.
+	Apply(operation, a, b string) (string, error)
+	// Compare сравнивает значения и возвращает -1, 0 или 1
+	Compare(a, b string) (int, error)
+	// Float возвращает приближенное значение для полей float64
+	Float(value string) float64
+	// IsZero сообщает, равно ли значение нулю
+	IsZero(value string) bool
+}
+
+// NewBackend создает арифметику для режима; scale — знаков после точки в режиме decimal
+func NewBackend(mode Mode, scale int) (Backend, error) {
+	switch mode {
+	case "", ModeFloat:
+		return floatBackend{}, nil
+	case ModeDecimal:
+		if scale < 0 || scale > MaxDecimalScale {
+			return nil, fmt.Errorf("%w: %d (allowed 0..%d)", ErrInvalidScale, scale, MaxDecimalScale)
+		}
+		return ratBackend{mode: ModeDecimal, scale: scale}, nil
+	case ModeRational:
+		return ratBackend{mode: ModeRational}, nil
+	case ModeInt:
+		return intBackend{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMode, mode)
+	}
+}
+
+// EvaluateValue вычисляет значение дерева выражения в заданном числовом режиме
+func EvaluateValue(node Node, backend Backend) (string, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		value, err := backend.Parse(n.Value)
+		if err != nil {
+			return "", &SyntaxError{Err: ErrMalformedNumber, Pos: n.Loc.Start, Token: n.Value}
+		}
+		return value, nil
+
+	case *UnaryNode:
+		operand, err := EvaluateValue(n.Operand, backend)
+		if err != nil {
+			return "", err
+		}
+		switch n.Op {
+		case "-":
+			return backend.Apply("-", "0", operand)
+		case "!":
+			return backend.Apply("==", operand, "0")
+		default:
+			return "", &SyntaxError{Err: ErrInvalidExpression, Pos: n.Loc.Start, Token: n.Op}
+		}
+
+	case *TernaryNode:
+		cond, err := EvaluateValue(n.Cond, backend)
+		if err != nil {
+			return "", err
+		}
+		if backend.IsZero(cond) {
+			return EvaluateValue(n.Else, backend)
+		}
+		return EvaluateValue(n.Then, backend)
+
+	case *BinaryNode:
+		a, err := EvaluateValue(n.Left, backend)
+		if err != nil {
+			return "", err
+		}
+		b, err := EvaluateValue(n.Right, backend)
+		if err != nil {
+			return "", err
+		}
+		result, err := backend.Apply(n.Op, a, b)
+		if errors.Is(err, ErrDivisionByZero) {
+			return "", &SyntaxError{Err: ErrDivisionByZero, Pos: n.Right.Span().Start, Token: n.Right.String()}
+		}
+		if errors.Is(err, ErrIntegerOverflow) {
+			return "", &SyntaxError{Err: err, Pos: n.Loc.Start, Token: n.String()}
+		}
+		return result, err
+
+	case *VariableNode:
+		return "", &SyntaxError{Err: ErrUnknownIdentifier, Pos: n.Loc.Start, Token: n.Name}
+
+	case *CallNode:
+		return "", &SyntaxError{Err: ErrUnknownIdentifier, Pos: n.Loc.Start, Token: n.Name}
+
+	default:
+		return "", ErrInvalidExpression
+	}
+}
+
+// applyBoolean выполняет сравнение или логическую операцию через Compare.
+// handled — false, если операция не логическая.
+func applyBoolean(backend Backend, operation, a, b string) (result string, handled bool, err error) {
+	switch operation {
+	case "&&", "||":
+		left, err := backend.Compare(a, "0")
+		if err != nil {
+			return "", true, err
+		}
+		right, err := backend.Compare(b, "0")
+		if err != nil {
+			return "", true, err
+		}
+		if operation == "&&" {
+			return boolValue(left != 0 && right != 0), true, nil
+		}
+		return boolValue(left != 0 || right != 0), true, nil
+	}
+	if !IsComparison(operation) {
+		return "", false, nil
+	}
+
+	cmp, err := backend.Compare(a, b)
+	if err != nil {
+		return "", true, err
+	}
+	switch operation {
+	case "==":
+		return boolValue(cmp == 0), true, nil
+	case "!=":
+		return boolValue(cmp != 0), true, nil
+	case "<":
+		return boolValue(cmp < 0), true, nil
+	case "<=":
+		return boolValue(cmp <= 0), true, nil
+	case ">":
+		return boolValue(cmp > 0), true, nil
+	default:
+		return boolValue(cmp >= 0), true, nil
+	}
+}
+
+// boolValue кодирует логическое значение числом: 1 или 0
+func boolValue(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+// floatBackend — арифметика float64; значения записываются кратчайшим точным представлением
+type floatBackend struct{}
+
+func (floatBackend) Mode() Mode { return ModeFloat }
+
+func (floatBackend) Parse(literal string) (string, error) {
+	value, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidValue, literal)
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64), nil
+}
+
+func (f floatBackend) Apply(operation, a, b string) (string, error) {
+	if result, handled, err := applyBoolean(f, operation, a, b); handled {
+		return result, err
+	}
+
+	arg1, err1 := strconv.ParseFloat(a, 64)
+	arg2, err2 := strconv.ParseFloat(b, 64)
+	if err1 != nil || err2 != nil || math.IsNaN(arg1) || math.IsNaN(arg2) || math.IsInf(arg1, 0) || math.IsInf(arg2, 0) {
+		return "", fmt.Errorf("%w: %q, %q", ErrInvalidValue, a, b)
+	}
+
+	var result float64
+	switch operation {
+	case "+":
+		result = arg1 + arg2
+	case "-":
+		result = arg1 - arg2
+	case "*":
+		result = arg1 * arg2
+	case "/":
+		if arg2 == 0 {
+			return "", ErrDivisionByZero
+		}
+		result = arg1 / arg2
+	case "%":
+		if arg2 == 0 {
+			return "", ErrDivisionByZero
+		}
+		result = math.Mod(arg1, arg2)
+	default:
+		return "", fmt.Errorf("%w: unsupported operation %q", ErrInvalidExpression, operation)
+	}
+	return strconv.FormatFloat(result, 'g', -1, 64), nil
+}
+
+func (floatBackend) Compare(a, b string) (int, error) {
+	arg1, err1 := strconv.ParseFloat(a, 64)
+	arg2, err2 := strconv.ParseFloat(b, 64)
+	if err1 != nil || err2 != nil || math.IsNaN(arg1) || math.IsNaN(arg2) {
+		return 0, fmt.Errorf("%w: %q, %q", ErrInvalidValue, a, b)
+	}
+	switch {
+	case arg1 < arg2:
+		return -1, nil
+	case arg1 > arg2:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (floatBackend) Float(value string) float64 {
+	result, _ := strconv.ParseFloat(value, 64)
+	return result
+}
+
+func (b floatBackend) IsZero(value string) bool {
+	return b.Float(value) == 0
+}
+
+// ratBackend — точная арифметика на big.Rat. В режиме decimal каждое значение
+// округляется до scale знаков после точки (половина — от нуля), в режиме rational деление точное.
+type ratBackend struct {
+	mode  Mode
+	scale int
+}
+
+func (r ratBackend) Mode() Mode { return r.mode }
+
+func (r ratBackend) Parse(literal string) (string, error) {
+	value, ok := new(big.Rat).SetString(literal)
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrInvalidValue, literal)
+	}
+	return r.format(value), nil
+}
+
+func (r ratBackend) Apply(operation, a, b string) (string, error) {
+	if result, handled, err := applyBoolean(r, operation, a, b); handled {
+		return result, err
+	}
+
+	arg1, ok1 := new(big.Rat).SetString(a)
+	arg2, ok2 := new(big.Rat).SetString(b)
+	if !ok1 || !ok2 {
+		return "", fmt.Errorf("%w: %q, %q", ErrInvalidValue, a, b)
+	}
+
+	result := new(big.Rat)
+	switch operation {
+	case "+":
+		result.Add(arg1, arg2)
+	case "-":
+		result.Sub(arg1, arg2)
+	case "*":
+		result.Mul(arg1, arg2)
+	case "/":
+		if arg2.Sign() == 0 {
+			return "", ErrDivisionByZero
+		}
+		result.Quo(arg1, arg2)
+	case "%":
+		// Остаток со знаком делимого: a - b * trunc(a / b)
+		if arg2.Sign() == 0 {
+			return "", ErrDivisionByZero
+		}
+		quotient := new(big.Rat).Quo(arg1, arg2)
+		truncated := new(big.Int).Quo(quotient.Num(), quotient.Denom())
+		result.Sub(arg1, new(big.Rat).Mul(arg2, new(big.Rat).SetInt(truncated)))
+	default:
+		return "", fmt.Errorf("%w: unsupported operation %q", ErrInvalidExpression, operation)
+	}
+	return r.format(result), nil
+}
+
+func (ratBackend) Compare(a, b string) (int, error) {
+	arg1, ok1 := new(big.Rat).SetString(a)
+	arg2, ok2 := new(big.Rat).SetString(b)
+	if !ok1 || !ok2 {
+		return 0, fmt.Errorf("%w: %q, %q", ErrInvalidValue, a, b)
+	}
+	return arg1.Cmp(arg2), nil
+}
+
+func (ratBackend) Float(value string) float64 {
+	parsed, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return 0
+	}
+	result, _ := parsed.Float64()
+	return result
+}
+
+func (ratBackend) IsZero(value string) bool {
+	parsed, ok := new(big.Rat).SetString(value)
+	return ok && parsed.Sign() == 0
+}
+
+// format записывает значение: дробью a/b в режиме rational,
+// десятичной записью без лишних нулей в режиме decimal
+func (r ratBackend) format(value *big.Rat) string {
+	if r.mode == ModeRational {
+		return value.RatString()
+	}
+	text := value.FloatString(r.scale)
+	if strings.Contains(text, ".") {
+		text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	}
+	if text == "-0" {
+		text = "0"
+	}
+	return text
+}
+
+// intBackend — целочисленная арифметика int64. Деление отбрасывает дробную часть,
+// остаток имеет знак делимого, переполнение возвращается как OverflowError.
+type intBackend struct{}
+
+func (intBackend) Mode() Mode { return ModeInt }
+
+func (intBackend) Parse(literal string) (string, error) {
+	value, ok := new(big.Int).SetString(literal, 10)
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrInvalidValue, literal)
+	}
+	if !value.IsInt64() {
+		return "", fmt.Errorf("%w: %s does not fit in int64", ErrIntegerOverflow, literal)
+	}
+	return value.String(), nil
+}
+
+func (i intBackend) Apply(operation, a, b string) (string, error) {
+	if result, handled, err := applyBoolean(i, operation, a, b); handled {
+		return result, err
+	}
+
+	arg1, err1 := strconv.ParseInt(a, 10, 64)
+	arg2, err2 := strconv.ParseInt(b, 10, 64)
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("%w: %q, %q", ErrInvalidValue, a, b)
+	}
+
+	// Вычисляем без ограничения разрядности и проверяем, что результат помещается в int64
+	x, y := big.NewInt(arg1), big.NewInt(arg2)
+	result := new(big.Int)
+	switch operation {
+	case "+":
+		result.Add(x, y)
+	case "-":
+		result.Sub(x, y)
+	case "*":
+		result.Mul(x, y)
+	case "/":
+		if arg2 == 0 {
+			return "", ErrDivisionByZero
+		}
+		result.Quo(x, y)
+	case "%":
+		if arg2 == 0 {
+			return "", ErrDivisionByZero
+		}
+		result.Rem(x, y)
+	default:
+		return "", fmt.Errorf("%w: unsupported operation %q", ErrInvalidExpression, operation)
+	}
+	if !result.IsInt64() {
+		return "", &OverflowError{Operation: operation, Arg1: a, Arg2: b}
+	}
+	return result.String(), nil
+}
+
+func (intBackend) Compare(a, b string) (int, error) {
+	arg1, err1 := strconv.ParseInt(a, 10, 64)
+	arg2, err2 := strconv.ParseInt(b, 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("%w: %q, %q", ErrInvalidValue, a, b)
+	}
+	switch {
+	case arg1 < arg2:
+		return -1, nil
+	case arg1 > arg2:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (intBackend) Float(value string) float64 {
+	result, _ := strconv.ParseInt(value, 10, 64)
+	return float64(result)
+}
+
+func (intBackend) IsZero(value string) bool {
+	return value == "0"
+}
@@ -0,0 +1,147 @@
+package calculation
+
+import (
+	"strconv"
+)
+
+// Simplify упрощает дерево в режиме float, см. SimplifyWith
+func Simplify(node Node) Node {
+	return SimplifyWith(node, floatBackend{})
+}
+
+// SimplifyWith убирает из дерева операции, не меняющие значение: x + 0, 0 + x, x - 0,
+// x * 1, 1 * x, x / 1 и двойное отрицание, и сворачивает подвыражения из одних чисел
+// арифметикой backend. Умножение на ноль не упрощается, чтобы не потерять ошибки и особые
+// значения в x. Подвыражение, которое не удается вычислить (переполнение, деление на ноль),
+// остается несвернутым, чтобы ошибка проявилась так же, как без оптимизации.
+// В режиме int двойное отрицание сохраняется: -(-x) при x = MinInt64 сообщает о переполнении.
+func SimplifyWith(node Node, backend Backend) Node {
+	return simplify(node, backend, true)
+}
+
+// simplify упрощает узел; при fold сворачивает подвыражения из чисел
+func simplify(node Node, backend Backend, fold bool) Node {
+	switch n := node.(type) {
+	case *UnaryNode:
+		operand := simplify(n.Operand, backend, fold)
+		if number, ok := operand.(*NumberNode); ok && fold {
+			if value, ok := foldUnary(backend, n.Op, number.Value); ok {
+				return &NumberNode{Value: value, Loc: n.Loc}
+			}
+			return simplify(n, backend, false)
+		}
+		if inner, ok := operand.(*UnaryNode); ok && n.Op == "-" && inner.Op == "-" && backend.Mode() != ModeInt {
+			if _, literal := inner.Operand.(*NumberNode); !literal {
+				return inner.Operand
+			}
+		}
+		return &UnaryNode{Op: n.Op, Operand: operand, Loc: n.Loc}
+
+	case *BinaryNode:
+		// Левый операнд && и || решает, строить ли правый, поэтому он не сворачивается:
+		// иначе ошибки правого операнда перестали бы проверяться при разборе
+		left := simplify(n.Left, backend, fold && n.Op != "&&" && n.Op != "||")
+		right := simplify(n.Right, backend, fold)
+		leftNumber, leftOK := left.(*NumberNode)
+		rightNumber, rightOK := right.(*NumberNode)
+		if leftOK && rightOK {
+			if !fold {
+				return &BinaryNode{Op: n.Op, Left: left, Right: right, Loc: n.Loc}
+			}
+			if value, ok := foldBinary(backend, n.Op, leftNumber.Value, rightNumber.Value); ok {
+				return &NumberNode{Value: value, Loc: n.Loc}
+			}
+			return simplify(n, backend, false)
+		}
+		switch {
+		case n.Op == "+" && isLiteral(left, 0):
+			return right
+		case (n.Op == "+" || n.Op == "-") && isLiteral(right, 0):
+			return left
+		case n.Op == "*" && isLiteral(left, 1):
+			return right
+		case (n.Op == "*" || n.Op == "/") && isLiteral(right, 1):
+			return left
+		}
+		return &BinaryNode{Op: n.Op, Left: left, Right: right, Loc: n.Loc}
+
+	case *TernaryNode:
+		// Условие не сворачивается по той же причине, что и левый операнд &&
+		return &TernaryNode{Cond: simplify(n.Cond, backend, false), Then: simplify(n.Then, backend, fold), Else: simplify(n.Else, backend, fold), Loc: n.Loc}
+
+	case *CallNode:
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = simplify(arg, backend, fold)
+		}
+		return &CallNode{Name: n.Name, Args: args, Loc: n.Loc}
+
+	default:
+		return node
+	}
+}
+
+// foldUnary вычисляет унарную операцию над числом так же, как builder: -x как 0 - x, !x как x == 0
+func foldUnary(backend Backend, operation, literal string) (string, bool) {
+	value, err := backend.Parse(literal)
+	if err != nil {
+		return "", false
+	}
+	switch operation {
+	case "-":
+		value, err = backend.Apply("-", "0", value)
+	case "!":
+		value, err = backend.Apply("==", value, "0")
+	default:
+		return "", false
+	}
+	return value, err == nil
+}
+
+// foldBinary вычисляет бинарную операцию над двумя числами
+func foldBinary(backend Backend, operation, left, right string) (string, bool) {
+	a, err := backend.Parse(left)
+	if err != nil {
+		return "", false
+	}
+	b, err := backend.Parse(right)
+	if err != nil {
+		return "", false
+	}
+	value, err := backend.Apply(operation, a, b)
+	return value, err == nil
+}
+
+// CountOperations возвращает количество задач, которое потребуется для вычисления дерева:
+// по одной на каждую операцию, кроме минуса перед числом
+func CountOperations(node Node) int {
+	switch n := node.(type) {
+	case *UnaryNode:
+		if _, ok := n.Operand.(*NumberNode); ok {
+			return 0
+		}
+		return 1 + CountOperations(n.Operand)
+	case *BinaryNode:
+		return 1 + CountOperations(n.Left) + CountOperations(n.Right)
+	case *TernaryNode:
+		return 1 + CountOperations(n.Cond) + CountOperations(n.Then) + CountOperations(n.Else)
+	case *CallNode:
+		count := 1
+		for _, arg := range n.Args {
+			count += CountOperations(arg)
+		}
+		return count
+	default:
+		return 0
+	}
+}
+
+// isLiteral проверяет, что узел — число, равное value
+func isLiteral(node Node, value float64) bool {
+	number, ok := node.(*NumberNode)
+	if !ok {
+		return false
+	}
+	parsed, err := strconv.ParseFloat(number.Value, 64)
+	return err == nil && parsed == value
+}
@@ -0,0 +1,248 @@
+package calculation
+
+// binaryPrecedence — приоритеты бинарных операторов (все левоассоциативные)
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3,
+	"!=": 3,
+	"<":  4,
+	"<=": 4,
+	">":  4,
+	">=": 4,
+	"+":  5,
+	"-":  5,
+	"*":  6,
+	"/":  6,
+	"%":  6,
+}
+
+// Parse разбирает выражение в абстрактное синтаксическое дерево
+func Parse(expression string) (Node, error) {
+	tokens, err := Lex(expression)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTokens(tokens)
+}
+
+// ParseInMode разбирает выражение с учетом числового режима (см. LexInMode)
+func ParseInMode(expression string, mode Mode) (Node, error) {
+	tokens, err := LexInMode(expression, mode)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTokens(tokens)
+}
+
+// ParseTokens строит дерево из токенов методом рекурсивного спуска. Числа, стоящие рядом
+// без оператора, операторы без операнда и несбалансированные скобки возвращаются как SyntaxError.
+func ParseTokens(tokens []Token) (Node, error) {
+	if len(tokens) == 0 {
+		return nil, ErrEmptyExpression
+	}
+
+	p := &parser{tokens: tokens}
+	node, err := p.parseTernary()
+	if err != nil {
+		logger().Debug("Failed to parse tokens", "error", err)
+		return nil, err
+	}
+
+	// После полного выражения токенов остаться не должно
+	if token, ok := p.peek(); ok {
+		if token.Type == TokenRParen {
+			return nil, &SyntaxError{Err: ErrMismatchedParentheses, Pos: token.Pos, Token: token.Value}
+		}
+		if token.Type == TokenColon {
+			return nil, &SyntaxError{Err: ErrUnmatchedConditional, Pos: token.Pos, Token: token.Value}
+		}
+		return nil, &SyntaxError{Err: ErrUnexpectedToken, Pos: token.Pos, Token: token.Value}
+	}
+	return node, nil
+}
+
+// parser хранит состояние рекурсивного спуска
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+// peek возвращает текущий токен, не продвигаясь
+func (p *parser) peek() (Token, bool) {
+	if p.pos >= len(p.tokens) {
+		return Token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// missingOperand возвращает ошибку отсутствующего операнда на текущем токене или после последнего
+func (p *parser) missingOperand() error {
+	if token, ok := p.peek(); ok {
+		return &SyntaxError{Err: ErrInsufficientOperands, Pos: token.Pos, Token: token.Value}
+	}
+	last := p.tokens[len(p.tokens)-1]
+	return &SyntaxError{Err: ErrInsufficientOperands, Pos: last.Pos, Token: last.Value}
+}
+
+// parseTernary разбирает условный оператор cond ? a : b (правоассоциативный)
+func (p *parser) parseTernary() (Node, error) {
+	cond, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	question, ok := p.peek()
+	if !ok || question.Type != TokenQuestion {
+		return cond, nil
+	}
+	p.pos++
+
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	colon, ok := p.peek()
+	if !ok || colon.Type != TokenColon {
+		return nil, &SyntaxError{Err: ErrUnmatchedConditional, Pos: question.Pos, Token: question.Value}
+	}
+	p.pos++
+
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return &TernaryNode{
+		Cond: cond,
+		Then: then,
+		Else: otherwise,
+		Loc:  Span{Start: cond.Span().Start, End: otherwise.Span().End},
+	}, nil
+}
+
+// parseBinary разбирает цепочку бинарных операций с приоритетом не ниже minPrec
+func (p *parser) parseBinary(minPrec int) (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		token, ok := p.peek()
+		if !ok || token.Type != TokenOperator {
+			return left, nil
+		}
+		prec := binaryPrecedence[token.Value]
+		if prec < minPrec {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryNode{
+			Op:    token.Value,
+			Left:  left,
+			Right: right,
+			Loc:   Span{Start: left.Span().Start, End: right.Span().End},
+		}
+	}
+}
+
+// parseUnary разбирает унарный минус или логическое отрицание перед операндом
+func (p *parser) parseUnary() (Node, error) {
+	token, ok := p.peek()
+	if ok && token.Type == TokenOperator && (token.Value == "-" || token.Value == "!") {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Op: token.Value, Operand: operand, Loc: Span{Start: token.Pos, End: operand.Span().End}}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary разбирает число, переменную, вызов функции или выражение в скобках
+func (p *parser) parsePrimary() (Node, error) {
+	token, ok := p.peek()
+	if !ok {
+		return nil, p.missingOperand()
+	}
+
+	var node Node
+	switch token.Type {
+	case TokenNumber:
+		p.pos++
+		node = &NumberNode{Value: token.Value, Loc: Span{Start: token.Pos, End: token.End()}}
+
+	case TokenIdentifier:
+		p.pos++
+		if next, ok := p.peek(); ok && next.Type == TokenLParen {
+			call, err := p.parseCall(token)
+			if err != nil {
+				return nil, err
+			}
+			node = call
+		} else {
+			node = &VariableNode{Name: token.Value, Loc: Span{Start: token.Pos, End: token.End()}}
+		}
+
+	case TokenLParen:
+		p.pos++
+		inner, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		closing, ok := p.peek()
+		if !ok || closing.Type != TokenRParen {
+			return nil, &SyntaxError{Err: ErrMismatchedParentheses, Pos: token.Pos, Token: token.Value}
+		}
+		p.pos++
+		node = inner
+
+	default:
+		// Оператор или закрывающая скобка на месте операнда
+		return nil, p.missingOperand()
+	}
+
+	// Операнд не может стоять сразу за другим операндом: "1 2", "2 (3)", "(1)(2)"
+	if next, ok := p.peek(); ok && (next.Type == TokenNumber || next.Type == TokenIdentifier || next.Type == TokenLParen) {
+		return nil, &SyntaxError{Err: ErrUnexpectedToken, Pos: next.Pos, Token: next.Value}
+	}
+	return node, nil
+}
+
+// parseCall разбирает аргументы вызова name(arg, ...); текущий токен — открывающая скобка
+func (p *parser) parseCall(name Token) (Node, error) {
+	open := p.tokens[p.pos]
+	p.pos++
+
+	call := &CallNode{Name: name.Value}
+	if next, ok := p.peek(); ok && next.Type == TokenRParen {
+		p.pos++
+		call.Loc = Span{Start: name.Pos, End: next.End()}
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		next, ok := p.peek()
+		switch {
+		case ok && next.Type == TokenComma:
+			p.pos++
+		case ok && next.Type == TokenRParen:
+			p.pos++
+			call.Loc = Span{Start: name.Pos, End: next.End()}
+			return call, nil
+		default:
+			return nil, &SyntaxError{Err: ErrMismatchedParentheses, Pos: open.Pos, Token: open.Value}
+		}
+	}
+}
@@ -1,46 +1,71 @@
 package calculation
 
 import (
-	"log"
+	"strings"
 	"unicode"
 )
 
+// expressionSymbols — допустимые в выражении символы, кроме цифр и пробелов
+const expressionSymbols = ".+-*/%()<>=!&|?:"
+
+// ValidateExpression проверяет выражение с ограничениями по умолчанию (DefaultLimits)
 func ValidateExpression(expression string) error {
-	log.Printf("ValidateExpression: Validating expression: %s", expression)
+	return ValidateExpressionWithLimits(expression, DefaultLimits)
+}
+
+// ValidateExpressionWithLimits проверяет синтаксис выражения и его размер согласно limits
+func ValidateExpressionWithLimits(expression string, limits Limits) error {
+	logger().Debug("Validating expression", "expression", expression)
 
 	// 1. Проверка на пустое выражение
 	if expression == "" {
-		log.Printf("ValidateExpression: Empty expression")
+		logger().Debug("Empty expression")
 		return ErrEmptyExpression
 	}
 
-	// 2. Проверка на допустимые символы
-	for _, char := range expression {
-		if !unicode.IsDigit(char) && char != '.' && char != '+' && char != '-' &&
-			char != '*' && char != '/' && char != '(' && char != ')' && !unicode.IsSpace(char) {
-			log.Printf("ValidateExpression: Invalid character found: %c", char)
-			return ErrInvalidCharacter
+	// 2. Проверка длины до посимвольного разбора
+	if limits.MaxBytes > 0 && len(expression) > limits.MaxBytes {
+		logger().Debug("Expression is too long", "bytes", len(expression), "limit", limits.MaxBytes)
+		return &LimitError{Err: ErrExpressionTooLong, Limit: limits.MaxBytes, Actual: len(expression)}
+	}
+
+	// 3. Проверка на допустимые символы
+	for pos, char := range []rune(expression) {
+		if !unicode.IsDigit(char) && !strings.ContainsRune(expressionSymbols, char) && !unicode.IsSpace(char) {
+			logger().Debug("Invalid character", "character", string(char), "position", pos)
+			return &SyntaxError{Err: ErrInvalidCharacter, Pos: pos, Token: string(char)}
 		}
 	}
 
-	// 3. Проверка баланса скобок
-	openParentheses := 0
-	for _, char := range expression {
+	// 4. Проверка баланса скобок
+	var openParentheses []int // Позиции незакрытых скобок
+	for pos, char := range []rune(expression) {
 		if char == '(' {
-			openParentheses++
+			openParentheses = append(openParentheses, pos)
 		} else if char == ')' {
-			openParentheses--
-			if openParentheses < 0 {
-				log.Printf("ValidateExpression: Mismatched parentheses")
-				return ErrMismatchedParentheses
+			if len(openParentheses) == 0 {
+				logger().Debug("Mismatched parentheses")
+				return &SyntaxError{Err: ErrMismatchedParentheses, Pos: pos, Token: ")"}
 			}
+			openParentheses = openParentheses[:len(openParentheses)-1]
 		}
 	}
-	if openParentheses != 0 {
-		log.Printf("ValidateExpression: Mismatched parentheses")
-		return ErrMismatchedParentheses
+	if len(openParentheses) != 0 {
+		logger().Debug("Mismatched parentheses")
+		return &SyntaxError{Err: ErrMismatchedParentheses, Pos: openParentheses[len(openParentheses)-1], Token: "("}
+	}
+
+	// 5. Проверка количества токенов и глубины вложенности
+	if err := CheckLimits(expression, limits); err != nil {
+		return err
+	}
+
+	// 6. Проверка записи чисел и грамматики: числа без оператора между ними, лишние операторы
+	if _, err := Parse(expression); err != nil {
+		logger().Debug("Grammar error", "error", err)
+		return err
 	}
 
-	log.Printf("ValidateExpression: Expression is valid")
+	logger().Debug("Expression is valid")
 	return nil
 }